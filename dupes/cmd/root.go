@@ -0,0 +1,295 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+
+	"github.com/MephistoMMM/magician/dupes/finder"
+	"github.com/MephistoMMM/magician/lib"
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var cfgFile string
+
+var (
+	minSize     string
+	keepPolicy  string
+	preferRoots []string
+	actionName  string
+	dryRun      bool
+)
+
+// explainFilters is the value of `--explain-filters`, noExplainedPath if no
+// path is given.
+var explainFilters string
+
+const noExplainedPath = "-"
+
+var (
+	filterTracer *lib.FilterTracer
+	walkErrors   = lib.NewErrorReport()
+	reportOnce   sync.Once
+)
+
+var log = lib.Logger
+
+// rootCmd represents the base command when called without any subcommands
+var rootCmd = &cobra.Command{
+	Use:   "dupes <root>...",
+	Short: "Find files with the same content under roots.",
+	Long: `dupes finds files with the same content under roots. Files are grouped
+by size, then by hash of their leading bytes, and then by hash of their
+content, so that only files possible to be duplicate are read fully.
+
+Duplicate sets are listed by default. With --action, all files of a set but
+the one kept by --keep are replaced with hardlinks or symlinks to it, or
+deleted. Use --dry-run to print what would be done.
+
+Besides --filter, filters of files compared and of directories pruned can
+be declared in config like:
+
+	prune:
+	  - type: dot
+	filters:
+	  - type: expr
+	    args: ['not path("**/node_modules/**")']`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if err := cobra.MinimumNArgs(1)(cmd, args); err != nil {
+			return err
+		}
+
+		for _, root := range args {
+			if !lib.IsDir(root) {
+				return fmt.Errorf("root is not exist or not a directory: %s", root)
+			}
+		}
+
+		return nil
+	},
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if explainFilters == "" {
+			return nil
+		}
+
+		filterTracer = lib.NewFilterTracer(5)
+		if explainFilters != noExplainedPath {
+			if err := filterTracer.Explain(explainFilters); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Run: run,
+}
+
+// Execute adds all child commands to the root command and sets flags appropriately.
+// This is called by main.main(). It only needs to happen once to the rootCmd.
+func Execute() {
+	if err := rootCmd.Execute(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+func init() {
+	cobra.OnInitialize(initConfig)
+
+	rootCmd.Flags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.dupes.yaml)")
+	rootCmd.Flags().String("filter", "", `filter expression of files compared, like 'not path("**/.git/**") and size>1M'`)
+	viper.BindPFlag("filter", rootCmd.Flags().Lookup("filter"))
+	rootCmd.Flags().StringVar(&explainFilters, "explain-filters", "",
+		"print statistics of filters, and which filter decided for the given path")
+	rootCmd.Flags().Lookup("explain-filters").NoOptDefVal = noExplainedPath
+	rootCmd.Flags().String("on-error", lib.ErrorSkipCollect.String(),
+		"what to do when a path fails to be read: collect, log or abort")
+	viper.BindPFlag("on-error", rootCmd.Flags().Lookup("on-error"))
+	rootCmd.Flags().StringVar(&minSize, "min-size", "1", "minimum size of files compared, like 4K")
+	rootCmd.Flags().Int("workers", 1, "number of directories read and files hashed concurrently")
+	viper.BindPFlag("workers", rootCmd.Flags().Lookup("workers"))
+	rootCmd.Flags().StringVar(&keepPolicy, "keep", finder.KeepOldest.String(),
+		"which file of a duplicate set is kept: oldest, shortest or root")
+	rootCmd.Flags().StringSliceVar(&preferRoots, "prefer", nil, "roots preferred by --keep=root, in order")
+	rootCmd.Flags().StringVar(&actionName, "action", finder.ActionList.String(),
+		"what to do with duplicates: list, hardlink, symlink or delete")
+	rootCmd.Flags().BoolVar(&dryRun, "dry-run", false, "print what --action would do without doing it")
+}
+
+// initConfig reads in config file and ENV variables if set.
+func initConfig() {
+	if cfgFile != "" {
+		viper.SetConfigFile(cfgFile)
+	} else {
+		home, err := homedir.Dir()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		// Search config in home directory with name ".dupes" (without extension).
+		viper.AddConfigPath(home)
+		viper.SetConfigName(".dupes")
+	}
+
+	viper.AutomaticEnv()
+
+	// duplicates are listed on stdout
+	if err := viper.ReadInConfig(); err == nil {
+		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
+	}
+}
+
+// reportFilters writes errors skipped during the run, statistics of
+// filters, and decisions for the path given by `--explain-filters`, to
+// stderr once.
+func reportFilters() {
+	reportOnce.Do(func() {
+		walkErrors.WriteTo(os.Stderr)
+		if filterTracer != nil {
+			filterTracer.WriteStats(os.Stderr)
+			filterTracer.WriteExplain(os.Stderr)
+		}
+	})
+}
+
+// iteratorOptions return options of iterating files under root kept by
+// filters declared in config and the filter expression of `--filter`.
+func iteratorOptions(root string, policy lib.ErrorPolicy) (lib.IteratorOptions, error) {
+	opts := lib.IteratorOptions{
+		ErrorPolicy: policy,
+		Report:      walkErrors,
+		Workers:     viper.GetInt("workers"),
+		Tracer:      filterTracer,
+	}
+
+	specs := make([]lib.FilterSpec, 0)
+	if err := viper.UnmarshalKey("filters", &specs); err != nil {
+		return opts, err
+	}
+	if expr := viper.GetString("filter"); expr != "" {
+		specs = append(specs, lib.FilterSpec{Type: "expr", Args: []string{expr}})
+	}
+	filter, err := lib.NewFilterChain(root, specs)
+	if err != nil {
+		return opts, err
+	}
+	opts.Filter = filter
+
+	pruneSpecs := make([]lib.FilterSpec, 0)
+	if err := viper.UnmarshalKey("prune", &pruneSpecs); err != nil {
+		return opts, err
+	}
+	opts.Prune, err = lib.NewFilterChain(root, pruneSpecs)
+	return opts, err
+}
+
+func run(cmd *cobra.Command, args []string) {
+	defer reportFilters()
+	policy, err := lib.ParseErrorPolicy(viper.GetString("on-error"))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	keep, err := finder.ParseKeepPolicy(keepPolicy)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	action, err := finder.ParseAction(actionName)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	size, err := lib.ParseSize(minSize)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	preferred := make([]string, 0, len(preferRoots))
+	for _, root := range preferRoots {
+		abs, err := filepath.Abs(root)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		preferred = append(preferred, abs)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		<-interrupts
+		log.Warnln("interrupted, stopping")
+		cancel()
+		signal.Stop(interrupts)
+	}()
+
+	f := &finder.Finder{
+		Options: func(root string) (lib.IteratorOptions, error) {
+			return iteratorOptions(root, policy)
+		},
+		MinSize:     size,
+		Workers:     viper.GetInt("workers"),
+		ErrorPolicy: policy,
+		Report:      walkErrors,
+	}
+	sets, err := f.Find(ctx, args...)
+	if err != nil {
+		reportFilters()
+		log.Fatalln(err)
+	}
+
+	var wasted int64
+	for _, set := range sets {
+		wasted += set.Wasted()
+		fmt.Printf("%d files of %s, sha256 %s\n", len(set.Files), lib.FormatSize(set.Size), set.Hash)
+		if action == finder.ActionList {
+			for _, file := range set.Files {
+				fmt.Printf("\t%s\n", file.Path)
+			}
+			fmt.Println()
+			continue
+		}
+
+		kept := set.Keep(keep, preferred)
+		fmt.Printf("\tkeep %s\n", kept.Path)
+		for _, file := range set.Files {
+			if file == kept {
+				continue
+			}
+			fmt.Printf("\t%s %s\n", action, file.Path)
+			if dryRun {
+				continue
+			}
+			if err := finder.Apply(nil, action, kept, file); err != nil {
+				if err := policy.Handle(&lib.PathError{Op: action.String(), Path: file.Path, Err: err}, walkErrors); err != nil {
+					reportFilters()
+					log.Fatalln(err)
+				}
+			}
+		}
+		fmt.Println()
+	}
+	fmt.Printf("%d duplicate sets, %s wasted\n", len(sets), lib.FormatSize(wasted))
+}
@@ -0,0 +1,132 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package finder
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/MephistoMMM/magician/lib"
+)
+
+// Action is what to do with duplicates of the kept file.
+type Action int
+
+const (
+	// ActionList only lists duplicates.
+	ActionList Action = iota
+	// ActionHardlink replaces duplicates with hardlinks to the kept file.
+	ActionHardlink
+	// ActionSymlink replaces duplicates with relative symlinks to the kept
+	// file.
+	ActionSymlink
+	// ActionDelete deletes duplicates.
+	ActionDelete
+)
+
+var actionNames = map[Action]string{
+	ActionList:     "list",
+	ActionHardlink: "hardlink",
+	ActionSymlink:  "symlink",
+	ActionDelete:   "delete",
+}
+
+func (a Action) String() string {
+	return actionNames[a]
+}
+
+// ParseAction parses name of action, which is one of list, hardlink,
+// symlink and delete.
+func ParseAction(name string) (Action, error) {
+	for action, n := range actionNames {
+		if n == name {
+			return action, nil
+		}
+	}
+	return ActionList, fmt.Errorf("unknown action: %s", name)
+}
+
+var errChanged = errors.New("file changed since it was hashed")
+
+// Apply applies action to dup, a duplicate of keep in fsys. Both files are
+// checked to be unchanged since they were found, and dup is replaced by
+// renaming a link created beside it, so that it is never lost if linking
+// fails.
+func Apply(fsys lib.FileSystem, action Action, keep, dup *File) error {
+	if action == ActionList {
+		return nil
+	}
+	if fsys == nil {
+		fsys = lib.OSFS
+	}
+	for _, file := range []*File{keep, dup} {
+		if err := unchanged(fsys, file); err != nil {
+			return err
+		}
+	}
+
+	switch action {
+	case ActionDelete:
+		return fsys.Remove(dup.Abs)
+	case ActionHardlink:
+		return replace(fsys, dup.Abs, func(tmp string) error {
+			return fsys.Link(keep.Abs, tmp)
+		})
+	case ActionSymlink:
+		target, err := filepath.Rel(filepath.Dir(dup.Abs), keep.Abs)
+		if err != nil {
+			target = keep.Abs
+		}
+		return replace(fsys, dup.Abs, func(tmp string) error {
+			return fsys.Symlink(target, tmp)
+		})
+	}
+	return fmt.Errorf("unknown action: %d", action)
+}
+
+// replace replaces path with the file created by create at a temporary
+// path in the same directory.
+func replace(fsys lib.FileSystem, path string, create func(tmp string) error) error {
+	tmp := filepath.Join(filepath.Dir(path),
+		fmt.Sprintf(".%s.%d.dupes", filepath.Base(path), time.Now().UnixNano()))
+	if err := create(tmp); err != nil {
+		return err
+	}
+	if err := fsys.Rename(tmp, path); err != nil {
+		fsys.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// unchanged return error if file is changed since it was found.
+func unchanged(fsys lib.FileSystem, file *File) error {
+	info, err := fsys.Lstat(file.Abs)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() || info.Size() != file.Info.Size() || !info.ModTime().Equal(file.Info.ModTime()) {
+		return &os.PathError{Op: "check", Path: file.Path, Err: errChanged}
+	}
+	return nil
+}
@@ -0,0 +1,280 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package finder
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/MephistoMMM/magician/lib"
+	"github.com/MephistoMMM/magician/lib/concurrent"
+)
+
+// DefaultPartialSize is the number of leading bytes hashed to split files of
+// the same size before hashing them fully.
+const DefaultPartialSize = 4096
+
+// File is a regular file found under a root.
+type File struct {
+	lib.FileEntry
+	// Root is the root the file is found under.
+	Root string
+}
+
+// DuplicateSet is files with the same content, ordered by path.
+type DuplicateSet struct {
+	Size int64
+	// Hash is the hex encoded sha256 of content.
+	Hash  string
+	Files []*File
+}
+
+// Wasted return the number of bytes freed by keeping only one file of set.
+func (ds *DuplicateSet) Wasted() int64 {
+	return ds.Size * int64(len(ds.Files)-1)
+}
+
+// Finder finds files with the same content under roots.
+type Finder struct {
+	// FS is the file system of roots, OS if it is nil.
+	FS lib.FileSystem
+	// Options return options to walk root, default options are used if it
+	// is nil. The FS of options is replaced by FS of Finder.
+	Options func(root string) (lib.IteratorOptions, error)
+	// MinSize is the minimum size of files compared.
+	MinSize int64
+	// PartialSize is the number of leading bytes hashed before full hashes,
+	// DefaultPartialSize if it is not positive.
+	PartialSize int64
+	// Workers is the number of files hashed concurrently, 1 if it is not
+	// positive.
+	Workers int
+	// ErrorPolicy decides what to do when a file fails to be hashed, and
+	// Report collects errors under ErrorSkipCollect.
+	ErrorPolicy lib.ErrorPolicy
+	Report      *lib.ErrorReport
+}
+
+// fs return the file system of finder.
+func (f *Finder) fs() lib.FileSystem {
+	if f.FS == nil {
+		return lib.OSFS
+	}
+	return f.FS
+}
+
+// partialSize return the number of bytes hashed by partial hashes.
+func (f *Finder) partialSize() int64 {
+	if f.PartialSize <= 0 {
+		return DefaultPartialSize
+	}
+	return f.PartialSize
+}
+
+// Find return sets of duplicate files under roots, the largest sets in
+// wasted bytes first. Files are grouped by size, then by hash of leading
+// bytes, and then by hash of content, so that only files possible to be
+// duplicate are read fully. Hardlinks of a file are counted once.
+func (f *Finder) Find(ctx context.Context, roots ...string) ([]*DuplicateSet, error) {
+	files, err := f.walk(ctx, roots)
+	if err != nil {
+		return nil, err
+	}
+
+	bySize := make(map[int64][]*File)
+	for _, file := range files {
+		size := file.Info.Size()
+		bySize[size] = append(bySize[size], file)
+	}
+	sets := make([]*DuplicateSet, 0)
+	for size, group := range bySize {
+		if len(group) > 1 {
+			sets = append(sets, &DuplicateSet{Size: size, Files: group})
+		}
+	}
+
+	partial := f.partialSize()
+	if sets, err = f.regroup(ctx, sets, func(file *File) (string, error) {
+		return hashHead(f.fs(), file.Path, partial)
+	}); err != nil {
+		return nil, err
+	}
+	// hashes of leading bytes are already full hashes of small files
+	small := make([]*DuplicateSet, 0, len(sets))
+	large := make([]*DuplicateSet, 0, len(sets))
+	for _, set := range sets {
+		if set.Size <= partial {
+			small = append(small, set)
+		} else {
+			large = append(large, set)
+		}
+	}
+	if large, err = f.regroup(ctx, large, func(file *File) (string, error) {
+		return lib.HashFileFS(f.fs(), file.Path)
+	}); err != nil {
+		return nil, err
+	}
+	sets = append(small, large...)
+
+	for _, set := range sets {
+		sort.Slice(set.Files, func(i, j int) bool {
+			return set.Files[i].Path < set.Files[j].Path
+		})
+	}
+	sort.Slice(sets, func(i, j int) bool {
+		if sets[i].Wasted() != sets[j].Wasted() {
+			return sets[i].Wasted() > sets[j].Wasted()
+		}
+		return sets[i].Files[0].Path < sets[j].Files[0].Path
+	})
+	return sets, nil
+}
+
+// walk return regular files not smaller than MinSize under roots, a file
+// found twice, by overlapping roots or hardlinks, is returned once.
+func (f *Finder) walk(ctx context.Context, roots []string) ([]*File, error) {
+	type inode struct{ dev, ino uint64 }
+	seenInodes := make(map[inode]bool)
+	seenPaths := make(map[string]bool)
+
+	files := make([]*File, 0)
+	for _, root := range roots {
+		var opts lib.IteratorOptions
+		if f.Options != nil {
+			var err error
+			if opts, err = f.Options(root); err != nil {
+				return nil, err
+			}
+		}
+		opts.FS = f.fs()
+
+		iterator, err := lib.NewEntryIterator(ctx, root, opts)
+		if err != nil {
+			return nil, err
+		}
+		for iterator.HasNext() {
+			entry, err := iterator.NextEntry()
+			if err != nil {
+				return nil, err
+			}
+			if !entry.Info.Mode().IsRegular() || entry.Info.Size() < f.MinSize {
+				continue
+			}
+
+			if entry.Ino != 0 {
+				id := inode{entry.Dev, entry.Ino}
+				if seenInodes[id] {
+					continue
+				}
+				seenInodes[id] = true
+			}
+			if seenPaths[entry.Abs] {
+				continue
+			}
+			seenPaths[entry.Abs] = true
+
+			files = append(files, &File{FileEntry: entry, Root: root})
+		}
+	}
+	return files, nil
+}
+
+// regroup splits sets by hash of files, sets with less than two files are
+// dropped. Files failed to be hashed are handled by ErrorPolicy.
+func (f *Finder) regroup(ctx context.Context, sets []*DuplicateSet, hash func(*File) (string, error)) ([]*DuplicateSet, error) {
+	hashes := make(map[*File]string)
+	var (
+		mu       sync.Mutex
+		firstErr error
+	)
+	workers := f.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	swg := concurrent.New(workers)
+	for _, set := range sets {
+		for _, file := range set.Files {
+			if err := swg.AddWithContext(ctx); err != nil {
+				break
+			}
+			go func(file *File) {
+				defer swg.Done()
+				sum, err := hash(file)
+
+				mu.Lock()
+				defer mu.Unlock()
+				if err == nil {
+					hashes[file] = sum
+				} else if err := f.ErrorPolicy.Handle(
+					&lib.PathError{Op: "hash", Path: file.Path, Err: err}, f.Report); err != nil && firstErr == nil {
+					firstErr = err
+				}
+			}(file)
+		}
+	}
+	swg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	regrouped := make([]*DuplicateSet, 0, len(sets))
+	for _, set := range sets {
+		byHash := make(map[string][]*File)
+		order := make([]string, 0)
+		for _, file := range set.Files {
+			sum, ok := hashes[file]
+			if !ok {
+				continue
+			}
+			if _, ok := byHash[sum]; !ok {
+				order = append(order, sum)
+			}
+			byHash[sum] = append(byHash[sum], file)
+		}
+		for _, sum := range order {
+			if len(byHash[sum]) > 1 {
+				regrouped = append(regrouped, &DuplicateSet{Size: set.Size, Hash: sum, Files: byHash[sum]})
+			}
+		}
+	}
+	return regrouped, nil
+}
+
+// hashHead return the hex encoded sha256 of the first n bytes of file.
+func hashHead(fsys lib.FileSystem, path string, n int64) (string, error) {
+	file, err := fsys.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, io.LimitReader(file, n)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,222 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package finder
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MephistoMMM/magician/lib"
+)
+
+// dupesTestTree return a MemFileSystem with duplicates under /a and /b.
+func dupesTestTree(t *testing.T) *lib.MemFileSystem {
+	head := strings.Repeat("x", 16)
+	mfs, err := lib.NewMemFileSystemWithFiles(map[string]string{
+		"/a/one.txt":        "same",
+		"/a/sub/one.txt":    "same",
+		"/b/one-copy.txt":   "same",
+		"/a/long.txt":       head + "tail",
+		"/b/long.txt":       head + "tail",
+		"/b/long-other.txt": head + "TAIL",
+		"/b/other.txt":      "diff",
+		"/b/empty.txt":      "",
+		"/a/empty.txt":      "",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	mtimes := map[string]int{
+		"/a/one.txt": 3, "/a/sub/one.txt": 1, "/b/one-copy.txt": 2,
+		"/a/long.txt": 2, "/b/long.txt": 1,
+	}
+	for path, hours := range mtimes {
+		mtime := base.Add(time.Duration(hours) * time.Hour)
+		mfs.Chtimes(path, mtime, mtime)
+	}
+	return mfs
+}
+
+// formatSets return paths of sets, sets are separated by '|'.
+func formatSets(sets []*DuplicateSet) string {
+	groups := make([]string, 0, len(sets))
+	for _, set := range sets {
+		paths := make([]string, 0, len(set.Files))
+		for _, file := range set.Files {
+			paths = append(paths, file.Path)
+		}
+		groups = append(groups, strings.Join(paths, " "))
+	}
+	return strings.Join(groups, " | ")
+}
+
+func TestFind(t *testing.T) {
+	mfs := dupesTestTree(t)
+	finder := &Finder{FS: mfs, MinSize: 1, PartialSize: 8, Workers: 2}
+
+	sets, err := finder.Find(context.Background(), "/a", "/b", "/a/sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "/a/long.txt /b/long.txt | /a/one.txt /a/sub/one.txt /b/one-copy.txt"
+	if got := formatSets(sets); got != expected {
+		t.Errorf("Duplicate sets are error:\n%s\nexpected:\n%s", got, expected)
+	}
+	sum := sha256.Sum256([]byte("same"))
+	if sets[1].Hash != hex.EncodeToString(sum[:]) || sets[1].Wasted() != 8 {
+		t.Errorf("Set is error: %s, %d", sets[1].Hash, sets[1].Wasted())
+	}
+
+	finder.MinSize = 0
+	finder.Options = func(root string) (lib.IteratorOptions, error) {
+		filter, err := lib.CompileFilterExpression(`regexp("one|empty")`, root)
+		return lib.IteratorOptions{Filter: filter}, err
+	}
+	sets, err = finder.Find(context.Background(), "/a", "/b")
+	expected = "/a/one.txt /a/sub/one.txt /b/one-copy.txt | /a/empty.txt /b/empty.txt"
+	if got := formatSets(sets); err != nil || got != expected {
+		t.Errorf("Duplicate sets with filter are error: %s, %v", got, err)
+	}
+}
+
+func TestFindHardlinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dupes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	lib.WriteFile(filepath.Join(dir, "a.txt"), []byte("same"))
+	os.Link(filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt"))
+	finder := &Finder{MinSize: 1}
+	sets, err := finder.Find(context.Background(), dir)
+	if err != nil || len(sets) != 0 {
+		t.Errorf("Hardlinks should not be duplicates: %s, %v", formatSets(sets), err)
+	}
+
+	lib.WriteFile(filepath.Join(dir, "c.txt"), []byte("same"))
+	if sets, _ = finder.Find(context.Background(), dir); len(sets) != 1 || len(sets[0].Files) != 2 {
+		t.Errorf("Hardlinks should be counted once: %s", formatSets(sets))
+	}
+}
+
+func TestKeep(t *testing.T) {
+	mfs := dupesTestTree(t)
+	sets, err := (&Finder{FS: mfs, MinSize: 1}).Find(context.Background(), "/a", "/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := sets[1]
+
+	tests := []struct {
+		policy    KeepPolicy
+		preferred []string
+		expected  string
+	}{
+		{KeepOldest, nil, "/a/sub/one.txt"},
+		{KeepShortest, nil, "/a/one.txt"},
+		{KeepRoot, []string{"/b"}, "/b/one-copy.txt"},
+		{KeepRoot, []string{"/c", "/a"}, "/a/sub/one.txt"},
+		{KeepRoot, []string{"/c"}, "/a/sub/one.txt"},
+	}
+	for _, test := range tests {
+		if got := set.Keep(test.policy, test.preferred); got.Path != test.expected {
+			t.Errorf("Keep %s %v is error: %s, expected %s", test.policy, test.preferred, got.Path, test.expected)
+		}
+	}
+	if _, err := ParseKeepPolicy("newest"); err == nil {
+		t.Error("Parsing unknown keep policy should fail.")
+	}
+}
+
+func TestApply(t *testing.T) {
+	mfs := dupesTestTree(t)
+	sets, err := (&Finder{FS: mfs, MinSize: 1}).Find(context.Background(), "/a", "/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := sets[1]
+	keep := set.Keep(KeepOldest, nil)
+	files := map[string]*File{}
+	for _, file := range set.Files {
+		files[file.Path] = file
+	}
+
+	if err := Apply(mfs, ActionHardlink, keep, files["/a/one.txt"]); err != nil {
+		t.Fatal(err)
+	}
+	if err := Apply(mfs, ActionSymlink, keep, files["/b/one-copy.txt"]); err != nil {
+		t.Fatal(err)
+	}
+	if err := lib.WriteFileFS(mfs, keep.Path, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/a/one.txt", "/b/one-copy.txt"} {
+		if data, err := lib.ReadFileFS(mfs, path); err != nil || string(data) != "new" {
+			t.Errorf("%s should be linked to kept file: %q, %v", path, data, err)
+		}
+	}
+	if target, _ := mfs.Readlink("/b/one-copy.txt"); target != "../a/sub/one.txt" {
+		t.Errorf("Symlink should be relative: %s", target)
+	}
+	if dir, err := mfs.Open("/b"); err == nil {
+		infos, _ := dir.Readdir(0)
+		for _, info := range infos {
+			if strings.HasSuffix(info.Name(), ".dupes") {
+				t.Errorf("Temporary file is left: %s", info.Name())
+			}
+		}
+	}
+
+	set = sets[0]
+	keep = set.Keep(KeepOldest, nil)
+	for _, file := range set.Files {
+		if file != keep {
+			lib.WriteFileFS(mfs, file.Path, []byte("changed"))
+			if err := Apply(mfs, ActionDelete, keep, file); err == nil {
+				t.Errorf("Changed file should not be deleted: %s", file.Path)
+			}
+		}
+	}
+
+	mfs = dupesTestTree(t)
+	sets, _ = (&Finder{FS: mfs, MinSize: 1}).Find(context.Background(), "/a", "/b")
+	set = sets[1]
+	keep = set.Keep(KeepShortest, nil)
+	for _, file := range set.Files {
+		if file != keep {
+			if err := Apply(mfs, ActionDelete, keep, file); err != nil {
+				t.Error(err)
+			}
+		}
+	}
+	if !lib.IsNotExistFS(mfs, "/a/sub/one.txt") || !lib.IsNotExistFS(mfs, "/b/one-copy.txt") || lib.IsNotExistFS(mfs, keep.Path) {
+		t.Errorf("Duplicates should be deleted except %s", keep.Path)
+	}
+}
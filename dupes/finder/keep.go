@@ -0,0 +1,98 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package finder
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// KeepPolicy decides which file of a duplicate set is kept.
+type KeepPolicy int
+
+const (
+	// KeepOldest keeps the file modified earliest.
+	KeepOldest KeepPolicy = iota
+	// KeepShortest keeps the file with the shortest path.
+	KeepShortest
+	// KeepRoot keeps the file under the first preferred root, the oldest
+	// file is kept if no file is under preferred roots.
+	KeepRoot
+)
+
+var keepPolicyNames = map[KeepPolicy]string{
+	KeepOldest:   "oldest",
+	KeepShortest: "shortest",
+	KeepRoot:     "root",
+}
+
+func (kp KeepPolicy) String() string {
+	return keepPolicyNames[kp]
+}
+
+// ParseKeepPolicy parses name of policy, which is one of oldest, shortest
+// and root.
+func ParseKeepPolicy(name string) (KeepPolicy, error) {
+	for policy, n := range keepPolicyNames {
+		if n == name {
+			return policy, nil
+		}
+	}
+	return KeepOldest, fmt.Errorf("unknown keep policy: %s", name)
+}
+
+// Keep return the file of set kept by policy, preferred is the absolute
+// roots preferred by KeepRoot in order. Ties are broken by the shorter path
+// and then by path, so that the same file is kept in every run.
+func (ds *DuplicateSet) Keep(policy KeepPolicy, preferred []string) *File {
+	files := append([]*File(nil), ds.Files...)
+	sort.SliceStable(files, func(i, j int) bool {
+		a, b := files[i], files[j]
+		if policy == KeepRoot {
+			if ra, rb := rootRank(a, preferred), rootRank(b, preferred); ra != rb {
+				return ra < rb
+			}
+		}
+		if policy != KeepShortest {
+			if ta, tb := a.Info.ModTime(), b.Info.ModTime(); !ta.Equal(tb) {
+				return ta.Before(tb)
+			}
+		}
+		if len(a.Path) != len(b.Path) {
+			return len(a.Path) < len(b.Path)
+		}
+		return a.Path < b.Path
+	})
+	return files[0]
+}
+
+// rootRank return the index of the first root in roots containing file, or
+// len(roots) if no root contains it.
+func rootRank(file *File, roots []string) int {
+	for i, root := range roots {
+		rel, err := filepath.Rel(root, file.Abs)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return i
+		}
+	}
+	return len(roots)
+}
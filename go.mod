@@ -5,7 +5,7 @@ require (
 	github.com/fatih/gomodifytags v1.6.0 // indirect
 	github.com/fatih/structtag v1.2.0 // indirect
 	github.com/keegancsmith/rpc v1.2.0 // indirect
-	github.com/mattn/go-runewidth v0.0.9 // indirect
+	github.com/mattn/go-runewidth v0.0.9
 	github.com/mitchellh/go-homedir v1.0.0
 	github.com/motemen/go-quickfix v0.0.0-20200118031250-2a6e54e79a50 // indirect
 	github.com/motemen/gore v0.5.0 // indirect
@@ -16,6 +16,7 @@ require (
 	github.com/stamblerre/gocode v1.0.0 // indirect
 	github.com/stretchr/testify v1.3.0
 	golang.org/x/net v0.0.0-20200707034311-ab3426394381 // indirect
+	golang.org/x/sys v0.0.0-20200323222414-85ca7c5b95cd
 	golang.org/x/text v0.3.3 // indirect
 	golang.org/x/tools v0.0.0-20200806234136-990129eca547 // indirect
 	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
@@ -0,0 +1,586 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package lib
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ArchiveSeparator separates the path of an archive and the path inside
+// it, like `notes.zip!/inner/a.org`.
+const ArchiveSeparator = "!"
+
+// maxCachedArchives is the number of archive indexes kept by an
+// ArchiveFileSystem.
+const maxCachedArchives = 8
+
+// archiveFormat indexes entries of an archive into a MemFileSystem, whose
+// files read their contents lazily from the archive.
+type archiveFormat struct {
+	exts  []string
+	index func(h *archiveHandle, mfs *MemFileSystem) error
+}
+
+var archiveFormats = []archiveFormat{
+	{[]string{".zip", ".jar"}, indexZip},
+	{[]string{".tar"}, func(h *archiveHandle, mfs *MemFileSystem) error {
+		return indexTar(h, nil, mfs)
+	}},
+	{[]string{".tar.gz", ".tgz"}, func(h *archiveHandle, mfs *MemFileSystem) error {
+		return indexTar(h, func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		}, mfs)
+	}},
+	{[]string{".tar.bz2", ".tbz2"}, func(h *archiveHandle, mfs *MemFileSystem) error {
+		return indexTar(h, func(r io.Reader) (io.ReadCloser, error) {
+			return ioutil.NopCloser(bzip2.NewReader(r)), nil
+		}, mfs)
+	}},
+}
+
+// archiveFormatOf return the format of archive by its name, or nil if it
+// is not an archive.
+func archiveFormatOf(name string) *archiveFormat {
+	name = strings.ToLower(name)
+	for i := range archiveFormats {
+		for _, ext := range archiveFormats[i].exts {
+			if strings.HasSuffix(name, ext) {
+				return &archiveFormats[i]
+			}
+		}
+	}
+	return nil
+}
+
+// IsArchiveName checks if name is the name of a supported archive, which
+// is one of zip, jar, tar, tar.gz, tgz, tar.bz2 and tbz2.
+func IsArchiveName(name string) bool {
+	return archiveFormatOf(name) != nil
+}
+
+// archiveHandle keeps an archive open while its index is cached or its
+// files are read, it is closed when the last reference is released.
+type archiveHandle struct {
+	io.ReaderAt
+	size   int64
+	closer io.Closer
+
+	mu   sync.Mutex
+	refs int
+}
+
+// openArchiveHandle opens archive in fsys, archives not supporting
+// io.ReaderAt, like archives nested in other archives, are read into
+// memory. The returned handle holds a reference.
+func openArchiveHandle(fsys FileSystem, archive string) (*archiveHandle, error) {
+	f, err := fsys.Open(archive)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if ra, ok := f.(io.ReaderAt); ok {
+		return &archiveHandle{ReaderAt: ra, size: info.Size(), closer: f, refs: 1}, nil
+	}
+
+	data, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	return &archiveHandle{
+		ReaderAt: bytes.NewReader(data),
+		size:     int64(len(data)),
+		closer:   closerFunc(func() error { return nil }),
+		refs:     1,
+	}, nil
+}
+
+// acquire adds a reference to h, it return false if h is closed.
+func (h *archiveHandle) acquire() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.refs == 0 {
+		return false
+	}
+	h.refs++
+	return true
+}
+
+// release removes a reference of h, and closes h with the last one.
+func (h *archiveHandle) release() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.refs--; h.refs == 0 {
+		return h.closer.Close()
+	}
+	return nil
+}
+
+// lazy return an open function of MemFileSystem file, which holds a
+// reference of h until the returned reader is closed.
+func (h *archiveHandle) lazy(open func() (io.ReadCloser, error)) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		if !h.acquire() {
+			return nil, os.ErrClosed
+		}
+		rc, err := open()
+		if err != nil {
+			h.release()
+			return nil, err
+		}
+		return &archiveEntryReader{Reader: rc, closers: []io.Closer{rc, closerFunc(h.release)}}, nil
+	}
+}
+
+// closerFunc makes a function an io.Closer.
+type closerFunc func() error
+
+func (fn closerFunc) Close() error { return fn() }
+
+// archiveEntryReader reads an entry of archive, closers are closed in
+// order when it is closed.
+type archiveEntryReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (r *archiveEntryReader) Close() error {
+	var err error
+	for _, closer := range r.closers {
+		if cerr := closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// indexZip indexes zip archive into mfs.
+func indexZip(h *archiveHandle, mfs *MemFileSystem) error {
+	r, err := zip.NewReader(h, h.size)
+	if err != nil {
+		return err
+	}
+	for _, f := range r.File {
+		name := filepath.FromSlash(f.Name)
+		if !f.FileInfo().IsDir() {
+			err = mfs.addLazyFile(name, f.Mode(), f.Modified, int64(f.UncompressedSize64), h.lazy(f.Open))
+		} else if err = mfs.MkdirAll(name, 0755); err == nil {
+			mfs.Chmod(name, f.Mode())
+			mfs.Chtimes(name, f.Modified, f.Modified)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexTar indexes tar archive into mfs, decompress is nil if the tar is
+// not compressed. Files of uncompressed tar are read at their offsets, and
+// files of compressed tar are read by decompressing the archive again up
+// to them.
+func indexTar(h *archiveHandle, decompress func(io.Reader) (io.ReadCloser, error), mfs *MemFileSystem) error {
+	sr := io.NewSectionReader(h, 0, h.size)
+	var r io.Reader = sr
+	if decompress != nil {
+		rc, err := decompress(sr)
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		r = rc
+	}
+
+	tr := tar.NewReader(r)
+	for entry := 0; ; entry++ {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := filepath.FromSlash(header.Name)
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err = mfs.MkdirAll(name, 0755); err == nil {
+				mfs.Chmod(name, os.FileMode(header.Mode))
+				mfs.Chtimes(name, header.ModTime, header.ModTime)
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			var open func() (io.ReadCloser, error)
+			if decompress == nil {
+				// tar.Reader seeks over contents, so sr is at the content
+				offset, _ := sr.Seek(0, io.SeekCurrent)
+				open = openTarSection(h, offset, header.Size)
+			} else {
+				open = openTarEntry(h, decompress, entry)
+			}
+			err = mfs.addLazyFile(name, os.FileMode(header.Mode), header.ModTime, header.Size, h.lazy(open))
+		case tar.TypeSymlink:
+			if err = mfs.MkdirAll(filepath.Dir(name), 0755); err == nil {
+				err = mfs.Symlink(header.Linkname, name)
+			}
+		default:
+			// hard links and special files are skipped
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// openTarSection return a function reading size bytes at offset of an
+// uncompressed tar.
+func openTarSection(h *archiveHandle, offset, size int64) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(io.NewSectionReader(h, offset, size)), nil
+	}
+}
+
+// openTarEntry return a function reading the content of the entry-th
+// header of a compressed tar.
+func openTarEntry(h *archiveHandle, decompress func(io.Reader) (io.ReadCloser, error), entry int) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		rc, err := decompress(io.NewSectionReader(h, 0, h.size))
+		if err != nil {
+			return nil, err
+		}
+		tr := tar.NewReader(rc)
+		for i := 0; i <= entry; i++ {
+			if _, err := tr.Next(); err != nil {
+				rc.Close()
+				if err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				return nil, err
+			}
+		}
+		return &archiveEntryReader{Reader: tr, closers: []io.Closer{rc}}, nil
+	}
+}
+
+// archiveDirInfo makes an archive look like a directory named with
+// ArchiveSeparator suffix.
+type archiveDirInfo struct {
+	os.FileInfo
+}
+
+func (adi archiveDirInfo) Name() string      { return adi.FileInfo.Name() + ArchiveSeparator }
+func (adi archiveDirInfo) Mode() os.FileMode { return os.ModeDir | adi.FileInfo.Mode().Perm() }
+func (adi archiveDirInfo) IsDir() bool       { return true }
+
+// ArchiveFileSystem is a read only view of archives in base FileSystem as
+// directories, a path inside an archive is like `notes.zip!/inner/a.org`.
+// Archives can be nested. Entries of archives are indexed when they are
+// opened, and contents of files are read from archives when the files are
+// opened. Archives nested in other archives are read into memory. Paths
+// outside archives are passed to base.
+type ArchiveFileSystem struct {
+	base FileSystem
+
+	mu       sync.Mutex
+	archives map[string]*cachedArchive
+	order    []string
+}
+
+// cachedArchive is the index of an archive, size and modTime are of the
+// archive when it is indexed, the index is dropped if they change.
+type cachedArchive struct {
+	mfs     *MemFileSystem
+	handle  *archiveHandle
+	size    int64
+	modTime time.Time
+}
+
+// NewArchiveFileSystem create an ArchiveFileSystem over base.
+func NewArchiveFileSystem(base FileSystem) *ArchiveFileSystem {
+	return &ArchiveFileSystem{
+		base:     fileSystemOrOS(base),
+		archives: make(map[string]*cachedArchive),
+		order:    make([]string, 0),
+	}
+}
+
+// split splits name to the path of the innermost archive and the path
+// inside it, ok is false if name is not inside an archive.
+func (afs *ArchiveFileSystem) split(name string) (archive, inner string, ok bool) {
+	for end := len(name); end > 0; {
+		i := strings.LastIndex(name[:end], ArchiveSeparator)
+		if i < 0 {
+			break
+		}
+		end = i
+		rest := name[i+len(ArchiveSeparator):]
+		if rest != "" && !os.IsPathSeparator(rest[0]) {
+			continue
+		}
+		if IsArchiveName(name[:i]) && IsFileFS(afs, name[:i]) {
+			return name[:i], filepath.Clean(string(filepath.Separator) + rest), true
+		}
+	}
+	return "", "", false
+}
+
+// Contains checks if name is inside an archive.
+func (afs *ArchiveFileSystem) Contains(name string) bool {
+	_, _, ok := afs.split(name)
+	return ok
+}
+
+// open return the cached index of archive, archive is indexed again if it
+// is changed after being cached.
+func (afs *ArchiveFileSystem) open(archive string) (*cachedArchive, error) {
+	info, err := afs.Stat(archive)
+	if err != nil {
+		return nil, err
+	}
+
+	afs.mu.Lock()
+	cached, ok := afs.archives[archive]
+	if ok && (cached.size != info.Size() || !cached.modTime.Equal(info.ModTime())) {
+		afs.evict(archive)
+		ok = false
+	}
+	afs.mu.Unlock()
+	if ok {
+		return cached, nil
+	}
+
+	handle, err := openArchiveHandle(afs, archive)
+	if err != nil {
+		return nil, err
+	}
+	cached = &cachedArchive{
+		mfs:     NewMemFileSystem(),
+		handle:  handle,
+		size:    info.Size(),
+		modTime: info.ModTime(),
+	}
+	if err := archiveFormatOf(archive).index(handle, cached.mfs); err != nil {
+		handle.release()
+		return nil, &os.PathError{Op: "open archive", Path: archive, Err: err}
+	}
+
+	afs.mu.Lock()
+	defer afs.mu.Unlock()
+	if existing, ok := afs.archives[archive]; ok {
+		handle.release()
+		return existing, nil
+	}
+	if len(afs.order) >= maxCachedArchives {
+		afs.evict(afs.order[0])
+	}
+	afs.archives[archive] = cached
+	afs.order = append(afs.order, archive)
+	return cached, nil
+}
+
+// evict drops the index of archive, files opened from it could be read
+// until they are closed. It should be called with lock held.
+func (afs *ArchiveFileSystem) evict(archive string) {
+	cached, ok := afs.archives[archive]
+	if !ok {
+		return
+	}
+	delete(afs.archives, archive)
+	for i, name := range afs.order {
+		if name == archive {
+			afs.order = append(afs.order[:i], afs.order[i+1:]...)
+			break
+		}
+	}
+	cached.handle.release()
+}
+
+// resolve return the cached archive and the inner path of name, cached is
+// nil if name is not inside an archive.
+func (afs *ArchiveFileSystem) resolve(name string) (cached *cachedArchive, archive, inner string, err error) {
+	archive, inner, ok := afs.split(name)
+	if !ok {
+		return nil, "", "", nil
+	}
+	cached, err = afs.open(archive)
+	return cached, archive, inner, err
+}
+
+// readOnly return the error of writing to name inside an archive.
+func readOnly(op, name string) error {
+	return &os.PathError{Op: op, Path: name, Err: os.ErrPermission}
+}
+
+// stat return the info of name, the root of an archive looks like a
+// directory.
+func (afs *ArchiveFileSystem) stat(name string, follow bool) (os.FileInfo, error) {
+	cached, archive, inner, err := afs.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	if cached == nil {
+		if follow {
+			return afs.base.Stat(name)
+		}
+		return afs.base.Lstat(name)
+	}
+
+	if inner == string(filepath.Separator) {
+		info, err := afs.Stat(archive)
+		if err != nil {
+			return nil, err
+		}
+		return archiveDirInfo{info}, nil
+	}
+	if follow {
+		return cached.mfs.Stat(inner)
+	}
+	return cached.mfs.Lstat(inner)
+}
+
+// Open ...
+func (afs *ArchiveFileSystem) Open(name string) (File, error) {
+	return afs.OpenFile(name, os.O_RDONLY, 0)
+}
+
+// OpenFile ...
+func (afs *ArchiveFileSystem) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if _, _, ok := afs.split(name); !ok {
+		return afs.base.OpenFile(name, flag, perm)
+	}
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, readOnly("open", name)
+	}
+
+	for {
+		cached, _, inner, err := afs.resolve(name)
+		if err != nil {
+			return nil, err
+		}
+		// the archive is kept open until the file holds its own reference,
+		// or it is evicted meanwhile and indexed again
+		if !cached.handle.acquire() {
+			continue
+		}
+		f, err := cached.mfs.OpenFile(inner, flag, perm)
+		cached.handle.release()
+		if err != nil {
+			return nil, err
+		}
+		f.(*memFile).name = name
+		return f, nil
+	}
+}
+
+// Stat ...
+func (afs *ArchiveFileSystem) Stat(name string) (os.FileInfo, error) {
+	return afs.stat(name, true)
+}
+
+// Lstat ...
+func (afs *ArchiveFileSystem) Lstat(name string) (os.FileInfo, error) {
+	return afs.stat(name, false)
+}
+
+// MkdirAll ...
+func (afs *ArchiveFileSystem) MkdirAll(path string, perm os.FileMode) error {
+	if _, _, ok := afs.split(path); ok {
+		return readOnly("mkdir", path)
+	}
+	return afs.base.MkdirAll(path, perm)
+}
+
+// Remove ...
+func (afs *ArchiveFileSystem) Remove(name string) error {
+	if _, _, ok := afs.split(name); ok {
+		return readOnly("remove", name)
+	}
+	return afs.base.Remove(name)
+}
+
+// Rename ...
+func (afs *ArchiveFileSystem) Rename(oldpath, newpath string) error {
+	for _, path := range []string{oldpath, newpath} {
+		if _, _, ok := afs.split(path); ok {
+			return readOnly("rename", path)
+		}
+	}
+	return afs.base.Rename(oldpath, newpath)
+}
+
+// Symlink ...
+func (afs *ArchiveFileSystem) Symlink(oldname, newname string) error {
+	if _, _, ok := afs.split(newname); ok {
+		return readOnly("symlink", newname)
+	}
+	return afs.base.Symlink(oldname, newname)
+}
+
+// Link ...
+func (afs *ArchiveFileSystem) Link(oldname, newname string) error {
+	for _, path := range []string{oldname, newname} {
+		if _, _, ok := afs.split(path); ok {
+			return readOnly("link", path)
+		}
+	}
+	return afs.base.Link(oldname, newname)
+}
+
+// Readlink ...
+func (afs *ArchiveFileSystem) Readlink(name string) (string, error) {
+	cached, _, inner, err := afs.resolve(name)
+	if err != nil {
+		return "", err
+	}
+	if cached == nil {
+		return afs.base.Readlink(name)
+	}
+	return cached.mfs.Readlink(inner)
+}
+
+// Chmod ...
+func (afs *ArchiveFileSystem) Chmod(name string, mode os.FileMode) error {
+	if _, _, ok := afs.split(name); ok {
+		return readOnly("chmod", name)
+	}
+	return afs.base.Chmod(name, mode)
+}
+
+// Chtimes ...
+func (afs *ArchiveFileSystem) Chtimes(name string, atime, mtime time.Time) error {
+	if _, _, ok := afs.split(name); ok {
+		return readOnly("chtimes", name)
+	}
+	return afs.base.Chtimes(name, atime, mtime)
+}
@@ -0,0 +1,264 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package lib
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// makeZip return a zip archive of files, keys of files ending with `/` are
+// directories.
+func makeZip(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, content := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(content))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// makeTar return a tar archive of files with a symlink `link.org` to
+// `c.org`.
+func makeTar(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, content := range files {
+		header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(content))
+	}
+	tw.WriteHeader(&tar.Header{Name: "link.org", Linkname: "c.org", Typeflag: tar.TypeSymlink})
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// makeTarGz return a gzipped tar archive like makeTar.
+func makeTarGz(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	gw.Write(makeTar(t, files))
+	gw.Close()
+	return buf.Bytes()
+}
+
+func TestArchiveIterator(t *testing.T) {
+	inner := makeZip(t, map[string]string{"d.org": "* d"})
+	root := makeTestTree(t, map[string]string{
+		"a.org": "* a",
+		"notes.zip": string(makeZip(t, map[string]string{
+			"x.org":         "* x",
+			"sub/":          "",
+			"sub/y.org":     "* y\n[[file:y.png]]\n",
+			"sub/y.png":     "",
+			".hidden/z.org": "",
+		})),
+		"b.tar.gz": string(makeTarGz(t, map[string]string{
+			"c.org":     "* c",
+			"inner.zip": string(inner),
+		})),
+		"broken.zip": "not a zip",
+	})
+	defer os.RemoveAll(root)
+
+	filter, _ := NewFilterRegexpMatchSupport(`\.org$`)
+	report := NewErrorReport()
+	opts := IteratorOptions{
+		Prune:       defaultFilterChain(),
+		Filter:      filter,
+		Archives:    true,
+		ErrorPolicy: ErrorSkipCollect,
+		Report:      report,
+	}
+	iterator, err := NewFileIteratorWithOptions(root, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"a.org",
+		"b.tar.gz!/c.org",
+		"b.tar.gz!/link.org",
+		"notes.zip!/x.org",
+		"b.tar.gz!/inner.zip!/d.org",
+		"notes.zip!/sub/y.org",
+	}
+	result := make([]string, 0)
+	for _, file := range iterateAll(t, iterator) {
+		rel, _ := filepath.Rel(root, file)
+		result = append(result, filepath.ToSlash(rel))
+	}
+	if strings.Join(result, " ") != strings.Join(expected, " ") {
+		t.Errorf("Iterate result is error: %v", result)
+	}
+	if errs := report.Errors(); len(errs) != 1 || !strings.Contains(errs[0].Error(), "broken.zip") {
+		t.Errorf("Broken archive should be reported: %v", errs)
+	}
+
+	// archives are files without Archives option
+	opts.Archives = false
+	iterator, _ = NewFileIteratorWithOptions(root, opts)
+	if result := iterateAll(t, iterator); len(result) != 1 {
+		t.Errorf("Archives should not be walked: %v", result)
+	}
+}
+
+// orgHeadlineParser collects headlines.
+type orgHeadlineParser struct {
+	path string
+}
+
+func (ohp *orgHeadlineParser) FilePath() string {
+	return ohp.path
+}
+
+func (ohp *orgHeadlineParser) Parse(line string) (interface{}, error) {
+	if strings.HasPrefix(line, "* ") {
+		return line, nil
+	}
+	return nil, nil
+}
+
+func TestArchiveFileSystem(t *testing.T) {
+	root := makeTestTree(t, map[string]string{
+		"b.tar.gz": string(makeTarGz(t, map[string]string{
+			"c.org":     "* c\ntext\n",
+			"inner.zip": string(makeZip(t, map[string]string{"d.org": "* d"})),
+		})),
+	})
+	defer os.RemoveAll(root)
+
+	archive := filepath.Join(root, "b.tar.gz")
+	afs := NewArchiveFileSystem(nil)
+	lines, err := ScanLinesFS(afs, &orgHeadlineParser{path: archive + "!/c.org"})
+	if err != nil || len(lines) != 1 || lines[0] != "* c" {
+		t.Errorf("Scan file in archive is error: %v, %v", lines, err)
+	}
+	lines, err = ScanLinesFS(afs, &orgHeadlineParser{path: archive + "!/inner.zip!/d.org"})
+	if err != nil || len(lines) != 1 {
+		t.Errorf("Scan file in nested archive is error: %v, %v", lines, err)
+	}
+	if _, err := ScanLines(&orgHeadlineParser{path: archive + "!/c.org"}); err == nil {
+		t.Error("ScanLines should not read files in archives.")
+	}
+
+	// archive members are not written as directories named like archives
+	if err := WriteFile(archive+"!/c.org", []byte("* changed")); !os.IsPermission(err) {
+		t.Errorf("Writing file in archive should fail: %v", err)
+	}
+	if !IsFile(archive) || !afs.Contains(archive+"!/inner.zip!/d.org") || afs.Contains(archive) {
+		t.Error("Archive is changed by WriteFile.")
+	}
+	if err := WriteFile(filepath.Join(root, "notes.zip!", "a.org"), nil); err != nil {
+		t.Errorf("Directory named like archive should be written: %v", err)
+	}
+
+	if info, err := afs.Stat(archive + "!"); err != nil || !info.IsDir() || info.Name() != "b.tar.gz!" {
+		t.Errorf("Stat of archive root is error: %v, %v", info, err)
+	}
+	if info, err := afs.Lstat(archive + "!/link.org"); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("Lstat of symlink in archive is error: %v, %v", info, err)
+	}
+	if data, err := ReadFileFS(afs, archive+"!/link.org"); err != nil || !strings.HasPrefix(string(data), "* c") {
+		t.Errorf("Read symlink in archive is error: %q, %v", data, err)
+	}
+	if err := WriteFileFS(afs, archive+"!/new.org", nil); !os.IsPermission(err) {
+		t.Errorf("Archives should be read only: %v", err)
+	}
+	if err := CopyFileFS(afs, archive+"!/c.org", filepath.Join(root, "c.org")); err != nil {
+		t.Error(err)
+	}
+	if !IsFile(filepath.Join(root, "c.org")) || !IsFileFS(afs, archive) || IsNotExistFS(afs, archive+"!/c.org") {
+		t.Error("File types are error.")
+	}
+}
+
+func TestArchiveFileSystemLazy(t *testing.T) {
+	files := map[string]string{
+		"c.org":     "* c\n",
+		"big.txt":   strings.Repeat("0123456789", 1000),
+		"sub/e.org": "* e\n",
+	}
+	root := makeTestTree(t, map[string]string{
+		"a.tar":    string(makeTar(t, files)),
+		"b.tar.gz": string(makeTarGz(t, files)),
+		"c.zip":    string(makeZip(t, files)),
+	})
+	defer os.RemoveAll(root)
+
+	afs := NewArchiveFileSystem(nil)
+	for _, archive := range []string{"a.tar", "b.tar.gz", "c.zip"} {
+		for name, content := range files {
+			path := filepath.Join(root, archive) + "!/" + name
+			if data, err := ReadFileFS(afs, path); err != nil || string(data) != content {
+				t.Errorf("Read %s is error: %.10q, %v", path, data, err)
+			}
+			if info, err := afs.Stat(path); err != nil || info.Size() != int64(len(content)) {
+				t.Errorf("Stat %s is error: %v, %v", path, info, err)
+			}
+		}
+	}
+
+	// files opened before evicting archive are still readable
+	archive := filepath.Join(root, "a.tar")
+	f, err := afs.Open(archive + "!/big.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	afs.mu.Lock()
+	afs.evict(archive)
+	afs.mu.Unlock()
+	if data, err := ioutil.ReadAll(f); err != nil || string(data) != files["big.txt"] {
+		t.Errorf("Read file of evicted archive is error: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Error(err)
+	}
+
+	// changed archives are indexed again
+	archive = filepath.Join(root, "c.zip")
+	if err := WriteFile(archive, makeZip(t, map[string]string{"c.org": "* changed\n"})); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ReadFileFS(afs, archive+"!/c.org"); err != nil || string(data) != "* changed\n" {
+		t.Errorf("Changed archive is not indexed again: %q, %v", data, err)
+	}
+	if !IsNotExistFS(afs, archive+"!/big.txt") {
+		t.Error("Files of old archive should not exist.")
+	}
+}
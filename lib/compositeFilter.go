@@ -0,0 +1,247 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package lib
+
+import (
+	"errors"
+	"os"
+	"strings"
+)
+
+// Composite filters build predicate trees from other filters. Each operand
+// is a whole chain, and an operand is said to include a path if no filter of
+// the chain ignores it, which is what `Filter` returns. A composite filter
+// ignores a path if it does not include the path, so composites can be
+// operands of other composites and can be linked by SetNext like any other
+// filter.
+
+// ErrNoOperand is returned when a composite filter is created without
+// operands.
+var ErrNoOperand = errors.New("composite filter needs at least one operand")
+
+// includes checks if chain includes path. Unlike `Filter`, it neither
+// calls Done nor Fail, the composite filter reports the final result.
+// Decisions of operands are recorded by tracer to be explained under the
+// composite filter.
+func includes(chain FilterSupport, path string, info os.FileInfo, tracer *FilterTracer) (bool, error) {
+	if tracer != nil {
+		defer tracer.enterOperands(path)()
+	}
+	for ; chain != nil; chain = chain.Next() {
+		ignored, err := chain.IsIgnore(path, info)
+		if tracer != nil {
+			tracer.recordOperand(chain, path, ignored, err)
+		}
+		if err != nil {
+			return false, err
+		}
+		if ignored {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// operandsString describes operands joined by op.
+func operandsString(op string, operands []FilterSupport) string {
+	strs := make([]string, 0, len(operands))
+	for _, operand := range operands {
+		strs = append(strs, operand.String())
+	}
+	return "(" + strings.Join(strs, op) + ")"
+}
+
+// AndSupport includes paths included by all operands.
+type AndSupport struct {
+	BaseSupport
+
+	operands []FilterSupport
+	tracer   *FilterTracer
+}
+
+// NewFilterAndSupport create a AndSupport.
+func NewFilterAndSupport(operands ...FilterSupport) (FilterSupport, error) {
+	if len(operands) == 0 {
+		return nil, ErrNoOperand
+	}
+
+	is := &AndSupport{operands: operands}
+	is.SetName(is.Name())
+	return is, nil
+}
+
+// IsIgnore ...
+func (as *AndSupport) IsIgnore(path string, info os.FileInfo) (bool, error) {
+	for _, operand := range as.operands {
+		included, err := includes(operand, path, info, as.tracer)
+		if err != nil || !included {
+			return true, err
+		}
+	}
+	return false, nil
+}
+
+// SetFileSystem sets fsys to the filter and its operands.
+func (as *AndSupport) SetFileSystem(fsys FileSystem) {
+	as.BaseSupport.SetFileSystem(fsys)
+	for _, operand := range as.operands {
+		SetChainFileSystem(operand, fsys)
+	}
+}
+
+// SetFilterTracer sets ft to record decisions of operands.
+func (as *AndSupport) SetFilterTracer(ft *FilterTracer) {
+	as.tracer = ft
+	for _, operand := range as.operands {
+		SetChainFilterTracer(operand, ft)
+	}
+}
+
+// Name ...
+func (as *AndSupport) Name() string {
+	return operandsString(" and ", as.operands)
+}
+
+// String ...
+func (as *AndSupport) String() string {
+	return chainString(as.Name(), as.Next())
+}
+
+// OrSupport includes paths included by any operand.
+type OrSupport struct {
+	BaseSupport
+
+	operands []FilterSupport
+	tracer   *FilterTracer
+}
+
+// NewFilterOrSupport create a OrSupport.
+func NewFilterOrSupport(operands ...FilterSupport) (FilterSupport, error) {
+	if len(operands) == 0 {
+		return nil, ErrNoOperand
+	}
+
+	is := &OrSupport{operands: operands}
+	is.SetName(is.Name())
+	return is, nil
+}
+
+// IsIgnore ignores path if no operand includes it, the first error of
+// operands is returned only if path is ignored.
+func (ors *OrSupport) IsIgnore(path string, info os.FileInfo) (bool, error) {
+	var firstErr error
+	for _, operand := range ors.operands {
+		included, err := includes(operand, path, info, ors.tracer)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if included {
+			return false, nil
+		}
+	}
+	return true, firstErr
+}
+
+// SetFileSystem sets fsys to the filter and its operands.
+func (ors *OrSupport) SetFileSystem(fsys FileSystem) {
+	ors.BaseSupport.SetFileSystem(fsys)
+	for _, operand := range ors.operands {
+		SetChainFileSystem(operand, fsys)
+	}
+}
+
+// SetFilterTracer sets ft to record decisions of operands.
+func (ors *OrSupport) SetFilterTracer(ft *FilterTracer) {
+	ors.tracer = ft
+	for _, operand := range ors.operands {
+		SetChainFilterTracer(operand, ft)
+	}
+}
+
+// Name ...
+func (ors *OrSupport) Name() string {
+	return operandsString(" or ", ors.operands)
+}
+
+// String ...
+func (ors *OrSupport) String() string {
+	return chainString(ors.Name(), ors.Next())
+}
+
+// NotSupport includes paths not included by operand.
+type NotSupport struct {
+	BaseSupport
+
+	operand FilterSupport
+	tracer  *FilterTracer
+}
+
+// NewFilterNotSupport create a NotSupport.
+func NewFilterNotSupport(operand FilterSupport) (FilterSupport, error) {
+	if operand == nil {
+		return nil, ErrNoOperand
+	}
+
+	is := &NotSupport{operand: operand}
+	is.SetName(is.Name())
+	return is, nil
+}
+
+// IsIgnore ...
+func (ns *NotSupport) IsIgnore(path string, info os.FileInfo) (bool, error) {
+	included, err := includes(ns.operand, path, info, ns.tracer)
+	if err != nil {
+		return true, err
+	}
+	return included, nil
+}
+
+// SetFileSystem sets fsys to the filter and its operand.
+func (ns *NotSupport) SetFileSystem(fsys FileSystem) {
+	ns.BaseSupport.SetFileSystem(fsys)
+	SetChainFileSystem(ns.operand, fsys)
+}
+
+// SetFilterTracer sets ft to record decisions of operand.
+func (ns *NotSupport) SetFilterTracer(ft *FilterTracer) {
+	ns.tracer = ft
+	SetChainFilterTracer(ns.operand, ft)
+}
+
+// Name ...
+func (ns *NotSupport) Name() string {
+	operand := ns.operand.String()
+	switch {
+	case ns.operand.Next() != nil:
+		return "not (" + operand + ")"
+	case strings.HasPrefix(operand, "not "):
+		// double negation is dropped
+		return strings.TrimPrefix(operand, "not ")
+	}
+	return "not " + operand
+}
+
+// String ...
+func (ns *NotSupport) String() string {
+	return chainString(ns.Name(), ns.Next())
+}
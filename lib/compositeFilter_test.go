@@ -0,0 +1,122 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package lib
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+// errorSupport fails on every path.
+type errorSupport struct {
+	BaseSupport
+}
+
+// IsIgnore ...
+func (es *errorSupport) IsIgnore(path string, info os.FileInfo) (bool, error) {
+	return false, errors.New("error support")
+}
+
+func TestCompositeSupports(t *testing.T) {
+	root := makeTestTree(t, map[string]string{
+		"a.org":       "",
+		"b.md":        "",
+		"c.txt":       "",
+		".hidden.org": "",
+		"sub/d.org":   "",
+		"sub/e.md":    "",
+		"sub/f.go":    "",
+	})
+	defer os.RemoveAll(root)
+
+	org, _ := NewFilterRegexpMatchSupport(`\.org$`)
+	md, _ := NewFilterRegexpMatchSupport(`\.md$`)
+	dir, _ := NewFilterGlobMatchSupport(root, "sub")
+	or, err := NewFilterOrSupport(org, md, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dot, _ := NewFilterIgnoreDotSupport()
+	or.SetNext(dot)
+
+	iterator, err := NewFileIterator(root, or)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"a.org", "b.md", "sub/d.org", "sub/e.md"}
+	result := iterateTestTree(t, root, iterator)
+	if strings.Join(result, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Iterate result is error:\n%s", strings.Join(result, "\n"))
+	}
+
+	expectedString := `(regexp("\.org$") or regexp("\.md$") or path("sub")) and not dot`
+	if or.String() != expectedString {
+		t.Errorf("String of chain is error: %s", or.String())
+	}
+
+	sub, _ := NewFilterGlobMatchSupport(root, "sub/**")
+	notSub, _ := NewFilterNotSupport(sub)
+	orgOrMd, _ := NewFilterOrSupport(org, md)
+	and, err := NewFilterAndSupport(orgOrMd, notSub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	iterator, err = NewFileIterator(root, and)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{".hidden.org", "a.org", "b.md"}
+	result = iterateTestTree(t, root, iterator)
+	if strings.Join(result, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Iterate result is error:\n%s", strings.Join(result, "\n"))
+	}
+}
+
+func TestCompositeSupportsError(t *testing.T) {
+	info, err := os.Lstat("filter.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fail := &errorSupport{}
+	pass, _ := NewFilterRegexpMatchSupport(`\.go$`)
+
+	or, _ := NewFilterOrSupport(fail, pass)
+	if ignored, err := or.IsIgnore("filter.go", info); ignored || err != nil {
+		t.Error("Or should include path included by any operand.")
+	}
+
+	and, _ := NewFilterAndSupport(pass, fail)
+	if ignored, err := and.IsIgnore("filter.go", info); !ignored || err == nil {
+		t.Error("And should ignore path with error.")
+	}
+
+	not, _ := NewFilterNotSupport(fail)
+	if ignored, err := not.IsIgnore("filter.go", info); !ignored || err == nil {
+		t.Error("Not should ignore path with error.")
+	}
+
+	if _, err := NewFilterAndSupport(); err != ErrNoOperand {
+		t.Error("And without operands should be invalid.")
+	}
+}
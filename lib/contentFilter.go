@@ -0,0 +1,259 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Content filters read files to decide. Directories are never ignored by
+// them so recursion goes on, and other special files are always ignored
+// since reading them may block.
+
+// sniffLen is the number of leading bytes used to detect content type.
+const sniffLen = 512
+
+// archiveMIMEs are MIME types of the `archive` family.
+var archiveMIMEs = map[string]bool{
+	"application/zip":              true,
+	"application/x-gzip":           true,
+	"application/x-tar":            true,
+	"application/x-bzip2":          true,
+	"application/x-xz":             true,
+	"application/x-7z-compressed":  true,
+	"application/x-rar-compressed": true,
+	"application/zstd":             true,
+}
+
+// extraSignatures are magic numbers not detected by http.DetectContentType.
+var extraSignatures = []struct {
+	offset int
+	magic  []byte
+	mime   string
+}{
+	{257, []byte("ustar"), "application/x-tar"},
+	{0, []byte("BZh"), "application/x-bzip2"},
+	{0, []byte("\xFD7zXZ\x00"), "application/x-xz"},
+	{0, []byte("7z\xBC\xAF\x27\x1C"), "application/x-7z-compressed"},
+	{0, []byte("\x28\xB5\x2F\xFD"), "application/zstd"},
+}
+
+// DetectMIME return the MIME type of content starting with head, without
+// parameters like charset.
+func DetectMIME(head []byte) string {
+	for _, sig := range extraSignatures {
+		if len(head) >= sig.offset+len(sig.magic) && bytes.Equal(head[sig.offset:sig.offset+len(sig.magic)], sig.magic) {
+			return sig.mime
+		}
+	}
+
+	mime := http.DetectContentType(head)
+	if i := strings.IndexByte(mime, ';'); i >= 0 {
+		mime = mime[:i]
+	}
+	return mime
+}
+
+// IsBinary checks if content starting with head is binary. Content with NUL
+// bytes or with too many invalid UTF-8 sequences and control characters is
+// binary.
+func IsBinary(head []byte) bool {
+	if bytes.IndexByte(head, 0) >= 0 {
+		return true
+	}
+
+	bad := 0
+	for i := 0; i < len(head); {
+		r, size := utf8.DecodeRune(head[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			if !utf8.FullRune(head[i:]) {
+				// the last rune is cut off by sniffLen
+				return bad*10 > len(head)
+			}
+			bad++
+		case r < 0x20 && r != '\n' && r != '\r' && r != '\t' && r != '\f' && r != '\b' && r != 0x1B:
+			bad++
+		}
+		i += size
+	}
+	return bad*10 > len(head)
+}
+
+// sniffFile reads the leading bytes of file in fsys.
+func sniffFile(fsys FileSystem, path string) ([]byte, error) {
+	file, err := fsys.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return head[:n], nil
+}
+
+// contentDecision return the decision of content filters for paths which
+// are not regular files, decided is false for regular files.
+func contentDecision(info os.FileInfo) (ignored, decided bool) {
+	switch {
+	case info.IsDir():
+		return false, true
+	case !info.Mode().IsRegular():
+		return true, true
+	}
+	return false, false
+}
+
+// MIMEMatchSupport keeps only files whose content type matches any of
+// patterns. A pattern is a MIME type like `application/pdf`, a family like
+// `image/*`, or `archive` for compressed files and archives.
+type MIMEMatchSupport struct {
+	BaseSupport
+
+	patterns []string
+}
+
+// NewFilterMIMEMatchSupport create a MIMEMatchSupport.
+func NewFilterMIMEMatchSupport(patterns ...string) (FilterSupport, error) {
+	if len(patterns) == 0 {
+		return nil, fmt.Errorf("no MIME pattern")
+	}
+
+	is := &MIMEMatchSupport{
+		patterns: patterns,
+	}
+	is.SetName("mime(" + strings.Join(formatFilterArgs(patterns), ",") + ")")
+	return is, nil
+}
+
+// MatchMIME checks if mime matches pattern of MIMEMatchSupport.
+func MatchMIME(pattern, mime string) bool {
+	switch {
+	case pattern == "archive":
+		return archiveMIMEs[mime]
+	case strings.HasSuffix(pattern, "/*"):
+		return strings.HasPrefix(mime, strings.TrimSuffix(pattern, "*"))
+	}
+	return pattern == mime
+}
+
+// IsIgnore ...
+func (mms *MIMEMatchSupport) IsIgnore(path string, info os.FileInfo) (bool, error) {
+	if ignored, decided := contentDecision(info); decided {
+		return ignored, nil
+	}
+
+	head, err := sniffFile(mms.FileSystem(), path)
+	if err != nil {
+		return true, err
+	}
+
+	mime := DetectMIME(head)
+	for _, pattern := range mms.patterns {
+		if MatchMIME(pattern, mime) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// TextSupport keeps only text files, or only binary files if binary is
+// true.
+type TextSupport struct {
+	BaseSupport
+
+	binary bool
+}
+
+// NewFilterTextSupport create a TextSupport.
+func NewFilterTextSupport(binary bool) (FilterSupport, error) {
+	is := &TextSupport{
+		binary: binary,
+	}
+	if binary {
+		is.SetName("binary")
+	} else {
+		is.SetName("text")
+	}
+	return is, nil
+}
+
+// IsIgnore ...
+func (ts *TextSupport) IsIgnore(path string, info os.FileInfo) (bool, error) {
+	if ignored, decided := contentDecision(info); decided {
+		return ignored, nil
+	}
+
+	head, err := sniffFile(ts.FileSystem(), path)
+	if err != nil {
+		return true, err
+	}
+	return IsBinary(head) != ts.binary, nil
+}
+
+// ContentRegexpSupport keeps only files whose content matches the regexp.
+// Files are read as a stream, so the whole content is never loaded into
+// memory.
+type ContentRegexpSupport struct {
+	BaseSupport
+
+	pattern *regexp.Regexp
+}
+
+// NewFilterContentRegexpSupport create a ContentRegexpSupport.
+func NewFilterContentRegexpSupport(expr string) (FilterSupport, error) {
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	is := &ContentRegexpSupport{
+		pattern: pattern,
+	}
+	is.SetName(fmt.Sprintf("content(%s)", quoteFilterArg(expr)))
+	return is, nil
+}
+
+// IsIgnore ...
+func (crs *ContentRegexpSupport) IsIgnore(path string, info os.FileInfo) (bool, error) {
+	if ignored, decided := contentDecision(info); decided {
+		return ignored, nil
+	}
+
+	file, err := crs.FileSystem().Open(path)
+	if err != nil {
+		return true, err
+	}
+	defer file.Close()
+
+	return !crs.pattern.MatchReader(bufio.NewReader(file)), nil
+}
@@ -0,0 +1,77 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package lib
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestContentSupports(t *testing.T) {
+	tarHead := strings.Repeat("\x00", 257) + "ustar\x0000" + strings.Repeat("\x00", 250)
+	root := makeTestTree(t, map[string]string{
+		"picture":         "\x89PNG\r\n\x1a\n" + strings.Repeat("\x00", 32),
+		"doc.txt":         "%PDF-1.4\n%âãÏÓ\n",
+		"backup.dat":      "\x1f\x8b\x08\x00" + strings.Repeat("\x01", 16),
+		"bundle":          tarHead,
+		"notes.org":       "* 标题\nSome text with\ttabs.\n",
+		"program":         "\x7fELF\x02\x01\x01\x00\x00\x00",
+		"empty":           "",
+		"sub/big.log":     strings.Repeat("line of log\n", 10000) + "NEEDLE found\n",
+		"sub/photo.jpg":   "not really a jpeg\n",
+		"sub/deep/gif.gz": "GIF89a" + strings.Repeat("\x00", 8),
+	})
+	defer os.RemoveAll(root)
+
+	cases := []struct {
+		expr     string
+		expected []string
+	}{
+		{`mime(image/*)`, []string{"picture", "sub/deep/gif.gz"}},
+		{`mime(application/pdf,archive)`, []string{"backup.dat", "bundle", "doc.txt"}},
+		{`text`, []string{"doc.txt", "empty", "notes.org", "sub/big.log", "sub/photo.jpg"}},
+		{`binary`, []string{"backup.dat", "bundle", "picture", "program", "sub/deep/gif.gz"}},
+		{`content("NEEDLE|标题")`, []string{"notes.org", "sub/big.log"}},
+		{`ext(jpg) and not mime(image/*)`, []string{"sub/photo.jpg"}},
+	}
+	for _, c := range cases {
+		result := filterTestTree(t, root, c.expr)
+		if strings.Join(result, "\n") != strings.Join(c.expected, "\n") {
+			t.Errorf("Filter %s result is error:\n%s", c.expr, strings.Join(result, "\n"))
+		}
+	}
+}
+
+func TestIsBinary(t *testing.T) {
+	cases := map[string]bool{
+		"plain ascii\n":            false,
+		"中文内容":                     false,
+		"中文内容"[:5]:                 false,
+		"\xff\xfe\xfd\xfc\xfb\xfa": true,
+		"with\x00nul":              true,
+		"\x01\x02\x03\x04ab":       true,
+	}
+	for content, binary := range cases {
+		if IsBinary([]byte(content)) != binary {
+			t.Errorf("Content %q should be binary: %v", content, binary)
+		}
+	}
+}
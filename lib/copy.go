@@ -0,0 +1,349 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// CopyOptions are options of copying files. Copies are always written to a
+// temporary file in the directory of destination, which is renamed to
+// destination when it is complete, so that destination is never left half
+// written.
+type CopyOptions struct {
+	// PreserveMode copies permission bits, including setuid, setgid and
+	// sticky bits, of source. Otherwise an existing destination keeps its
+	// permission bits and a new one is created with 0666 before umask.
+	PreserveMode bool
+	// PreserveTimes copies access and modification times of source.
+	PreserveTimes bool
+	// PreserveOwner copies owner and group of source, it usually needs
+	// privileges.
+	PreserveOwner bool
+	// PreserveXattrs copies extended attributes of source.
+	PreserveXattrs bool
+	// Verify compares sha256 of source and the copy before it replaces
+	// destination.
+	Verify bool
+	// Strategy is the way contents are copied, CopyAuto by default.
+	Strategy CopyStrategy
+}
+
+// CopyStrategy is the way contents of files are copied.
+type CopyStrategy int
+
+const (
+	// CopyAuto tries CopyReflink, CopyFileRange and CopySendfile in order,
+	// and falls back to CopyBuffered if none of them is supported.
+	CopyAuto CopyStrategy = iota
+	// CopyReflink clones source by the FICLONE ioctl, so that contents are
+	// shared until either file is written. It is supported by btrfs and xfs
+	// on linux.
+	CopyReflink
+	// CopyFileRange copies contents in kernel by copy_file_range on linux.
+	CopyFileRange
+	// CopySendfile copies contents in kernel by sendfile on linux.
+	CopySendfile
+	// CopyBuffered copies contents through a page sized buffer.
+	CopyBuffered
+)
+
+var copyStrategyNames = map[CopyStrategy]string{
+	CopyAuto:      "auto",
+	CopyReflink:   "reflink",
+	CopyFileRange: "copy_file_range",
+	CopySendfile:  "sendfile",
+	CopyBuffered:  "buffered",
+}
+
+func (cs CopyStrategy) String() string {
+	return copyStrategyNames[cs]
+}
+
+// ParseCopyStrategy parses name of strategy, which is one of auto, reflink,
+// copy_file_range, sendfile and buffered.
+func ParseCopyStrategy(name string) (CopyStrategy, error) {
+	for strategy, n := range copyStrategyNames {
+		if n == name {
+			return strategy, nil
+		}
+	}
+	return CopyAuto, fmt.Errorf("unknown copy strategy: %s", name)
+}
+
+// ErrCopyNotSupported is returned when owner or extended attributes are
+// asked to be preserved on a file system or platform not supporting them.
+var ErrCopyNotSupported = errors.New("preserving owner and xattrs is not supported")
+
+// ErrCopyStrategyNotSupported is returned when the strategy forced by
+// CopyOptions could not copy the file, like reflinks across file systems.
+var ErrCopyStrategyNotSupported = errors.New("copy strategy is not supported")
+
+// errCopyMismatch is returned when a copy differs from its source.
+var errCopyMismatch = errors.New("checksum of copy mismatches source")
+
+// CopyFile copies a file from src to dst. If src and dst files exist, and are
+// the same, then return success. Otherise, copy the file contents from src to dst.
+func CopyFile(src, dst string) (err error) {
+	return CopyFileFSWithOptions(OSFS, src, dst, CopyOptions{})
+}
+
+// CopyFileWithOptions copies a file from src to dst like CopyFile, and
+// preserves metadata of src by opts.
+func CopyFileWithOptions(src, dst string, opts CopyOptions) error {
+	return CopyFileFSWithOptions(OSFS, src, dst, opts)
+}
+
+// CopyFileFS copies a file from src to dst in fsys like CopyFile.
+func CopyFileFS(fsys FileSystem, src, dst string) (err error) {
+	return CopyFileFSWithOptions(fsys, src, dst, CopyOptions{})
+}
+
+// CopyFileFSWithOptions copies a file from src to dst in fsys like
+// CopyFileWithOptions. Owner and extended attributes could only be
+// preserved in OSFS.
+func CopyFileFSWithOptions(fsys FileSystem, src, dst string, opts CopyOptions) (err error) {
+	Logger.Debugf("Copy file %s to %s...", src, dst)
+	if (opts.PreserveOwner || opts.PreserveXattrs) && fsys != OSFS {
+		return &os.PathError{Op: "copy", Path: src, Err: ErrCopyNotSupported}
+	}
+
+	sfi, err := fsys.Stat(src)
+	if err != nil {
+		return
+	}
+	if !sfi.Mode().IsRegular() {
+		// cannot copy non-regular files (e.g., directories,
+		// symlinks, devices, etc.)
+		return fmt.Errorf("CopyFile: non-regular source file %s (%q)", sfi.Name(), sfi.Mode().String())
+	}
+
+	perm, keepPerm := os.FileMode(0666), false
+	dfi, err := fsys.Stat(dst)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return
+		}
+	} else {
+		if !(dfi.Mode().IsRegular()) {
+			return fmt.Errorf("CopyFile: non-regular destination file %s (%q)", dfi.Name(), dfi.Mode().String())
+		}
+		if sameFile(fsys, src, dst, sfi, dfi) {
+			return nil
+		}
+		// replace the file linked by dst rather than the link
+		if dst, err = EvalSymlinks(fsys, dst); err != nil {
+			return
+		}
+		perm, keepPerm = dfi.Mode()&copiedModeBits, true
+	}
+	if opts.PreserveMode {
+		perm, keepPerm = sfi.Mode()&copiedModeBits, true
+	}
+
+	tmp, err := copyToTemp(fsys, src, dst, perm, sfi.Size(), opts.Strategy)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			fsys.Remove(tmp)
+		}
+	}()
+
+	// verify before times are set, reading tmp changes its atime
+	if opts.Verify {
+		if err = verifyCopy(fsys, src, tmp); err != nil {
+			return
+		}
+	}
+	if err = copyMetadata(fsys, src, tmp, sfi, opts); err != nil {
+		return
+	}
+	// chmod after chown, which clears setuid and setgid bits, and to undo
+	// umask
+	if keepPerm {
+		if err = fsys.Chmod(tmp, perm); err != nil {
+			return
+		}
+	}
+	if opts.PreserveTimes {
+		atime := sfi.ModTime()
+		if st, err := statOf(sfi); err == nil {
+			atime = st.atime
+		}
+		if err = fsys.Chtimes(tmp, atime, sfi.ModTime()); err != nil {
+			return
+		}
+	}
+
+	err = fsys.Rename(tmp, dst)
+	Logger.Debugf("Finish Copying from %s to %s...", src, dst)
+	return
+}
+
+// copiedModeBits are bits of file mode copied by CopyFile.
+const copiedModeBits = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// sameFile checks if src and dst are the same file, files of fsys other
+// than OSFS are compared by their real paths.
+func sameFile(fsys FileSystem, src, dst string, sfi, dfi os.FileInfo) bool {
+	if fsys == OSFS {
+		return os.SameFile(sfi, dfi)
+	}
+	srcReal, err := EvalSymlinks(fsys, src)
+	if err != nil {
+		return false
+	}
+	dstReal, err := EvalSymlinks(fsys, dst)
+	return err == nil && srcReal == dstReal
+}
+
+// tempSeq makes names of temporary files unique in the process.
+var tempSeq uint64
+
+// createTemp creates a new file beside path in fsys, named after path.
+func createTemp(fsys FileSystem, path string, perm os.FileMode) (File, string, error) {
+	dir, base := filepath.Split(path)
+	for {
+		seq := atomic.AddUint64(&tempSeq, 1)
+		name := filepath.Join(dir, "."+base+"."+
+			strconv.FormatInt(time.Now().UnixNano(), 36)+strconv.FormatUint(seq, 36)+".tmp")
+		f, err := fsys.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+		if os.IsExist(err) {
+			continue
+		}
+		return f, name, err
+	}
+}
+
+// copyToTemp copies the contents of the file named src, whose size is
+// size, to a new temporary file beside dst created with perm by strategy,
+// and return its name.
+func copyToTemp(fsys FileSystem, src, dst string, perm os.FileMode, size int64, strategy CopyStrategy) (tmp string, err error) {
+	in, err := fsys.Open(src)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+	out, tmp, err := createTemp(fsys, dst, perm)
+	if err != nil {
+		return
+	}
+	defer func() {
+		cerr := out.Close()
+		if err == nil {
+			err = cerr
+		}
+		if err != nil {
+			fsys.Remove(tmp)
+		}
+	}()
+
+	if err = copyContents(in, out, size, strategy); err != nil {
+		return
+	}
+	err = out.Sync()
+	return
+}
+
+// copyContents copies the contents of in, whose size is size, to out by
+// strategy. Kernel strategies are only tried on files of OSFS, a strategy
+// failed partway is continued by the next one from where it stops.
+func copyContents(in, out File, size int64, strategy CopyStrategy) error {
+	if strategy != CopyBuffered {
+		inf, inOK := in.(*os.File)
+		outf, outOK := out.(*os.File)
+		done := false
+		if inOK && outOK {
+			var err error
+			if done, err = copyFast(inf, outf, size, strategy); err != nil {
+				return err
+			}
+		}
+		if done {
+			return nil
+		}
+		if strategy != CopyAuto {
+			return &os.PathError{Op: strategy.String(), Path: in.Name(), Err: ErrCopyStrategyNotSupported}
+		}
+	}
+	return copyFileContents(in, out)
+}
+
+// copyFileContents copies the contents of in to out through a page sized
+// buffer.
+func copyFileContents(in io.Reader, out io.Writer) error {
+	buf := make([]byte, os.Getpagesize())
+	for {
+		n, err := in.Read(buf)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if n == 0 {
+			break
+		}
+
+		if _, err := out.Write(buf[:n]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyMetadata copies owner and extended attributes of src to dst by opts.
+func copyMetadata(fsys FileSystem, src, dst string, sfi os.FileInfo, opts CopyOptions) error {
+	if opts.PreserveOwner {
+		st, err := statOf(sfi)
+		if err != nil {
+			return &os.PathError{Op: "copy", Path: src, Err: ErrCopyNotSupported}
+		}
+		if err := os.Lchown(dst, int(st.uid), int(st.gid)); err != nil {
+			return err
+		}
+	}
+	if opts.PreserveXattrs {
+		return copyXattrs(src, dst)
+	}
+	return nil
+}
+
+// verifyCopy return error if contents of src and dst are different.
+func verifyCopy(fsys FileSystem, src, dst string) error {
+	srcHash, err := HashFileFS(fsys, src)
+	if err != nil {
+		return err
+	}
+	dstHash, err := HashFileFS(fsys, dst)
+	if err != nil {
+		return err
+	}
+	if srcHash != dstHash {
+		return &os.PathError{Op: "verify", Path: dst, Err: errCopyMismatch}
+	}
+	return nil
+}
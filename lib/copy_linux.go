@@ -0,0 +1,148 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+//go:build linux
+// +build linux
+
+package lib
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// ficlone is the FICLONE ioctl, _IOW(0x94, 9, int), cloning the file
+// given as argument into the target file.
+const ficlone = 0x40049409
+
+// maxFastChunk is the maximum number of bytes copied by one syscall.
+const maxFastChunk = 1 << 30
+
+// errFastCopyUnsupported stops a fast copier which could not copy the
+// rest of file, so that the next strategy continues.
+var errFastCopyUnsupported = errors.New("fast copy is not supported")
+
+// fastCopier copies from in to out at their offsets until the end of in,
+// size is the size of in when it is opened. It return the number of bytes
+// copied.
+type fastCopier func(in, out *os.File, size int64) (int64, error)
+
+var fastCopiers = map[CopyStrategy]fastCopier{
+	CopyReflink:   copyReflink,
+	CopyFileRange: copyFileRange,
+	CopySendfile:  copySendfile,
+}
+
+// copyFast copies in to out by strategy until the end of in, and return
+// false if the rest should be copied through buffers. size is the size of
+// in when it is opened, files could grow or shrink while being copied. Sizes
+// of files in pseudo file systems like /proc are zero, so empty files are
+// left to buffers under CopyAuto.
+func copyFast(in, out *os.File, size int64, strategy CopyStrategy) (bool, error) {
+	strategies := []CopyStrategy{strategy}
+	if strategy == CopyAuto {
+		if size == 0 {
+			return false, nil
+		}
+		strategies = []CopyStrategy{CopyReflink, CopyFileRange, CopySendfile}
+	}
+
+	for _, s := range strategies {
+		copier, ok := fastCopiers[s]
+		if !ok {
+			return false, nil
+		}
+		n, err := copier(in, out, size)
+		if err == nil {
+			return true, nil
+		}
+		if err != errFastCopyUnsupported {
+			return false, err
+		}
+		Logger.Debugf("%s is not supported to copy %s after %d bytes", s, in.Name(), n)
+	}
+	return false, nil
+}
+
+// fastCopyUnsupported checks if err means the strategy could not be used
+// on the files, rather than the copy fails.
+func fastCopyUnsupported(err error) bool {
+	switch err {
+	case unix.ENOSYS, unix.EXDEV, unix.EINVAL, unix.EOPNOTSUPP,
+		unix.ENOTTY, unix.EPERM, unix.EBADF:
+		return true
+	}
+	return false
+}
+
+// copyReflink clones the whole in to out, it could only be used before
+// anything is copied.
+func copyReflink(in, out *os.File, size int64) (int64, error) {
+	if err := unix.IoctlSetInt(int(out.Fd()), ficlone, int(in.Fd())); err != nil {
+		if fastCopyUnsupported(err) {
+			return 0, errFastCopyUnsupported
+		}
+		return 0, &os.PathError{Op: "ficlone", Path: in.Name(), Err: err}
+	}
+	return size, nil
+}
+
+// copyFileRange copies by copy_file_range, which fails across file systems
+// on kernels before 5.3.
+func copyFileRange(in, out *os.File, size int64) (int64, error) {
+	return copyChunks(in, size, "copy_file_range", func(chunk int) (int, error) {
+		return unix.CopyFileRange(int(in.Fd()), nil, int(out.Fd()), nil, chunk, 0)
+	})
+}
+
+// copySendfile copies by sendfile.
+func copySendfile(in, out *os.File, size int64) (int64, error) {
+	return copyChunks(in, size, "sendfile", func(chunk int) (int, error) {
+		return unix.Sendfile(int(out.Fd()), int(in.Fd()), nil, chunk)
+	})
+}
+
+// copyChunks calls copy until it copies nothing at the end of in. Copying
+// nothing from a file not empty when it is opened, like copy_file_range on
+// some pseudo file systems, is taken as unsupported.
+func copyChunks(in *os.File, size int64, op string, copy func(chunk int) (int, error)) (int64, error) {
+	var copied int64
+	for {
+		m, err := copy(maxFastChunk)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			if fastCopyUnsupported(err) {
+				return copied, errFastCopyUnsupported
+			}
+			return copied, &os.PathError{Op: op, Path: in.Name(), Err: err}
+		}
+		if m == 0 {
+			if copied == 0 && size > 0 {
+				return 0, errFastCopyUnsupported
+			}
+			return copied, nil
+		}
+		copied += int64(m)
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+//go:build linux
+// +build linux
+
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCopyFastChangedSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "copy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src")
+	for _, strategy := range []CopyStrategy{CopyFileRange, CopySendfile} {
+		// the size given is the stale size of a file grown or shrunk after
+		// being opened
+		for _, size := range []int64{3, 100} {
+			if err := WriteFile(src, []byte("abcdef")); err != nil {
+				t.Fatal(err)
+			}
+			in, err := os.Open(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dst := filepath.Join(dir, "dst")
+			out, err := os.Create(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			done, err := copyFast(in, out, size, strategy)
+			in.Close()
+			out.Close()
+			if err != nil || !done {
+				t.Errorf("%s with size %d is error: %v, %v", strategy, size, done, err)
+				continue
+			}
+			if data, _ := ReadFile(dst); string(data) != "abcdef" {
+				t.Errorf("%s with size %d copies %q", strategy, size, data)
+			}
+		}
+	}
+}
@@ -0,0 +1,299 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package lib
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCopyFileFS(t *testing.T) {
+	mfs, err := NewMemFileSystemWithFiles(map[string]string{
+		"/a/src.txt": "content",
+		"/a/dst.txt": "old content to be replaced",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mfs.Symlink("src.txt", "/a/link.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, dst := range []string{"/a/dst.txt", "/a/new.txt", "/a/link.txt"} {
+		if err := CopyFileFS(mfs, "/a/src.txt", dst); err != nil {
+			t.Fatal(err)
+		}
+		if data, _ := ReadFileFS(mfs, dst); string(data) != "content" {
+			t.Errorf("Content of %s is error: %q", dst, data)
+		}
+	}
+
+	if err := CopyFileFS(mfs, "/a", "/b"); err == nil {
+		t.Error("Copying directory should fail.")
+	}
+	if !IsFileFS(mfs, "/a/new.txt") || IsDirFS(mfs, "/a/new.txt") || !IsDirFS(mfs, "/a") || !IsNotExistFS(mfs, "/b") {
+		t.Error("File types are error.")
+	}
+}
+
+func TestCopyFileOptions(t *testing.T) {
+	mfs, _ := NewMemFileSystemWithFiles(map[string]string{
+		"/a/src.txt": "content",
+		"/a/dst.txt": "old",
+	})
+	mtime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	mfs.Chtimes("/a/src.txt", mtime, mtime)
+	mfs.Chmod("/a/src.txt", 0640)
+	mfs.Chmod("/a/dst.txt", 0600)
+
+	if err := CopyFileFS(mfs, "/a/src.txt", "/a/dst.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := mfs.Stat("/a/dst.txt"); info.Mode() != 0600 || info.ModTime().Equal(mtime) {
+		t.Errorf("Existing destination should keep its mode: %v %v", info.Mode(), info.ModTime())
+	}
+
+	opts := CopyOptions{PreserveMode: true, PreserveTimes: true, Verify: true}
+	for _, dst := range []string{"/a/dst.txt", "/a/new.txt"} {
+		if err := CopyFileFSWithOptions(mfs, "/a/src.txt", dst, opts); err != nil {
+			t.Fatal(err)
+		}
+		if info, _ := mfs.Stat(dst); info.Mode() != 0640 || !info.ModTime().Equal(mtime) {
+			t.Errorf("Mode and mtime of %s should be preserved: %v %v", dst, info.Mode(), info.ModTime())
+		}
+	}
+
+	dir, _ := mfs.Open("/a")
+	infos, _ := dir.Readdir(0)
+	if len(infos) != 3 {
+		t.Errorf("Temporary files should be renamed: %d files", len(infos))
+	}
+
+	if err := CopyFileFSWithOptions(mfs, "/a/src.txt", "/a/dst.txt", CopyOptions{PreserveOwner: true}); !errors.Is(err, ErrCopyNotSupported) {
+		t.Errorf("Preserving owner in MemFileSystem should fail: %v", err)
+	}
+}
+
+// failingFileSystem is a MemFileSystem whose files fail to be read after
+// the first read.
+type failingFileSystem struct {
+	*MemFileSystem
+}
+
+type failingFile struct {
+	File
+	reads int
+}
+
+func (ffs failingFileSystem) Open(name string) (File, error) {
+	f, err := ffs.MemFileSystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &failingFile{File: f}, nil
+}
+
+func (ff *failingFile) Read(p []byte) (int, error) {
+	if ff.reads++; ff.reads > 1 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return ff.File.Read(p[:1])
+}
+
+func TestCopyFileAtomic(t *testing.T) {
+	mfs, _ := NewMemFileSystemWithFiles(map[string]string{
+		"/a/src.txt": "content",
+		"/a/dst.txt": "old",
+	})
+
+	if err := CopyFileFS(failingFileSystem{mfs}, "/a/src.txt", "/a/dst.txt"); err != io.ErrUnexpectedEOF {
+		t.Errorf("Copy should fail: %v", err)
+	}
+	if data, _ := ReadFileFS(mfs, "/a/dst.txt"); string(data) != "old" {
+		t.Errorf("Destination should be intact: %q", data)
+	}
+	dir, _ := mfs.Open("/a")
+	if infos, _ := dir.Readdir(0); len(infos) != 2 {
+		t.Errorf("Temporary file should be removed: %d files", len(infos))
+	}
+}
+
+func TestCopyFileOS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "copy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src, dst := filepath.Join(dir, "src.txt"), filepath.Join(dir, "sub", "dst.txt")
+	os.Mkdir(filepath.Dir(dst), 0755)
+	if err := WriteFile(src, []byte(strings.Repeat("content", 1000))); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.Local)
+	os.Chtimes(src, mtime, mtime)
+	os.Chmod(src, 0604)
+
+	opts := CopyOptions{PreserveMode: true, PreserveTimes: true, PreserveOwner: os.Getuid() == 0, Verify: true}
+	if err := CopyFileWithOptions(src, dst, opts); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(dst)
+	if err != nil || info.Mode() != 0604 || !info.ModTime().Equal(mtime) || info.Size() != 7000 {
+		t.Errorf("Copy is error: %v, %v", info, err)
+	}
+	if err := CopyFile(dst, dst); err != nil {
+		t.Errorf("Copying file to itself should succeed: %v", err)
+	}
+}
+
+// copyStrategies are strategies forced in tests and benchmarks.
+var copyStrategies = []CopyStrategy{CopyAuto, CopyReflink, CopyFileRange, CopySendfile, CopyBuffered}
+
+// checkCopyStrategies copies src to dirs by every strategy, and checks the
+// copies are the same as src or fail as unsupported.
+func checkCopyStrategies(t *testing.T, src string, dirs ...string) {
+	want, err := ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range dirs {
+		for _, strategy := range copyStrategies {
+			dst := filepath.Join(dir, strategy.String()+".dat")
+			err := CopyFileWithOptions(src, dst, CopyOptions{Strategy: strategy, Verify: true})
+			if strategy != CopyAuto && strategy != CopyBuffered && errors.Is(err, ErrCopyStrategyNotSupported) {
+				t.Logf("%s is not supported from %s to %s", strategy, src, dir)
+				continue
+			}
+			if err != nil {
+				t.Errorf("Copying to %s by %s fails: %v", dir, strategy, err)
+				continue
+			}
+			if got, _ := ReadFile(dst); string(got) != string(want) {
+				t.Errorf("Copy to %s by %s is error: %d bytes", dir, strategy, len(got))
+			}
+			os.Remove(dst)
+		}
+	}
+}
+
+func TestCopyFileStrategies(t *testing.T) {
+	dir, err := ioutil.TempDir("", "copy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, size := range []int{0, 1, 4097, 3<<20 + 7} {
+		src := filepath.Join(dir, "src.dat")
+		data := make([]byte, size)
+		for i := range data {
+			data[i] = byte(i * 7)
+		}
+		if err := WriteFile(src, data); err != nil {
+			t.Fatal(err)
+		}
+		checkCopyStrategies(t, src, dir)
+	}
+
+	if _, err := ParseCopyStrategy("splice"); err == nil {
+		t.Error("Parsing unknown copy strategy should fail.")
+	}
+	mfs, _ := NewMemFileSystemWithFiles(map[string]string{"/src": "src"})
+	err = CopyFileFSWithOptions(mfs, "/src", "/dst", CopyOptions{Strategy: CopyFileRange})
+	if !errors.Is(err, ErrCopyStrategyNotSupported) {
+		t.Errorf("Kernel strategies should not be supported by MemFileSystem: %v", err)
+	}
+}
+
+func TestCopyFileAcrossFileSystems(t *testing.T) {
+	dir, err := ioutil.TempDir("", "copy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	info, _ := os.Stat(dir)
+	st, err := statOf(info)
+	if err != nil {
+		t.Skip(err)
+	}
+
+	others := make([]string, 0)
+	for _, candidate := range []string{"/dev/shm"} {
+		info, err := os.Stat(candidate)
+		if err != nil {
+			continue
+		}
+		if other, err := statOf(info); err == nil && other.dev != st.dev {
+			tmp, err := ioutil.TempDir(candidate, "copy")
+			if err != nil {
+				continue
+			}
+			defer os.RemoveAll(tmp)
+			others = append(others, tmp)
+		}
+	}
+	if len(others) == 0 {
+		t.Skip("no other file system is found")
+	}
+
+	src := filepath.Join(dir, "src.dat")
+	if err := WriteFile(src, []byte(strings.Repeat("across file systems\n", 100000))); err != nil {
+		t.Fatal(err)
+	}
+	checkCopyStrategies(t, src, others...)
+}
+
+// benchmarkCopySize is the size of files copied in benchmarks.
+const benchmarkCopySize = 64 << 20
+
+func BenchmarkCopyFile(b *testing.B) {
+	dir, err := ioutil.TempDir("", "copy")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src.dat")
+	if err := WriteFile(src, make([]byte, benchmarkCopySize)); err != nil {
+		b.Fatal(err)
+	}
+	for _, strategy := range copyStrategies {
+		b.Run(strategy.String(), func(b *testing.B) {
+			dst := filepath.Join(dir, strategy.String()+".dat")
+			b.SetBytes(benchmarkCopySize)
+			for i := 0; i < b.N; i++ {
+				err := CopyFileWithOptions(src, dst, CopyOptions{Strategy: strategy})
+				if errors.Is(err, ErrCopyStrategyNotSupported) {
+					b.Skip(err)
+				}
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
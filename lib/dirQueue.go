@@ -0,0 +1,145 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package lib
+
+import (
+	"context"
+	"os"
+
+	"github.com/MephistoMMM/magician/lib/concurrent"
+)
+
+// prefetchFactor is how many directories per worker are read ahead of the
+// walk.
+const prefetchFactor = 4
+
+// dirListing is a directory to read and the result of reading.
+type dirListing struct {
+	dir   FileEntry
+	infos []os.FileInfo
+	err   error
+
+	started bool
+	done    chan struct{}
+}
+
+// dirQueue is a queue of directories to walk, it is FIFO for breadth first
+// walks and LIFO for depth first ones. With more than one worker,
+// directories near the head are read ahead concurrently, and the walk still
+// sees them in queue order, so the order of output does not depend on
+// workers.
+type dirQueue struct {
+	ctx        context.Context
+	fs         FileSystem
+	items      []*dirListing
+	depthFirst bool
+
+	// swg bounds concurrent reads, it is nil for sequential reads.
+	swg    *concurrent.SizedWaitGroup
+	window int
+}
+
+// newDirQueue create a dirQueue reading up to workers directories at once.
+func newDirQueue(ctx context.Context, fsys FileSystem, workers int, depthFirst bool) *dirQueue {
+	q := &dirQueue{
+		ctx:        ctx,
+		fs:         fsys,
+		items:      make([]*dirListing, 0),
+		depthFirst: depthFirst,
+	}
+	if workers > 1 {
+		swg := concurrent.New(workers)
+		q.swg = &swg
+		q.window = workers * prefetchFactor
+	}
+	return q
+}
+
+// Len return the number of directories in queue.
+func (q *dirQueue) Len() int {
+	return len(q.items)
+}
+
+// Push adds dirs of a directory to queue in order, they are added to the
+// tail for breadth first walks or to the head for depth first walks.
+func (q *dirQueue) Push(dirs ...FileEntry) {
+	items := make([]*dirListing, 0, len(dirs))
+	for _, dir := range dirs {
+		items = append(items, &dirListing{dir: dir, done: make(chan struct{})})
+	}
+	if q.depthFirst {
+		q.items = append(items, q.items...)
+	} else {
+		q.items = append(q.items, items...)
+	}
+	q.prefetch()
+}
+
+// Pop removes the first directory from queue and return its entries sorted
+// by name. It blocks until the directory is read.
+func (q *dirQueue) Pop() (FileEntry, []os.FileInfo, error) {
+	item := q.items[0]
+	q.items[0] = nil
+	q.items = q.items[1:]
+
+	if q.swg == nil {
+		infos, err := readDirContext(q.ctx, q.fs, item.dir.Path)
+		return item.dir, infos, err
+	}
+
+	q.prefetch()
+	if !item.started {
+		q.start(item)
+	}
+	<-item.done
+	return item.dir, item.infos, item.err
+}
+
+// Reset drops all directories in queue, reads in progress are left to
+// finish.
+func (q *dirQueue) Reset() {
+	q.items = q.items[:0]
+}
+
+// prefetch starts reading directories in the window at the head of queue.
+func (q *dirQueue) prefetch() {
+	if q.swg == nil {
+		return
+	}
+	for i := 0; i < len(q.items) && i < q.window; i++ {
+		if !q.items[i].started {
+			q.start(q.items[i])
+		}
+	}
+}
+
+// start reads item in a new goroutine once a worker is free.
+func (q *dirQueue) start(item *dirListing) {
+	item.started = true
+	go func() {
+		defer close(item.done)
+		if err := q.swg.AddWithContext(q.ctx); err != nil {
+			item.err = err
+			return
+		}
+		defer q.swg.Done()
+		item.infos, item.err = readDirContext(q.ctx, q.fs, item.dir.Path)
+	}()
+}
@@ -0,0 +1,145 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package lib
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ErrorPolicy decides what to do when a filter or an iterator fails on a
+// path.
+type ErrorPolicy int
+
+const (
+	// ErrorSkipLog skips the path and logs the error.
+	ErrorSkipLog ErrorPolicy = iota
+	// ErrorSkipCollect skips the path and collects the error into a report.
+	ErrorSkipCollect
+	// ErrorAbort stops the walk and returns the error.
+	ErrorAbort
+)
+
+var errorPolicyNames = map[ErrorPolicy]string{
+	ErrorSkipLog:     "log",
+	ErrorSkipCollect: "collect",
+	ErrorAbort:       "abort",
+}
+
+func (ep ErrorPolicy) String() string {
+	return errorPolicyNames[ep]
+}
+
+// ParseErrorPolicy parses name of policy, which is one of log, collect and
+// abort.
+func ParseErrorPolicy(name string) (ErrorPolicy, error) {
+	for policy, n := range errorPolicyNames {
+		if n == name {
+			return policy, nil
+		}
+	}
+	return ErrorSkipLog, fmt.Errorf("unknown error policy: %s", name)
+}
+
+// Handle handles err by policy, it return err only under ErrorAbort
+// policy.
+func (ep ErrorPolicy) Handle(err *PathError, report *ErrorReport) error {
+	switch ep {
+	case ErrorAbort:
+		return err
+	case ErrorSkipCollect:
+		report.Add(err)
+	default:
+		Logger.Warnln(err)
+	}
+	return nil
+}
+
+// PathError records an error and the operation and path caused it.
+type PathError struct {
+	Op   string
+	Path string
+	Err  error
+}
+
+func (pe *PathError) Error() string {
+	return pe.Op + " " + pe.Path + ": " + pe.Err.Error()
+}
+
+// Unwrap return the underlying error.
+func (pe *PathError) Unwrap() error {
+	return pe.Err
+}
+
+// ErrorReport collects errors of a walk, it is safe for concurrent use.
+type ErrorReport struct {
+	mu     sync.Mutex
+	errors []*PathError
+}
+
+// NewErrorReport create a empty ErrorReport.
+func NewErrorReport() *ErrorReport {
+	return &ErrorReport{
+		errors: make([]*PathError, 0),
+	}
+}
+
+// Add appends err to report.
+func (er *ErrorReport) Add(err *PathError) {
+	er.mu.Lock()
+	er.errors = append(er.errors, err)
+	er.mu.Unlock()
+}
+
+// Len return the number of errors.
+func (er *ErrorReport) Len() int {
+	er.mu.Lock()
+	defer er.mu.Unlock()
+	return len(er.errors)
+}
+
+// Errors return errors in the order they are added.
+func (er *ErrorReport) Errors() []*PathError {
+	er.mu.Lock()
+	defer er.mu.Unlock()
+	return append([]*PathError(nil), er.errors...)
+}
+
+// WriteTo writes errors to w one per line, nothing is written if there is
+// no error.
+func (er *ErrorReport) WriteTo(w io.Writer) (int64, error) {
+	errors := er.Errors()
+	if len(errors) == 0 {
+		return 0, nil
+	}
+
+	var written int64
+	n, err := fmt.Fprintf(w, "%d paths skipped by errors:\n", len(errors))
+	written += int64(n)
+	for _, e := range errors {
+		if err != nil {
+			break
+		}
+		n, err = fmt.Fprintf(w, "    %s\n", e)
+		written += int64(n)
+	}
+	return written, err
+}
@@ -0,0 +1,83 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package lib
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// FileEntry is a file or directory found by walk, with the information
+// read from its directory, so no more syscall is needed to use it.
+type FileEntry struct {
+	// Path is the path joined to the root given to walk, it is the path
+	// returned by FileIterator.Next.
+	Path string
+	// Abs is the absolute path.
+	Abs string
+	// Rel is the path relative to the root, it is "." for the root.
+	Rel string
+	// Depth is the number of directories between root and the entry, it is
+	// 1 for entries directly under root.
+	Depth int
+	Info  os.FileInfo
+	// Dev and Ino are the device and inode numbers, both are zero on
+	// platforms not supporting them.
+	Dev uint64
+	Ino uint64
+}
+
+// newFileEntry create an entry of info.
+func newFileEntry(path, abs, rel string, depth int, info os.FileInfo) FileEntry {
+	entry := FileEntry{
+		Path:  path,
+		Abs:   abs,
+		Rel:   rel,
+		Depth: depth,
+		Info:  info,
+	}
+	if stat, err := statOf(info); err == nil {
+		entry.Dev, entry.Ino = stat.dev, stat.ino
+	}
+	return entry
+}
+
+// rootEntry create the entry of root directory of walk in fsys.
+func rootEntry(fsys FileSystem, dir string) (FileEntry, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return FileEntry{}, err
+	}
+	info, err := fsys.Stat(dir)
+	if err != nil {
+		return FileEntry{}, err
+	}
+	return newFileEntry(dir, abs, ".", 0, info), nil
+}
+
+// child create the entry of info under directory fe.
+func (fe *FileEntry) child(info os.FileInfo) FileEntry {
+	name := info.Name()
+	rel := name
+	if fe.Depth > 0 {
+		rel = filepath.Join(fe.Rel, name)
+	}
+	return newFileEntry(filepath.Join(fe.Path, name), filepath.Join(fe.Abs, name), rel, fe.Depth+1, info)
+}
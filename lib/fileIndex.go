@@ -0,0 +1,374 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package lib
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// fileIndexVersion is the version of index file format.
+const fileIndexVersion = 1
+
+// IndexEntry is the state of a file recorded in FileIndex.
+type IndexEntry struct {
+	Size  int64     `json:"size"`
+	MTime time.Time `json:"mtime"`
+	Dev   uint64    `json:"dev,omitempty"`
+	Ino   uint64    `json:"ino,omitempty"`
+	// Hash is the hex encoded sha256 of content, it is empty if the index
+	// is not hashed.
+	Hash string `json:"hash,omitempty"`
+}
+
+// FileIndex records states of files under a root, keyed by slash separated
+// paths relative to root. It is used to find files changed since the last
+// scan.
+type FileIndex struct {
+	Version int                    `json:"version"`
+	Root    string                 `json:"root"`
+	Hashed  bool                   `json:"hashed"`
+	Entries map[string]*IndexEntry `json:"entries"`
+}
+
+// NewFileIndex create an empty index of files under root, hashes of
+// contents are recorded if hashed is true.
+func NewFileIndex(root string, hashed bool) (*FileIndex, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	return &FileIndex{
+		Version: fileIndexVersion,
+		Root:    root,
+		Hashed:  hashed,
+		Entries: make(map[string]*IndexEntry),
+	}, nil
+}
+
+// LoadFileIndex reads index from file path.
+func LoadFileIndex(path string) (*FileIndex, error) {
+	data, err := ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	index := &FileIndex{}
+	if err := json.Unmarshal(data, index); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if index.Version != fileIndexVersion {
+		return nil, fmt.Errorf("%s: unsupported index version %d", path, index.Version)
+	}
+	if index.Entries == nil {
+		index.Entries = make(map[string]*IndexEntry)
+	}
+	return index, nil
+}
+
+// Save writes index to file path, the file is replaced atomically.
+func (fi *FileIndex) Save(path string) error {
+	data, err := json.Marshal(fi)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp := fmt.Sprintf("%s.%d.tmp", path, os.Getpid())
+	if err := WriteFile(tmp, data); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// ChangeKind is the kind of FileChange.
+type ChangeKind int
+
+const (
+	// FileAdded is a file not in index.
+	FileAdded ChangeKind = iota
+	// FileChanged is a file different from its state in index.
+	FileChanged
+	// FileRemoved is a file in index but not found.
+	FileRemoved
+)
+
+var changeKindNames = map[ChangeKind]string{
+	FileAdded:   "added",
+	FileChanged: "changed",
+	FileRemoved: "removed",
+}
+
+func (ck ChangeKind) String() string {
+	return changeKindNames[ck]
+}
+
+// FileChange is a file added, changed or removed since the last scan.
+type FileChange struct {
+	Kind ChangeKind
+	// Path is the path of file joined to the root of index.
+	Path string
+	// Entry is the file found by the scan, it is empty for removed files.
+	Entry FileEntry
+	// Old is the state in index, it is nil for added files.
+	Old *IndexEntry
+}
+
+// ChangeIterator iterates files changed since the last scan recorded in
+// index, and updates index as it goes. Removed files are returned after
+// all added and changed ones.
+type ChangeIterator struct {
+	index    *FileIndex
+	iterator EntryIterator
+	fs       FileSystem
+	policy   ErrorPolicy
+	report   *ErrorReport
+	// verify compares hashes even if size and mtime are not changed.
+	verify bool
+
+	seen map[string]bool
+	// skipped are paths skipped by error policy, files under them keep
+	// their states in index.
+	skipped []string
+	removed []string
+	walked  bool
+
+	next *FileChange
+	err  error
+}
+
+// NewChangeIterator create a ChangeIterator walking the root of index
+// configured by opts.
+func NewChangeIterator(ctx context.Context, index *FileIndex, opts IteratorOptions) (*ChangeIterator, error) {
+	return newChangeIterator(ctx, index, opts, false)
+}
+
+func newChangeIterator(ctx context.Context, index *FileIndex, opts IteratorOptions, verify bool) (*ChangeIterator, error) {
+	ci := &ChangeIterator{
+		index:   index,
+		fs:      walkFileSystem(opts),
+		policy:  opts.ErrorPolicy,
+		report:  opts.Report,
+		verify:  verify,
+		seen:    make(map[string]bool),
+		skipped: make([]string, 0),
+	}
+	opts.skipped = ci.skip
+
+	iterator, err := NewEntryIterator(ctx, index.Root, opts)
+	if err != nil {
+		return nil, err
+	}
+	ci.iterator = iterator
+	return ci, nil
+}
+
+// skip records the path of err skipped by the walk.
+func (ci *ChangeIterator) skip(err *PathError) {
+	if rel, rerr := filepath.Rel(ci.index.Root, err.Path); rerr == nil {
+		ci.skipped = append(ci.skipped, filepath.ToSlash(rel))
+	}
+}
+
+// isSkipped checks if rel is a path skipped by the walk or under it.
+func (ci *ChangeIterator) isSkipped(rel string) bool {
+	for _, skipped := range ci.skipped {
+		if rel == skipped || strings.HasPrefix(rel, skipped+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// HasNext ...
+func (ci *ChangeIterator) HasNext() bool {
+	for ci.next == nil && ci.err == nil {
+		if !ci.walked {
+			if !ci.iterator.HasNext() {
+				ci.walked = true
+				ci.removed = ci.unseen()
+				continue
+			}
+			entry, err := ci.iterator.NextEntry()
+			if err != nil {
+				ci.err = err
+				break
+			}
+			ci.next, ci.err = ci.compare(entry)
+			continue
+		}
+
+		if len(ci.removed) == 0 {
+			return false
+		}
+		rel := ci.removed[0]
+		ci.removed = ci.removed[1:]
+		ci.next = &FileChange{
+			Kind: FileRemoved,
+			Path: filepath.Join(ci.index.Root, filepath.FromSlash(rel)),
+			Old:  ci.index.Entries[rel],
+		}
+		delete(ci.index.Entries, rel)
+	}
+	return true
+}
+
+// Next return the next change, or the error stopping the walk. The index
+// should not be saved after an error, since removed files are unknown.
+func (ci *ChangeIterator) Next() (FileChange, error) {
+	if !ci.HasNext() {
+		return FileChange{}, nil
+	}
+	if err := ci.err; err != nil {
+		ci.err = nil
+		ci.walked, ci.removed = true, nil
+		return FileChange{}, err
+	}
+
+	change := *ci.next
+	ci.next = nil
+	return change, nil
+}
+
+// unseen return sorted paths in index not found by the walk, paths skipped
+// by error policy are not removed.
+func (ci *ChangeIterator) unseen() []string {
+	removed := make([]string, 0)
+	for rel := range ci.index.Entries {
+		if !ci.seen[rel] && !ci.isSkipped(rel) {
+			removed = append(removed, rel)
+		}
+	}
+	sort.Strings(removed)
+	return removed
+}
+
+// compare updates index by entry, and return the change of entry or nil
+// if it is not changed. Errors of hashing are handled by error policy.
+func (ci *ChangeIterator) compare(entry FileEntry) (*FileChange, error) {
+	rel := filepath.ToSlash(entry.Rel)
+	ci.seen[rel] = true
+
+	current := &IndexEntry{
+		Size:  entry.Info.Size(),
+		MTime: entry.Info.ModTime(),
+		Dev:   entry.Dev,
+		Ino:   entry.Ino,
+	}
+	old, ok := ci.index.Entries[rel]
+	statChanged := !ok || old.Size != current.Size || !old.MTime.Equal(current.MTime) ||
+		old.Dev != current.Dev || old.Ino != current.Ino
+
+	if ci.index.Hashed && (statChanged || ci.verify || old.Hash == "") {
+		hash, err := HashFileFS(ci.fs, entry.Path)
+		if err != nil {
+			// a skipped file keeps its state in index
+			return nil, ci.policy.Handle(&PathError{Op: "hash", Path: entry.Path, Err: err}, ci.report)
+		}
+		current.Hash = hash
+	} else if ok {
+		current.Hash = old.Hash
+	}
+	ci.index.Entries[rel] = current
+
+	switch {
+	case !ok:
+		return &FileChange{Kind: FileAdded, Path: entry.Path, Entry: entry}, nil
+	case ci.index.Hashed && old.Hash != "" && old.Size == current.Size:
+		// a touched file with the same content is not changed
+		if old.Hash == current.Hash {
+			return nil, nil
+		}
+	case !statChanged:
+		return nil, nil
+	}
+	return &FileChange{Kind: FileChanged, Path: entry.Path, Entry: entry, Old: old}, nil
+}
+
+// Verify return changes of files under root of index without updating it,
+// hashes of all files are compared if index is hashed.
+func (fi *FileIndex) Verify(ctx context.Context, opts IteratorOptions) ([]FileChange, error) {
+	clone := *fi
+	clone.Entries = make(map[string]*IndexEntry, len(fi.Entries))
+	for rel, entry := range fi.Entries {
+		clone.Entries[rel] = entry
+	}
+
+	iterator, err := newChangeIterator(ctx, &clone, opts, true)
+	if err != nil {
+		return nil, err
+	}
+	return collectChanges(iterator)
+}
+
+// BuildFileIndex create an index of files under root configured by opts.
+func BuildFileIndex(ctx context.Context, root string, opts IteratorOptions, hashed bool) (*FileIndex, error) {
+	index, err := NewFileIndex(root, hashed)
+	if err != nil {
+		return nil, err
+	}
+	iterator, err := NewChangeIterator(ctx, index, opts)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := collectChanges(iterator); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// collectChanges return all changes of iterator.
+func collectChanges(iterator *ChangeIterator) ([]FileChange, error) {
+	changes := make([]FileChange, 0)
+	for iterator.HasNext() {
+		change, err := iterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+// HashFileFS return the hex encoded sha256 of file in fsys.
+func HashFileFS(fsys FileSystem, path string) (string, error) {
+	f, err := fileSystemOrOS(fsys).Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,194 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package lib
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scanChanges return changes of index as `kind path` sorted in order.
+func scanChanges(t *testing.T, index *FileIndex, opts IteratorOptions) []string {
+	iterator, err := NewChangeIterator(context.Background(), index, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, err := collectChanges(iterator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return formatChanges(index.Root, changes)
+}
+
+func formatChanges(root string, changes []FileChange) []string {
+	result := make([]string, 0, len(changes))
+	for _, change := range changes {
+		rel, _ := filepath.Rel(root, change.Path)
+		result = append(result, change.Kind.String()+" "+filepath.ToSlash(rel))
+	}
+	return result
+}
+
+func TestChangeIterator(t *testing.T) {
+	for _, hashed := range []bool{false, true} {
+		mfs, _ := NewMemFileSystemWithFiles(map[string]string{
+			"/r/a.txt":     "a",
+			"/r/b.txt":     "b",
+			"/r/sub/c.txt": "c",
+			"/r/sub/d.txt": "d",
+		})
+		opts := IteratorOptions{FS: mfs}
+		old := time.Now().Add(-time.Hour)
+		for _, name := range []string{"/r/a.txt", "/r/b.txt", "/r/sub/c.txt", "/r/sub/d.txt"} {
+			mfs.Chtimes(name, old, old)
+		}
+
+		index, err := BuildFileIndex(context.Background(), "/r", opts, hashed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(index.Entries) != 4 || (hashed && index.Entries["sub/c.txt"].Hash == "") {
+			t.Fatalf("Index is error: %+v", index.Entries)
+		}
+
+		WriteFileFS(mfs, "/r/b.txt", []byte("changed"))
+		WriteFileFS(mfs, "/r/e.txt", []byte("e"))
+		mfs.Remove("/r/sub/d.txt")
+		// touched without changing content
+		now := time.Now()
+		mfs.Chtimes("/r/sub/c.txt", now, now)
+
+		expected := "changed b.txt|added e.txt|removed sub/d.txt"
+		if !hashed {
+			expected = "changed b.txt|added e.txt|changed sub/c.txt|removed sub/d.txt"
+		}
+		if result := strings.Join(scanChanges(t, index, opts), "|"); result != expected {
+			t.Errorf("Changes of hashed %v index are error: %s", hashed, result)
+		}
+		if result := scanChanges(t, index, opts); len(result) != 0 {
+			t.Errorf("Index is not updated: %v", result)
+		}
+	}
+}
+
+// failingDirFileSystem is a MemFileSystem whose directory dir fails to be
+// read.
+type failingDirFileSystem struct {
+	*MemFileSystem
+	dir string
+}
+
+func (fdfs failingDirFileSystem) Open(name string) (File, error) {
+	if name == fdfs.dir {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrPermission}
+	}
+	return fdfs.MemFileSystem.Open(name)
+}
+
+func TestChangeIteratorSkippedDir(t *testing.T) {
+	mfs, _ := NewMemFileSystemWithFiles(map[string]string{
+		"/r/a.txt":        "a",
+		"/r/sub/b.txt":    "b",
+		"/r/sub/in/c.txt": "c",
+		"/r/subway/d.txt": "d",
+	})
+	index, err := BuildFileIndex(context.Background(), "/r", IteratorOptions{FS: mfs}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// files under a directory skipped by error policy keep their states
+	mfs.Remove("/r/subway/d.txt")
+	report := NewErrorReport()
+	opts := IteratorOptions{
+		FS:          failingDirFileSystem{MemFileSystem: mfs, dir: "/r/sub"},
+		ErrorPolicy: ErrorSkipCollect,
+		Report:      report,
+	}
+	if result := strings.Join(scanChanges(t, index, opts), "|"); result != "removed subway/d.txt" {
+		t.Errorf("Changes with skipped directory are error: %s", result)
+	}
+	if report.Len() != 1 || index.Entries["sub/b.txt"] == nil || index.Entries["sub/in/c.txt"] == nil {
+		t.Errorf("Entries under skipped directory are error: %v, %+v", report.Errors(), index.Entries)
+	}
+}
+
+func TestFileIndexVerify(t *testing.T) {
+	mfs, _ := NewMemFileSystemWithFiles(map[string]string{
+		"/r/a.txt": "aaaa",
+		"/r/b.txt": "bbbb",
+	})
+	opts := IteratorOptions{FS: mfs}
+	index, err := BuildFileIndex(context.Background(), "/r", opts, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// content changed with the same size and mtime
+	info, _ := mfs.Stat("/r/a.txt")
+	WriteFileFS(mfs, "/r/a.txt", []byte("AAAA"))
+	mfs.Chtimes("/r/a.txt", info.ModTime(), info.ModTime())
+
+	changes, err := index.Verify(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result := strings.Join(formatChanges("/r", changes), "|"); result != "changed a.txt" {
+		t.Errorf("Verify result is error: %s", result)
+	}
+	if result := scanChanges(t, index, opts); len(result) != 0 {
+		t.Errorf("Changes without verifying should be missed: %v", result)
+	}
+}
+
+func TestFileIndexSave(t *testing.T) {
+	dir, err := ioutil.TempDir("", "magician")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	index, _ := NewFileIndex("/r", true)
+	mtime := time.Date(2020, 2, 3, 4, 5, 6, 7, time.UTC)
+	index.Entries["a/b.txt"] = &IndexEntry{Size: 3, MTime: mtime, Ino: 42, Hash: "abc"}
+
+	path := filepath.Join(dir, "cache", "index.json")
+	if err := index.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadFileIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := loaded.Entries["a/b.txt"]
+	if loaded.Root != "/r" || !loaded.Hashed || entry == nil || !entry.MTime.Equal(mtime) || entry.Ino != 42 || entry.Hash != "abc" {
+		t.Errorf("Loaded index is error: %+v", loaded)
+	}
+
+	WriteFile(path, []byte(`{"version": 100}`))
+	if _, err := LoadFileIndex(path); err == nil {
+		t.Error("Unknown version should be invalid.")
+	}
+}
@@ -20,92 +20,156 @@
 package lib
 
 import (
-	"io/ioutil"
+	"context"
 	"os"
-	"path/filepath"
 )
 
 type defaultFileIterator struct {
-	dir string
+	// ctx stops the walk when it is done.
+	ctx    context.Context
+	walker *walker
 
 	index int
-	files []os.FileInfo
-	dirs  []string
+	files []FileEntry
 
-	filter FilterSupport
+	// err is the error stopping the walk under ErrorAbort policy, it is
+	// returned by the next call of Next.
+	err error
 }
 
 // Init ...
 func (dfi *defaultFileIterator) Init(dir string) error {
-	return dfi.loadFilesAndDirs(dir, dfi.files[:0], dfi.dirs[:0])
-}
-
-// loadFilesAndDirs ...
-func (dfi *defaultFileIterator) loadFilesAndDirs(dir string, files []os.FileInfo, dirs []string) error {
-	fs, err := ioutil.ReadDir(dir)
+	root, err := rootEntry(dfi.walker.fs, dir)
 	if err != nil {
 		return err
 	}
+	dfi.walker.descends(root)
+	infos, err := readDirContext(dfi.ctx, dfi.walker.fs, dir)
+	if err != nil {
+		return err
+	}
+	return dfi.loadFilesAndDirs(root, infos, dfi.files[:0])
+}
 
-	for _, file := range fs {
-		path := filepath.Join(dir, file.Name())
-		if !Filter(dfi.filter, path, file) {
-			continue
-		}
-
-		if file.IsDir() {
-			dirs = append(dirs, path)
-		} else {
-			files = append(files, file)
+// loadFilesAndDirs loads infos under dir, appends files to files and
+// pushes directories to queue.
+func (dfi *defaultFileIterator) loadFilesAndDirs(dir FileEntry, infos []os.FileInfo, files []FileEntry) error {
+	dfi.index = 0
+	entries, err := dfi.walker.load(dir, infos)
+
+	dirs := make([]FileEntry, 0)
+	for _, entry := range entries {
+		if !entry.Info.IsDir() {
+			if dfi.walker.returns(entry) {
+				files = append(files, entry)
+			}
+		} else if dfi.walker.descends(entry) {
+			dirs = append(dirs, entry)
 		}
 	}
+	dfi.walker.queue.Push(dirs...)
 
-	dfi.dir = dir
-	dfi.dirs = dirs
 	dfi.files = files
-	dfi.index = 0
-	return nil
+	return err
 }
 
 // HasNext ...
 func (dfi *defaultFileIterator) HasNext() bool {
-	if dfi.index < len(dfi.files) {
-		return true
-	}
+	for dfi.err == nil && dfi.index >= len(dfi.files) {
+		if dfi.walker.queue.Len() == 0 {
+			return false
+		}
 
-	if len(dfi.dirs) > 0 {
 		// read files and directories under the first dir element
-		dir := dfi.dirs[0]
-		dfi.dirs = dfi.dirs[1:]
-		err := dfi.loadFilesAndDirs(dir, dfi.files[:0], dfi.dirs[:])
+		dir, infos, err := dfi.walker.queue.Pop()
+		if ctxErr := dfi.ctx.Err(); ctxErr != nil {
+			// a cancelled walk stops whatever the policy is
+			dfi.files, dfi.index = dfi.files[:0], 0
+			dfi.err = ctxErr
+			continue
+		}
 		if err != nil {
-			panic(err)
+			dfi.files, dfi.index = dfi.files[:0], 0
+			dfi.err = dfi.walker.handleError(&PathError{Op: "readdir", Path: dir.Path, Err: err})
+			continue
 		}
-
-		return dfi.HasNext()
+		dfi.err = dfi.loadFilesAndDirs(dir, infos, dfi.files[:0])
 	}
 
-	return false
+	return true
 }
 
-// Next ...
-func (dfi *defaultFileIterator) Next() (path string, err error) {
-	defer func() {
-		if e := recover(); e != nil {
-			err = e.(error)
-			return
-		}
-	}()
+// Next return the next file, or the error stopping the walk under
+// ErrorAbort policy or by cancellation of context. The walk is over after
+// an error is returned.
+func (dfi *defaultFileIterator) Next() (string, error) {
+	entry, err := dfi.NextEntry()
+	return entry.Path, err
+}
 
+// NextEntry return the entry of next file like Next.
+func (dfi *defaultFileIterator) NextEntry() (FileEntry, error) {
 	if !dfi.HasNext() {
-		return "", nil
+		return FileEntry{}, nil
 	}
 
-	result := filepath.Join(dfi.dir, dfi.files[dfi.index].Name())
+	if err := dfi.err; err != nil {
+		dfi.err = nil
+		dfi.walker.queue.Reset()
+		dfi.files, dfi.index = nil, 0
+		return FileEntry{}, err
+	}
+
+	result := dfi.files[dfi.index]
 	dfi.index++
 	return result, nil
 }
 
+// IteratorOptions configures a file iterator.
+type IteratorOptions struct {
+	// Prune decides which directories to descend into.
+	Prune FilterSupport
+	// Filter decides which files to return.
+	Filter FilterSupport
+	// ErrorPolicy decides what to do when reading a directory or filtering
+	// a path fails.
+	ErrorPolicy ErrorPolicy
+	// Report collects errors under ErrorSkipCollect policy.
+	Report *ErrorReport
+	// Workers is the number of directories read concurrently, directories
+	// are read one by one if it is less than 2. Files are returned in the
+	// same order whatever it is.
+	Workers int
+
+	// MinDepth and MaxDepth limit the depth of returned entries, entries
+	// directly under root are of depth 1. MaxDepth is unlimited if it is 0.
+	MinDepth int
+	MaxDepth int
+	// Order decides the order directories are walked in, files of a
+	// directory are always returned together.
+	Order TraversalOrder
+	// SortBy decides the order of entries in a directory.
+	SortBy SortKey
+	// FollowSymlinks makes symlinks behave like their targets, a directory
+	// reached twice through symlinks is walked only once.
+	FollowSymlinks bool
+
+	// FS is the FileSystem to walk, it is set to filters of Prune and
+	// Filter too. OSFS is used if it is nil.
+	FS FileSystem
+	// Archives makes archives walked like directories named with `!`
+	// suffix, so files in them are returned like `notes.zip!/a.org`, see
+	// ArchiveFileSystem.
+	Archives bool
+
+	// Tracer records decisions of Prune and Filter, it is set to filters of
+	// them too. Decisions are not traced if it is nil.
+	Tracer *FilterTracer
+
+	// skipped is called with errors of paths skipped by ErrorPolicy.
+	skipped func(err *PathError)
+}
+
 func defaultFilterChain() FilterSupport {
 	var filterChain FilterSupport
 	tmp, _ := NewFilterIgnoreDotSupport()
@@ -115,29 +179,61 @@ func defaultFilterChain() FilterSupport {
 	return filterChain
 }
 
+// DefaultPruneChain return a new chain pruning dot directories, special
+// files and directories marked by `.nomagic`.
+func DefaultPruneChain() FilterSupport {
+	pruneChain := defaultFilterChain()
+	tmp, _ := NewFilterMarkerFileSupport()
+	pruneChain.Next().SetNext(tmp)
+	return pruneChain
+}
+
 func NewFileIteratorWithDefaultFilter(directory string) (FileIterator, error) {
+	return NewFileIteratorWithPrune(directory, DefaultPruneChain(), defaultFilterChain())
+}
 
-	iterator := &defaultFileIterator{
-		index:  0,
-		files:  make([]os.FileInfo, 0),
-		dirs:   make([]string, 0),
-		filter: defaultFilterChain(),
-	}
+// NewFileIterator create a new file iterator, filterChain is used for both
+// directories and files.
+func NewFileIterator(directory string, filterChain FilterSupport) (FileIterator, error) {
+	return NewFileIteratorWithPrune(directory, filterChain, filterChain)
+}
 
-	if err := iterator.Init(directory); err != nil {
-		return nil, err
-	}
+// NewFileIteratorWithPrune create a new file iterator, pruneChain decides
+// which directories to descend into and filterChain decides which files to
+// return.
+func NewFileIteratorWithPrune(directory string, pruneChain, filterChain FilterSupport) (FileIterator, error) {
+	return NewFileIteratorWithOptions(directory, IteratorOptions{
+		Prune:  pruneChain,
+		Filter: filterChain,
+	})
+}
 
-	return iterator, nil
+// NewFileIteratorWithOptions create a new file iterator configured by opts.
+// An error is returned if directory can not be read, whatever the policy
+// is.
+func NewFileIteratorWithOptions(directory string, opts IteratorOptions) (FileIterator, error) {
+	return NewFileIteratorWithContext(context.Background(), directory, opts)
 }
 
-// NewFileIterator create a new file iterator.
-func NewFileIterator(directory string, filterChain FilterSupport) (FileIterator, error) {
+// NewFileIteratorWithContext create a new file iterator configured by opts,
+// the walk stops with the error of ctx once ctx is done.
+func NewFileIteratorWithContext(ctx context.Context, directory string, opts IteratorOptions) (FileIterator, error) {
+	return NewEntryIterator(ctx, directory, opts)
+}
+
+// NewEntryIterator create a new iterator returning entries of files, it is
+// configured like NewFileIteratorWithContext.
+func NewEntryIterator(ctx context.Context, directory string, opts IteratorOptions) (EntryIterator, error) {
+	walker, err := newWalker(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
 	iterator := &defaultFileIterator{
+		ctx:    ctx,
+		walker: walker,
 		index:  0,
-		files:  make([]os.FileInfo, 0),
-		dirs:   make([]string, 0),
-		filter: filterChain,
+		files:  make([]FileEntry, 0),
 	}
 
 	if err := iterator.Init(directory); err != nil {
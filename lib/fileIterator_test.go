@@ -20,25 +20,362 @@
 package lib
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"os"
-	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
 )
 
 func TestFileIterartor(t *testing.T) {
-	home := os.Getenv("HOME")
+	mfs, err := NewMemFileSystemWithFiles(map[string]string{
+		"/home/Desktop/a.org":            "",
+		"/home/Desktop/notes/b.org":      "",
+		"/home/Desktop/notes/.draft.org": "",
+		"/home/Desktop/.cache/c.org":     "",
+		"/home/Desktop/skip/.nomagic":    "",
+		"/home/Desktop/skip/d.org":       "",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfs.Symlink("a.org", "/home/Desktop/link.org")
+
+	iterator, err := NewFileIteratorWithOptions("/home/Desktop", IteratorOptions{
+		Prune:  DefaultPruneChain(),
+		Filter: defaultFilterChain(),
+		FS:     mfs,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"/home/Desktop/a.org", "/home/Desktop/notes/b.org"}
+	if result := iterateAll(t, iterator); strings.Join(result, " ") != strings.Join(expected, " ") {
+		t.Errorf("Iterate result is error: %v", result)
+	}
+}
 
-	magicianProjectPath := path.Join(home, "Desktop")
-	iterator, err := NewFileIteratorWithDefaultFilter(magicianProjectPath)
+func TestFileIteratorFiltersFS(t *testing.T) {
+	mfs, _ := NewMemFileSystemWithFiles(map[string]string{
+		"/r/.gitignore": "*.log\n",
+		"/r/a.log":      "",
+		"/r/b.txt":      "plain text",
+		"/r/c.txt":      "TODO: fix",
+		"/r/d.png":      "\x89PNG\r\n\x1a\n\x00\x00",
+		"/r/sub/e.txt":  "TODO: more",
+		"/r/sub/f.log":  "TODO",
+	})
+	filter, err := CompileFilterExpression(`not gitignore and text and content("TODO")`, "/r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	iterator, err := NewFileIteratorWithOptions("/r", IteratorOptions{Filter: filter, FS: mfs})
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
 
+	expected := []string{"/r/c.txt", "/r/sub/e.txt"}
+	if result := iterateAll(t, iterator); strings.Join(result, " ") != strings.Join(expected, " ") {
+		t.Errorf("Iterate result is error: %v", result)
+	}
+}
+
+func TestFileIteratorWithPrune(t *testing.T) {
+	root := makeTestTree(t, map[string]string{
+		"a.org":             "",
+		"b.txt":             "",
+		"sub/c.org":         "",
+		"sub/deep/d.org":    "",
+		"skipped/.nomagic":  "",
+		"skipped/e.org":     "",
+		"skipped/sub/f.org": "",
+		".git/g.org":        "",
+	})
+	defer os.RemoveAll(root)
+
+	filter, _ := NewFilterRegexpMatchSupport(`\.org$`)
+	iterator, err := NewFileIteratorWithPrune(root, DefaultPruneChain(), filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"a.org", "sub/c.org", "sub/deep/d.org"}
+	result := iterateTestTree(t, root, iterator)
+	if strings.Join(result, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Iterate result is error:\n%s", strings.Join(result, "\n"))
+	}
+
+	// the same chain for directories and files stops recursion
+	iterator, err = NewFileIterator(root, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{"a.org"}
+	result = iterateTestTree(t, root, iterator)
+	if strings.Join(result, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Iterate result is error:\n%s", strings.Join(result, "\n"))
+	}
+}
+
+// failingSupport fails on paths with the name.
+type failingSupport struct {
+	BaseSupport
+
+	name string
+}
+
+// IsIgnore ...
+func (fs *failingSupport) IsIgnore(path string, info os.FileInfo) (bool, error) {
+	if filepath.Base(path) == fs.name {
+		return false, errors.New("failing support")
+	}
+	return false, nil
+}
+
+func TestFileIteratorErrorPolicy(t *testing.T) {
+	for _, policy := range []ErrorPolicy{ErrorSkipLog, ErrorSkipCollect, ErrorAbort} {
+		root := makeTestTree(t, map[string]string{
+			"a.txt":       "",
+			"gone/y.txt":  "",
+			"sub/bad.txt": "",
+			"sub/x.txt":   "",
+			"z/w.txt":     "",
+		})
+		defer os.RemoveAll(root)
+
+		report := NewErrorReport()
+		iterator, err := NewFileIteratorWithOptions(root, IteratorOptions{
+			Filter:      &failingSupport{name: "bad.txt"},
+			ErrorPolicy: policy,
+			Report:      report,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the directory vanishes after it is found
+		if err := os.RemoveAll(filepath.Join(root, "gone")); err != nil {
+			t.Fatal(err)
+		}
+
+		result := make([]string, 0)
+		var walkErr error
+		for iterator.HasNext() {
+			file, err := iterator.Next()
+			if err != nil {
+				walkErr = err
+				continue
+			}
+			rel, _ := filepath.Rel(root, file)
+			result = append(result, filepath.ToSlash(rel))
+		}
+
+		expected := "a.txt\nsub/x.txt\nz/w.txt"
+		if policy == ErrorAbort {
+			expected = "a.txt"
+			pe, ok := walkErr.(*PathError)
+			if !ok || pe.Op != "readdir" || pe.Path != filepath.Join(root, "gone") || !os.IsNotExist(errors.Unwrap(pe)) {
+				t.Errorf("Error under abort policy is error: %v", walkErr)
+			}
+		} else if walkErr != nil {
+			t.Errorf("Error should be skipped under %s policy: %v", policy, walkErr)
+		}
+		if strings.Join(result, "\n") != expected {
+			t.Errorf("Iterate result under %s policy is error:\n%s", policy, strings.Join(result, "\n"))
+		}
+
+		if policy == ErrorSkipCollect {
+			errs := report.Errors()
+			if len(errs) != 2 || errs[0].Op != "readdir" || errs[1].Path != filepath.Join(root, "sub/bad.txt") {
+				t.Errorf("Report is error: %v", errs)
+			}
+
+			var buf strings.Builder
+			report.WriteTo(&buf)
+			if !strings.HasPrefix(buf.String(), "2 paths skipped by errors:\n") {
+				t.Errorf("Report output is error:\n%s", buf.String())
+			}
+		} else if report.Len() != 0 {
+			t.Errorf("Report should be empty under %s policy.", policy)
+		}
+	}
+
+	if _, err := NewFileIteratorWithOptions(".", IteratorOptions{ErrorPolicy: ErrorSkipCollect}); err == nil {
+		t.Error("Collect policy without report should be invalid.")
+	}
+}
+
+// makeGeneratedTree creates a tree of depth levels under a temporary
+// directory, each directory has fanout sub directories and files files.
+func makeGeneratedTree(tb testing.TB, depth, fanout, files int) string {
+	root, err := ioutil.TempDir("", "magician")
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	var generate func(dir string, level int)
+	generate = func(dir string, level int) {
+		for i := 0; i < files; i++ {
+			if err := WriteFile(filepath.Join(dir, fmt.Sprintf("f%02d.txt", i)), nil); err != nil {
+				tb.Fatal(err)
+			}
+		}
+		if level == depth {
+			return
+		}
+		for i := 0; i < fanout; i++ {
+			sub := filepath.Join(dir, fmt.Sprintf("d%02d", i))
+			if err := os.Mkdir(sub, 0755); err != nil {
+				tb.Fatal(err)
+			}
+			generate(sub, level+1)
+		}
+	}
+	generate(root, 0)
+	return root
+}
+
+// iterateAll return files produced by iterator in order.
+func iterateAll(tb testing.TB, iterator FileIterator) []string {
+	result := make([]string, 0)
 	for iterator.HasNext() {
 		file, err := iterator.Next()
 		if err != nil {
-			t.Error(err)
+			tb.Fatal(err)
+		}
+		result = append(result, file)
+	}
+	return result
+}
+
+func TestFileIteratorWorkers(t *testing.T) {
+	root := makeGeneratedTree(t, 3, 4, 3)
+	defer os.RemoveAll(root)
+
+	iterator, err := NewFileIteratorWithOptions(root, IteratorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := iterateAll(t, iterator)
+	if len(expected) != 3*(1+4+16+64) {
+		t.Fatalf("Generated tree has %d files.", len(expected))
+	}
+
+	for _, workers := range []int{2, 8, 100} {
+		opts := IteratorOptions{Workers: workers}
+		iterator, err := NewFileIteratorWithOptions(root, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result := iterateAll(t, iterator); strings.Join(result, "\n") != strings.Join(expected, "\n") {
+			t.Errorf("Iterate order with %d workers is different.", workers)
+		}
+
+		result := make([]string, 0)
+		err = WalkFiles(context.Background(), root, opts, func(entry FileEntry) error {
+			if !entry.Info.IsDir() {
+				result = append(result, entry.Path)
+			}
+			return nil
+		})
+		if err != nil || strings.Join(result, "\n") != strings.Join(expected, "\n") {
+			t.Errorf("Walk order with %d workers is different: %v", workers, err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	iterator, err = NewFileIteratorWithContext(ctx, root, IteratorOptions{Workers: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	for iterator.HasNext() {
+		if _, err = iterator.Next(); err != nil {
+			break
+		}
+	}
+	if err != context.Canceled {
+		t.Errorf("Iterator with workers should stop after cancel: %v", err)
+	}
+}
+
+func benchmarkFileIterator(b *testing.B, workers int) {
+	root := makeGeneratedTree(b, 3, 8, 8)
+	defer os.RemoveAll(root)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		iterator, err := NewFileIteratorWithOptions(root, IteratorOptions{Workers: workers})
+		if err != nil {
+			b.Fatal(err)
+		}
+		iterateAll(b, iterator)
+	}
+}
+
+func BenchmarkFileIterator(b *testing.B) {
+	benchmarkFileIterator(b, 0)
+}
+
+func BenchmarkFileIteratorWorkers4(b *testing.B) {
+	benchmarkFileIterator(b, 4)
+}
+
+func BenchmarkFileIteratorWorkers16(b *testing.B) {
+	benchmarkFileIterator(b, 16)
+}
+
+func TestEntryIterator(t *testing.T) {
+	root := makeTestTree(t, map[string]string{
+		"a.txt":     "a",
+		"b/c/d.txt": "dd",
+	})
+	defer os.RemoveAll(root)
+
+	iterator, err := NewEntryIterator(context.Background(), root, IteratorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := make([]FileEntry, 0)
+	for iterator.HasNext() {
+		entry, err := iterator.NextEntry()
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+
+	expected := []struct {
+		rel   string
+		depth int
+		size  int64
+	}{
+		{"a.txt", 1, 1},
+		{"b/c/d.txt", 3, 2},
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("Iterate %d entries.", len(entries))
+	}
+	for i, e := range expected {
+		entry := entries[i]
+		path := filepath.Join(root, filepath.FromSlash(e.rel))
+		if entry.Path != path || entry.Abs != path || entry.Rel != filepath.FromSlash(e.rel) ||
+			entry.Depth != e.depth || entry.Info.Size() != e.size {
+			t.Errorf("Entry %d is error: %+v", i, entry)
+		}
+
+		info, _ := os.Stat(path)
+		if !os.SameFile(info, entry.Info) {
+			t.Errorf("Info of entry %d is not the file.", i)
+		}
+		if runtime.GOOS == "linux" {
+			if stat, _ := statOf(info); entry.Ino != stat.ino || entry.Dev != stat.dev {
+				t.Errorf("Inode of entry %d is error: %d", i, entry.Ino)
+			}
 		}
-		t.Logf("%s\n", file)
 	}
 }
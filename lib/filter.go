@@ -24,6 +24,7 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"strings"
 )
 
 // Filter files is designed as `chain of repositories` mode. It includes three parts,
@@ -48,6 +49,46 @@ type FilterSupport interface {
 type BaseSupport struct {
 	next FilterSupport
 	name string
+	fs   FileSystem
+}
+
+// SetFileSystem set the FileSystem used by filter to read files.
+func (bs *BaseSupport) SetFileSystem(fsys FileSystem) {
+	bs.fs = fsys
+}
+
+// FileSystem return the FileSystem used by filter, it is OSFS by default.
+func (bs *BaseSupport) FileSystem() FileSystem {
+	return fileSystemOrOS(bs.fs)
+}
+
+// fileSystemSetter is implemented by filters reading files.
+type fileSystemSetter interface {
+	SetFileSystem(fsys FileSystem)
+}
+
+// SetChainFileSystem sets the FileSystem used by filters of chain.
+func SetChainFileSystem(chain FilterSupport, fsys FileSystem) {
+	for f := chain; f != nil; f = f.Next() {
+		if setter, ok := f.(fileSystemSetter); ok {
+			setter.SetFileSystem(fsys)
+		}
+	}
+}
+
+// filterTracerSetter is implemented by filters evaluating other filters.
+type filterTracerSetter interface {
+	SetFilterTracer(ft *FilterTracer)
+}
+
+// SetChainFilterTracer sets the tracer recording decisions made inside
+// filters of chain, like operands of composite filters.
+func SetChainFilterTracer(chain FilterSupport, ft *FilterTracer) {
+	for f := chain; f != nil; f = f.Next() {
+		if setter, ok := f.(filterTracerSetter); ok {
+			setter.SetFilterTracer(ft)
+		}
+	}
 }
 
 // SetName set n to name
@@ -82,12 +123,23 @@ func (bs *BaseSupport) Next() FilterSupport {
 	return bs.next
 }
 
-// String describe the chain of FilterSupport
+// Name return the name of FilterSupport without its following chain.
+func (bs *BaseSupport) Name() string {
+	return bs.name
+}
+
+// String describe the chain of FilterSupport as a filter expression, see
+// CompileFilterExpression.
 func (bs *BaseSupport) String() string {
-	if bs.Next() == nil {
-		return bs.name
+	return chainString(bs.name, bs.Next())
+}
+
+// chainString describe a FilterSupport named name and its following chain.
+func chainString(name string, next FilterSupport) string {
+	if next == nil {
+		return name
 	}
-	return bs.name + " | " + bs.Next().String()
+	return name + " and " + next.String()
 }
 
 // Done does nothing but implement FilterSupport interface
@@ -97,29 +149,44 @@ func (bs *BaseSupport) Done(path string, info os.FileInfo) {
 
 // Fail does nothing but implement FilterSupport interface
 func (bs *BaseSupport) Fail(path string, info os.FileInfo) {
+	Logger.Debugf("%s failed to be filtered by %s\n", path, bs.name)
 }
 
 // Filter calls each IsIgnore method of each FilterSupport in chain of repositories.
+// A path failed to be filtered is ignored.
 func Filter(chain FilterSupport, path string, info os.FileInfo) bool {
-	if chain == nil {
-		return true
-	}
+	kept, _ := FilterWithError(chain, path, info)
+	return kept
+}
 
+// FilterWithError is like Filter, but it stops at the first FilterSupport
+// failed and return the error as a *PathError.
+func FilterWithError(chain FilterSupport, path string, info os.FileInfo) (bool, error) {
+	return FilterWithTracer(chain, path, info, nil)
+}
+
+// FilterWithTracer is like FilterWithError, and records decisions of chain
+// by tracer if it is not nil.
+func FilterWithTracer(chain FilterSupport, path string, info os.FileInfo, tracer *FilterTracer) (bool, error) {
 	for chain != nil {
 		result, err := chain.IsIgnore(path, info)
+		if tracer != nil {
+			tracer.Record(chain, path, result, err)
+		}
 		if err != nil {
 			chain.Fail(path, info)
+			return false, &PathError{Op: "filter " + filterName(chain), Path: path, Err: err}
 		}
 
 		if result {
 			chain.Done(path, info)
-			return false
+			return false, nil
 		}
 
 		chain = chain.Next()
 	}
 
-	return true
+	return true, nil
 }
 
 type RegexpMatchSupport struct {
@@ -150,7 +217,7 @@ func NewFilterRegexpMatchSupport(expr string) (FilterSupport, error) {
 	is := &RegexpMatchSupport{
 		pattern: pattern,
 	}
-	is.SetName(fmt.Sprintf("RegexpMatchSupport[%s]", expr))
+	is.SetName(fmt.Sprintf("regexp(%s)", quoteFilterArg(expr)))
 	return is, nil
 }
 
@@ -191,7 +258,7 @@ func NewFilterIgnoreRegexpMatchSupport(expr string) (FilterSupport, error) {
 	is := &IgnoreRegexpMatchSupport{
 		pattern: pattern,
 	}
-	is.SetName("IgnoreRegexpMatchSupport")
+	is.SetName(fmt.Sprintf("not regexp(%s)", quoteFilterArg(expr)))
 	return is, nil
 }
 
@@ -214,7 +281,7 @@ func NewFilterIgnoreUnregularSupport() (FilterSupport, error) {
 	is := &IgnoreSpecialModeSupport{
 		modeMask: os.ModeSymlink | os.ModeNamedPipe | os.ModeSocket | os.ModeDevice | os.ModeIrregular,
 	}
-	is.SetName("IgnoreUnregularSupport")
+	is.SetName("not mode(" + strings.Join(modeTypeNames(is.modeMask, false), ",") + ")")
 	return is, nil
 }
 
@@ -234,7 +301,7 @@ type IgnoreDotSupport struct {
 
 func NewFilterIgnoreDotSupport() (FilterSupport, error) {
 	is := &IgnoreDotSupport{}
-	is.SetName("IgnoreDotSupport")
+	is.SetName("not dot")
 	return is, nil
 }
 
@@ -246,3 +313,144 @@ func (ids *IgnoreDotSupport) IsIgnore(path string, info os.FileInfo) (bool, erro
 
 	return false, nil
 }
+
+// DefaultMarkerFile is the name of marker file to skip whole subtree.
+const DefaultMarkerFile = ".nomagic"
+
+// MarkerFileSupport ignores directories containing any of marker files, so
+// the whole subtree is skipped if it is used to prune directories. Files
+// are never ignored.
+type MarkerFileSupport struct {
+	BaseSupport
+
+	markers []string
+}
+
+// NewFilterMarkerFileSupport create a MarkerFileSupport, `.nomagic` is used
+// if markers is empty.
+func NewFilterMarkerFileSupport(markers ...string) (FilterSupport, error) {
+	if len(markers) == 0 {
+		markers = []string{DefaultMarkerFile}
+	}
+
+	is := &MarkerFileSupport{
+		markers: markers,
+	}
+	is.SetName("not marker(" + strings.Join(quoteFilterArgs(markers), ",") + ")")
+	return is, nil
+}
+
+// IsIgnore ...
+func (mfs *MarkerFileSupport) IsIgnore(path string, info os.FileInfo) (bool, error) {
+	if !info.IsDir() {
+		return false, nil
+	}
+
+	for _, marker := range mfs.markers {
+		_, err := mfs.FileSystem().Lstat(filepath.Join(path, marker))
+		if err == nil {
+			return true, nil
+		}
+		if !os.IsNotExist(err) {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// ExtMatchSupport keeps only paths with any of extensions, extensions are
+// compared case insensitively and without the leading dot.
+type ExtMatchSupport struct {
+	BaseSupport
+
+	exts map[string]bool
+}
+
+// NewFilterExtMatchSupport create a ExtMatchSupport.
+func NewFilterExtMatchSupport(exts ...string) (FilterSupport, error) {
+	if len(exts) == 0 {
+		return nil, fmt.Errorf("no extension")
+	}
+
+	is := &ExtMatchSupport{
+		exts: make(map[string]bool, len(exts)),
+	}
+	for _, ext := range exts {
+		is.exts[strings.ToLower(strings.TrimPrefix(ext, "."))] = true
+	}
+	is.SetName("ext(" + strings.Join(formatFilterArgs(exts), ",") + ")")
+	return is, nil
+}
+
+// IsIgnore ...
+func (ems *ExtMatchSupport) IsIgnore(path string, info os.FileInfo) (bool, error) {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+	return !ems.exts[ext], nil
+}
+
+// modeTypes maps names of file types used by mode filters to mode bits,
+// regular files have no type bits.
+var modeTypes = []struct {
+	name string
+	mode os.FileMode
+}{
+	{"dir", os.ModeDir},
+	{"regular", 0},
+	{"symlink", os.ModeSymlink},
+	{"pipe", os.ModeNamedPipe},
+	{"socket", os.ModeSocket},
+	{"device", os.ModeDevice},
+	{"irregular", os.ModeIrregular},
+}
+
+// modeTypeNames return names of file types in mask.
+func modeTypeNames(mask os.FileMode, regular bool) []string {
+	names := make([]string, 0)
+	for _, t := range modeTypes {
+		if (t.mode == 0 && regular) || (t.mode != 0 && mask&t.mode != 0) {
+			names = append(names, t.name)
+		}
+	}
+	return names
+}
+
+// ModeMatchSupport keeps only paths of any of file types.
+type ModeMatchSupport struct {
+	BaseSupport
+
+	modeMask os.FileMode
+	regular  bool
+}
+
+// NewFilterModeMatchSupport create a ModeMatchSupport, types are dir,
+// regular, symlink, pipe, socket, device and irregular.
+func NewFilterModeMatchSupport(types ...string) (FilterSupport, error) {
+	if len(types) == 0 {
+		return nil, fmt.Errorf("no file type")
+	}
+
+	is := &ModeMatchSupport{}
+	for _, name := range types {
+		found := false
+		for _, t := range modeTypes {
+			if t.name == name {
+				found = true
+				is.modeMask |= t.mode
+				is.regular = is.regular || t.mode == 0
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown file type: %s", name)
+		}
+	}
+	is.SetName("mode(" + strings.Join(modeTypeNames(is.modeMask, is.regular), ",") + ")")
+	return is, nil
+}
+
+// IsIgnore ...
+func (mms *ModeMatchSupport) IsIgnore(path string, info os.FileInfo) (bool, error) {
+	if mms.regular && info.Mode().IsRegular() {
+		return false, nil
+	}
+	return mms.modeMask&info.Mode() == 0, nil
+}
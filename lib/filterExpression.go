@@ -0,0 +1,534 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package lib
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Filter expression is the textual form of a filter tree, like
+//
+//   ext(org,md) and not path("archive/**") and size<10MB
+//
+// Predicates are combined by `and`, `or`, `not` and parentheses, `not` binds
+// tighter than `and`, and `and` tighter than `or`. A predicate is either a
+// call with arguments or a comparison:
+//
+//   ext(org,md)            extension is any of org and md
+//   path("a/**","*.org")   path relative to root matches any glob, also glob
+//   regexp("\.org$")       path matches regexp
+//   mode(dir,symlink)      file type is any of dir, regular, symlink, pipe,
+//                          socket, device and irregular
+//   dot                    name starts with a dot
+//   gitignore(".ignore")   path is ignored by ignore files, default .gitignore
+//   marker(".nomagic")     directory contains marker file, default .nomagic
+//   size<10MB              size compared by < <= > >= = !=, units are B, KB,
+//                          MB, GB and TB
+//   size(5MB,*)            size is in range, `*` is an open bound
+//   mtime<30d              age of modification time, units are w d h m s
+//   mtime>=2020-01-01      modification time
+//   atime(30d,7d)          access time is in window, ctime also works
+//   uid=1000               owner compared by id or name, also gid
+//   perm(0002)             all permission bits are set, perm(any,0111)
+//                          for any bit and perm(exact,0644) for equality
+//   mime(image/*,archive)  content type sniffed from leading bytes matches
+//   text                   content is text, binary for the opposite
+//   content("TODO")        content matches regexp
+//
+// Other predicates are looked up in the registry of FilterFactory, see
+// RegisterFilterFactory.
+//
+// Arguments are bare words or double quoted strings, in which `\` only
+// escapes `"` and `\`, so regexps need no extra escaping. The String of a
+// filter chain is a filter expression compiled to an equivalent chain.
+
+// filterComparison creates the filter of a comparison predicate.
+type filterComparison func(op, value string) (FilterSupport, error)
+
+// filterPredicates are predicates built in filter expression, other names
+// are looked up in the registry of FilterFactory.
+var filterPredicates = map[string]FilterFactory{
+	"ext": func(root string, args []string) (FilterSupport, error) {
+		return NewFilterExtMatchSupport(args...)
+	},
+	"path": func(root string, args []string) (FilterSupport, error) {
+		return NewFilterGlobMatchSupport(root, args...)
+	},
+	"glob": func(root string, args []string) (FilterSupport, error) {
+		return NewFilterGlobMatchSupport(root, args...)
+	},
+	"regexp": func(root string, args []string) (FilterSupport, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("regexp needs one argument")
+		}
+		return NewFilterRegexpMatchSupport(args[0])
+	},
+	"mode": func(root string, args []string) (FilterSupport, error) {
+		return NewFilterModeMatchSupport(args...)
+	},
+	"dot": func(root string, args []string) (FilterSupport, error) {
+		if len(args) != 0 {
+			return nil, fmt.Errorf("dot needs no argument")
+		}
+		is, _ := NewFilterIgnoreDotSupport()
+		return NewFilterNotSupport(is)
+	},
+	"gitignore": func(root string, args []string) (FilterSupport, error) {
+		is, err := NewFilterGitIgnoreSupport(root, args...)
+		if err != nil {
+			return nil, err
+		}
+		return NewFilterNotSupport(is)
+	},
+	"marker": func(root string, args []string) (FilterSupport, error) {
+		is, _ := NewFilterMarkerFileSupport(args...)
+		return NewFilterNotSupport(is)
+	},
+	"size": func(root string, args []string) (FilterSupport, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("size needs min and max")
+		}
+		bounds := []int64{-1, -1}
+		for i, arg := range args {
+			if arg == unboundedArg {
+				continue
+			}
+			size, err := ParseSize(arg)
+			if err != nil {
+				return nil, err
+			}
+			bounds[i] = size
+		}
+		return NewFilterSizeRangeSupport(bounds[0], bounds[1])
+	},
+	"mtime": timeWindowPredicate("mtime"),
+	"atime": timeWindowPredicate("atime"),
+	"ctime": timeWindowPredicate("ctime"),
+	"perm": func(root string, args []string) (FilterSupport, error) {
+		match := PermAll
+		switch len(args) {
+		case 1:
+		case 2:
+			match = PermMatch(args[0])
+			args = args[1:]
+		default:
+			return nil, fmt.Errorf("perm needs a mask")
+		}
+		mask, err := strconv.ParseUint(args[0], 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid permission: %s", args[0])
+		}
+		return NewFilterPermSupport(match, uint32(mask))
+	},
+	"mime": func(root string, args []string) (FilterSupport, error) {
+		return NewFilterMIMEMatchSupport(args...)
+	},
+	"text": func(root string, args []string) (FilterSupport, error) {
+		if len(args) != 0 {
+			return nil, fmt.Errorf("text needs no argument")
+		}
+		return NewFilterTextSupport(false)
+	},
+	"binary": func(root string, args []string) (FilterSupport, error) {
+		if len(args) != 0 {
+			return nil, fmt.Errorf("binary needs no argument")
+		}
+		return NewFilterTextSupport(true)
+	},
+	"content": func(root string, args []string) (FilterSupport, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("content needs one argument")
+		}
+		return NewFilterContentRegexpSupport(args[0])
+	},
+}
+
+func timeWindowPredicate(field string) FilterFactory {
+	return func(root string, args []string) (FilterSupport, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("%s needs from and to", field)
+		}
+		return NewFilterTimeWindowSupport(field, args[0], args[1])
+	}
+}
+
+func timeComparison(field string) filterComparison {
+	return func(op, value string) (FilterSupport, error) {
+		return NewFilterTimeSupport(field, op, value)
+	}
+}
+
+func ownerComparison(field string) filterComparison {
+	return func(op, value string) (FilterSupport, error) {
+		return NewFilterOwnerSupport(field, op, value)
+	}
+}
+
+var filterComparisons = map[string]filterComparison{
+	"size": func(op, value string) (FilterSupport, error) {
+		size, err := ParseSize(value)
+		if err != nil {
+			return nil, err
+		}
+		return NewFilterSizeSupport(op, size)
+	},
+	"mtime": timeComparison("mtime"),
+	"atime": timeComparison("atime"),
+	"ctime": timeComparison("ctime"),
+	"uid":   ownerComparison("uid"),
+	"gid":   ownerComparison("gid"),
+}
+
+// CompileFilterExpression compiles expr to a filter chain, paths are
+// matched relative to root by path predicates.
+func CompileFilterExpression(expr, root string) (FilterSupport, error) {
+	fp := &filterExprParser{input: expr, root: root}
+	chain, err := fp.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	fp.skipSpaces()
+	if fp.pos < len(fp.input) {
+		return nil, fp.errorf("unexpected %q", fp.input[fp.pos])
+	}
+	return chain, nil
+}
+
+// quoteFilterArg quotes s as a string of filter expression.
+func quoteFilterArg(s string) string {
+	var buf strings.Builder
+	buf.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '"':
+			buf.WriteString(`\"`)
+		case s[i] == '\\' && (i+1 == len(s) || s[i+1] == '"' || s[i+1] == '\\'):
+			buf.WriteString(`\\`)
+		default:
+			buf.WriteByte(s[i])
+		}
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
+
+func quoteFilterArgs(args []string) []string {
+	quoted := make([]string, 0, len(args))
+	for _, arg := range args {
+		quoted = append(quoted, quoteFilterArg(arg))
+	}
+	return quoted
+}
+
+// formatFilterArgs quotes args only if they are not bare words.
+func formatFilterArgs(args []string) []string {
+	formatted := make([]string, 0, len(args))
+	for _, arg := range args {
+		if arg == "" || strings.IndexFunc(arg, func(r rune) bool { return !isBareRune(r) }) >= 0 {
+			arg = quoteFilterArg(arg)
+		}
+		formatted = append(formatted, arg)
+	}
+	return formatted
+}
+
+func isIdentRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+func isBareRune(r rune) bool {
+	return !unicode.IsSpace(r) && !strings.ContainsRune(`,()"<>=!`, r)
+}
+
+// linkFilters links chains one after another.
+func linkFilters(chains []FilterSupport) FilterSupport {
+	tail := chains[0]
+	for _, chain := range chains[1:] {
+		for tail.Next() != nil {
+			tail = tail.Next()
+		}
+		tail.SetNext(chain)
+	}
+	return chains[0]
+}
+
+// negateFilter return a filter including paths not included by chain,
+// filters with an opposite one are replaced by it.
+func negateFilter(chain FilterSupport) (FilterSupport, error) {
+	if chain.Next() == nil {
+		switch f := chain.(type) {
+		case *NotSupport:
+			return f.operand, nil
+		case *RegexpMatchSupport:
+			return NewFilterIgnoreRegexpMatchSupport(f.pattern.String())
+		case *IgnoreRegexpMatchSupport:
+			return NewFilterRegexpMatchSupport(f.pattern.String())
+		case *GlobMatchSupport:
+			return NewFilterIgnoreGlobMatchSupport(f.root, f.globs...)
+		case *IgnoreGlobMatchSupport:
+			return NewFilterGlobMatchSupport(f.root, f.globs...)
+		}
+	}
+	return NewFilterNotSupport(chain)
+}
+
+// filterExprParser parses filter expression by recursive descent.
+type filterExprParser struct {
+	input string
+	pos   int
+	root  string
+}
+
+func (fp *filterExprParser) errorf(format string, a ...interface{}) error {
+	return fmt.Errorf("invalid filter %q at %d: %s", fp.input, fp.pos, fmt.Sprintf(format, a...))
+}
+
+func (fp *filterExprParser) peek() byte {
+	if fp.pos >= len(fp.input) {
+		return 0
+	}
+	return fp.input[fp.pos]
+}
+
+func (fp *filterExprParser) skipSpaces() {
+	for fp.pos < len(fp.input) && unicode.IsSpace(rune(fp.input[fp.pos])) {
+		fp.pos++
+	}
+}
+
+// scan consumes runes satisfied by f and return them.
+func (fp *filterExprParser) scan(f func(rune) bool) string {
+	start := fp.pos
+	for _, r := range fp.input[fp.pos:] {
+		if !f(r) {
+			break
+		}
+		fp.pos += len(string(r))
+	}
+	return fp.input[start:fp.pos]
+}
+
+// keyword consumes word if it is the next identifier.
+func (fp *filterExprParser) keyword(word string) bool {
+	fp.skipSpaces()
+	start := fp.pos
+	if fp.scan(isIdentRune) == word {
+		return true
+	}
+	fp.pos = start
+	return false
+}
+
+func (fp *filterExprParser) parseOr() (FilterSupport, error) {
+	operands := make([]FilterSupport, 0, 1)
+	for {
+		operand, err := fp.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, operand)
+
+		if !fp.keyword("or") {
+			break
+		}
+	}
+
+	if len(operands) == 1 {
+		return operands[0], nil
+	}
+	return NewFilterOrSupport(operands...)
+}
+
+func (fp *filterExprParser) parseAnd() (FilterSupport, error) {
+	operands := make([]FilterSupport, 0, 1)
+	for {
+		operand, err := fp.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, operand)
+
+		if !fp.keyword("and") {
+			break
+		}
+	}
+	return linkFilters(operands), nil
+}
+
+func (fp *filterExprParser) parseUnary() (FilterSupport, error) {
+	if fp.keyword("not") {
+		operand, err := fp.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return negateFilter(operand)
+	}
+	return fp.parsePrimary()
+}
+
+func (fp *filterExprParser) parsePrimary() (FilterSupport, error) {
+	fp.skipSpaces()
+	if fp.peek() == '(' {
+		fp.pos++
+		chain, err := fp.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		fp.skipSpaces()
+		if fp.peek() != ')' {
+			return nil, fp.errorf("expect )")
+		}
+		fp.pos++
+		return chain, nil
+	}
+
+	start := fp.pos
+	name := fp.scan(isIdentRune)
+	switch name {
+	case "":
+		return nil, fp.errorf("expect a predicate")
+	case "and", "or", "not":
+		fp.pos = start
+		return nil, fp.errorf("unexpected %s", name)
+	}
+
+	fp.skipSpaces()
+	if strings.IndexByte("<>=!", fp.peek()) >= 0 {
+		return fp.parseComparison(name)
+	}
+
+	predicate, ok := filterPredicates[name]
+	if !ok {
+		predicate, ok = LookupFilterFactory(name)
+	}
+	if !ok {
+		fp.pos = start
+		return nil, fp.errorf("unknown predicate %s", name)
+	}
+
+	args := make([]string, 0)
+	if fp.peek() == '(' {
+		var err error
+		if args, err = fp.parseArgs(); err != nil {
+			return nil, err
+		}
+	}
+
+	chain, err := predicate(fp.root, args)
+	if err != nil {
+		fp.pos = start
+		return nil, fp.errorf("%s", err)
+	}
+	return chain, nil
+}
+
+func (fp *filterExprParser) parseComparison(name string) (FilterSupport, error) {
+	start := fp.pos
+	comparison, ok := filterComparisons[name]
+	if !ok {
+		return nil, fp.errorf("unknown comparison %s", name)
+	}
+
+	op := ""
+	for _, candidate := range compareOps {
+		if strings.HasPrefix(fp.input[fp.pos:], candidate) {
+			op = candidate
+			break
+		}
+	}
+	if op == "" {
+		return nil, fp.errorf("unknown operator")
+	}
+	fp.pos += len(op)
+
+	value, err := fp.parseArg()
+	if err != nil {
+		return nil, err
+	}
+
+	chain, err := comparison(op, value)
+	if err != nil {
+		fp.pos = start
+		return nil, fp.errorf("%s", err)
+	}
+	return chain, nil
+}
+
+func (fp *filterExprParser) parseArgs() ([]string, error) {
+	// skip (
+	fp.pos++
+	args := make([]string, 0)
+	fp.skipSpaces()
+	if fp.peek() == ')' {
+		fp.pos++
+		return args, nil
+	}
+
+	for {
+		arg, err := fp.parseArg()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+
+		fp.skipSpaces()
+		switch fp.peek() {
+		case ',':
+			fp.pos++
+		case ')':
+			fp.pos++
+			return args, nil
+		default:
+			return nil, fp.errorf("expect , or )")
+		}
+	}
+}
+
+func (fp *filterExprParser) parseArg() (string, error) {
+	fp.skipSpaces()
+	if fp.peek() != '"' {
+		arg := fp.scan(isBareRune)
+		if arg == "" {
+			return "", fp.errorf("expect an argument")
+		}
+		return arg, nil
+	}
+
+	var buf strings.Builder
+	for fp.pos++; fp.pos < len(fp.input); fp.pos++ {
+		c := fp.input[fp.pos]
+		switch {
+		case c == '"':
+			fp.pos++
+			return buf.String(), nil
+		case c == '\\' && fp.pos+1 < len(fp.input) &&
+			(fp.input[fp.pos+1] == '"' || fp.input[fp.pos+1] == '\\'):
+			fp.pos++
+			buf.WriteByte(fp.input[fp.pos])
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	return "", fp.errorf("unclosed \"")
+}
@@ -0,0 +1,164 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompileFilterExpression(t *testing.T) {
+	root := makeTestTree(t, map[string]string{
+		"a.org":           "small",
+		"b.MD":            "",
+		"c.txt":           "",
+		"big.org":         strings.Repeat("x", 2048),
+		"archive/old.org": "",
+		"notes/new.md":    "",
+		".hidden.org":     "",
+	})
+	defer os.RemoveAll(root)
+
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(filepath.Join(root, "notes/new.md"), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		expr     string
+		expected []string
+	}{
+		{`ext(org,md) and not path("archive/**") and size<1KB`,
+			[]string{".hidden.org", "a.org", "b.MD", "notes/new.md"}},
+		{`(ext(org) or regexp("\.txt$")) and not dot and not path("archive/**")`,
+			[]string{"a.org", "big.org", "c.txt"}},
+		{`not (dot or ext(org, md))`, []string{"c.txt"}},
+		{`ext(org,md) and mtime>1d`, []string{"notes/new.md"}},
+		{`mode(regular) and size>=2KB`, []string{"big.org"}},
+		{`glob("**/*.{md,MD}") and mtime<1d`, []string{"b.MD"}},
+	}
+	for _, c := range cases {
+		filter, err := CompileFilterExpression(c.expr, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prune, _ := NewFilterIgnoreUnregularSupport()
+		iterator, err := NewFileIteratorWithPrune(root, prune, filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		result := iterateTestTree(t, root, iterator)
+		if strings.Join(result, "\n") != strings.Join(c.expected, "\n") {
+			t.Errorf("Filter %s result is error:\n%s", c.expr, strings.Join(result, "\n"))
+		}
+	}
+}
+
+func TestFilterExpressionRoundTrip(t *testing.T) {
+	cases := []struct {
+		expr     string
+		expected string
+	}{
+		{`ext(org,md) and not path("archive/**") and size<10MB`,
+			`ext(org,md) and not path("archive/**") and size<10MB`},
+		{`ext( org , "m d" ) or not glob(a/*.org)`,
+			`(ext(org,"m d") or not path("a/*.org"))`},
+		{`not (regexp("\.org$") and dot)`, `not (regexp("\.org$") and dot)`},
+		{`not not dot`, `dot`},
+		{`not dot and not marker and not gitignore(".ignore")`,
+			`not dot and not marker(".nomagic") and not gitignore(".ignore")`},
+		{`path("a\"b\\")`, `path("a\"b\\")`},
+		{`mode(symlink, dir) and size>=1024 and mtime<2w and mtime>=2020-01-02`,
+			`mode(dir,symlink) and size>=1KB and mtime<2w and mtime>=2020-01-02`},
+		{`(ext(a) and ext(b)) and (ext(c) or ext(d) and ext(e))`,
+			`ext(a) and ext(b) and (ext(c) or ext(d) and ext(e))`},
+	}
+	for _, c := range cases {
+		filter, err := CompileFilterExpression(c.expr, ".")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if filter.String() != c.expected {
+			t.Errorf("String of %s is error: %s", c.expr, filter.String())
+		}
+
+		again, err := CompileFilterExpression(filter.String(), ".")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again.String() != filter.String() {
+			t.Errorf("String of %s is not stable: %s", filter.String(), again.String())
+		}
+	}
+
+	dot, _ := NewFilterIgnoreDotSupport()
+	unregular, _ := NewFilterIgnoreUnregularSupport()
+	dot.SetNext(unregular)
+	if dot.String() != "not dot and not mode(symlink,pipe,socket,device,irregular)" {
+		t.Errorf("String of default chain is error: %s", dot.String())
+	}
+}
+
+func TestFilterExpressionErrors(t *testing.T) {
+	for _, expr := range []string{
+		``,
+		`ext(org`,
+		`unknown(a)`,
+		`size<10XB`,
+		`mtime<yesterday`,
+		`ext(org) and`,
+		`(ext(org)`,
+		`regexp("a", "b")`,
+		`regexp("(")`,
+		`path("a`,
+		`mode(fifo)`,
+		`ext(org) ext(md)`,
+		`name=a`,
+	} {
+		if _, err := CompileFilterExpression(expr, "."); err == nil {
+			t.Errorf("Filter %q should be invalid.", expr)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	cases := map[string]int64{
+		"512":   512,
+		"512B":  512,
+		"4k":    4096,
+		"10MB":  10 << 20,
+		"1GiB":  1 << 30,
+		"2TB":   2 << 40,
+		"1536K": 1536 << 10,
+	}
+	for s, expected := range cases {
+		size, err := ParseSize(s)
+		if err != nil || size != expected {
+			t.Errorf("Size %s should be %d, but %d, %v.", s, expected, size, err)
+		}
+	}
+	if FormatSize(1536<<10) != "1536KB" || FormatSize(0) != "0B" || FormatSize(1<<30) != "1GB" {
+		t.Error("Format size is error.")
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package lib
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// FilterFactory creates a filter with args, paths are matched relative to
+// root.
+type FilterFactory func(root string, args []string) (FilterSupport, error)
+
+// FilterSpec declares a filter by the name of its factory and arguments,
+// a list of FilterSpec in yaml is like:
+//
+//   - type: dot
+//   - type: regexp
+//     args: ['\.org$']
+//   - type: expr
+//     args: ['not path("archive/**") and size<10MB']
+type FilterSpec struct {
+	Type string   `yaml:"type" mapstructure:"type"`
+	Args []string `yaml:"args" mapstructure:"args"`
+}
+
+var (
+	factoriesMu     sync.RWMutex
+	filterFactories = map[string]FilterFactory{}
+)
+
+// Filters `dot` and `unregular` drop dot files and special files, and
+// `ignoreDot` and `ignoreUnregular` are their aliases. Other filters named
+// like predicates of filter expression keep the paths matched.
+func init() {
+	ignoreDot := func(root string, args []string) (FilterSupport, error) {
+		return NewFilterIgnoreDotSupport()
+	}
+	ignoreUnregular := func(root string, args []string) (FilterSupport, error) {
+		return NewFilterIgnoreUnregularSupport()
+	}
+
+	builtins := map[string]FilterFactory{
+		"regexp": filterPredicates["regexp"],
+		"ignoreRegexp": func(root string, args []string) (FilterSupport, error) {
+			if len(args) != 1 {
+				return nil, fmt.Errorf("ignoreRegexp needs one argument")
+			}
+			return NewFilterIgnoreRegexpMatchSupport(args[0])
+		},
+		"dot":             ignoreDot,
+		"ignoreDot":       ignoreDot,
+		"unregular":       ignoreUnregular,
+		"ignoreUnregular": ignoreUnregular,
+		"ignoreGlob": func(root string, args []string) (FilterSupport, error) {
+			return NewFilterIgnoreGlobMatchSupport(root, args...)
+		},
+		"ignoreGitignore": func(root string, args []string) (FilterSupport, error) {
+			return NewFilterGitIgnoreSupport(root, args...)
+		},
+		"ignoreMarker": func(root string, args []string) (FilterSupport, error) {
+			return NewFilterMarkerFileSupport(args...)
+		},
+		"expr": func(root string, args []string) (FilterSupport, error) {
+			if len(args) != 1 {
+				return nil, fmt.Errorf("expr needs one argument")
+			}
+			return CompileFilterExpression(args[0], root)
+		},
+	}
+	for _, name := range []string{"ext", "path", "glob", "mode", "gitignore", "marker", "size", "mtime", "atime", "ctime", "perm", "mime", "text", "binary", "content"} {
+		builtins[name] = filterPredicates[name]
+	}
+
+	for name, factory := range builtins {
+		if err := RegisterFilterFactory(name, factory); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// RegisterFilterFactory registers factory by name, so filters created by it
+// could be declared in FilterSpec and used in filter expression. It fails
+// if the name is registered.
+func RegisterFilterFactory(name string, factory FilterFactory) error {
+	if name == "" || factory == nil {
+		return fmt.Errorf("invalid filter factory %q", name)
+	}
+
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	if _, ok := filterFactories[name]; ok {
+		return fmt.Errorf("filter factory %q is registered", name)
+	}
+	filterFactories[name] = factory
+	return nil
+}
+
+// LookupFilterFactory return the factory registered by name.
+func LookupFilterFactory(name string) (FilterFactory, bool) {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	factory, ok := filterFactories[name]
+	return factory, ok
+}
+
+// FilterFactoryNames return sorted names of registered factories.
+func FilterFactoryNames() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	names := make([]string, 0, len(filterFactories))
+	for name := range filterFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewFilterChain creates filters declared by specs and links them in order,
+// it return nil if specs is empty. A path is kept if every filter keeps it.
+// Note that `- type: dot` drops dot files like `--filter 'not dot'`, since
+// the built-in predicate `dot` of filter expression keeps only dot files,
+// which is declared as `- type: expr` with args `['dot']`.
+func NewFilterChain(root string, specs []FilterSpec) (FilterSupport, error) {
+	chains := make([]FilterSupport, 0, len(specs))
+	for i, spec := range specs {
+		factory, ok := LookupFilterFactory(spec.Type)
+		if !ok {
+			return nil, fmt.Errorf("filter %d: unknown type %q", i, spec.Type)
+		}
+
+		chain, err := factory(root, spec.Args)
+		if err != nil {
+			return nil, fmt.Errorf("filter %d (%s): %s", i, spec.Type, err)
+		}
+		chains = append(chains, chain)
+	}
+
+	if len(chains) == 0 {
+		return nil, nil
+	}
+	return linkFilters(chains), nil
+}
+
+// LoadFilterChainFromFile builds a filter chain from a yaml file with a list
+// of FilterSpec.
+func LoadFilterChainFromFile(path, root string) (FilterSupport, error) {
+	specs := make([]FilterSpec, 0)
+	if err := YamlLoadFromFile(path, &specs); err != nil {
+		return nil, err
+	}
+	return NewFilterChain(root, specs)
+}
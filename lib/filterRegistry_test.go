@@ -0,0 +1,156 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// nameLengthSupport keeps only paths whose base name is shorter than max.
+type nameLengthSupport struct {
+	BaseSupport
+
+	max int
+}
+
+// IsIgnore ...
+func (nls *nameLengthSupport) IsIgnore(path string, info os.FileInfo) (bool, error) {
+	return len(filepath.Base(path)) >= nls.max, nil
+}
+
+func TestLoadFilterChainFromFile(t *testing.T) {
+	root := makeTestTree(t, map[string]string{
+		"filters.yaml": `
+- type: dot
+- type: unregular
+- type: regexp
+  args: ['\.org$']
+- type: expr
+  args: ['not path("archive/**")']
+- type: ignoreRegexp
+  args: [draft]
+`,
+		"a.org":            "",
+		"b.md":             "",
+		".hidden.org":      "",
+		"draft.org":        "",
+		"archive/old.org":  "",
+		"notes/a_note.org": "",
+	})
+	defer os.RemoveAll(root)
+
+	filter, err := LoadFilterChainFromFile(filepath.Join(root, "filters.yaml"), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedString := `not dot and not mode(symlink,pipe,socket,device,irregular) and regexp("\.org$") and not path("archive/**") and not regexp("draft")`
+	if filter.String() != expectedString {
+		t.Errorf("String of chain is error: %s", filter.String())
+	}
+
+	iterator, err := NewFileIteratorWithPrune(root, nil, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"a.org", "notes/a_note.org"}
+	result := iterateTestTree(t, root, iterator)
+	if strings.Join(result, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Iterate result is error:\n%s", strings.Join(result, "\n"))
+	}
+
+	// specs dot and ignoreDot drop dot files, and the predicate dot of
+	// expression keeps only them
+	cases := []struct {
+		specs []FilterSpec
+		only  bool
+	}{
+		{[]FilterSpec{{Type: "dot"}}, false},
+		{[]FilterSpec{{Type: "ignoreDot"}}, false},
+		{[]FilterSpec{{Type: "expr", Args: []string{"not dot"}}}, false},
+		{[]FilterSpec{{Type: "expr", Args: []string{"dot"}}}, true},
+	}
+	for _, c := range cases {
+		chain, err := NewFilterChain(root, c.specs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		iterator, _ := NewFileIteratorWithPrune(root, nil, chain)
+		result := strings.Join(iterateTestTree(t, root, iterator), " ")
+		if c.only && result != ".hidden.org" || !c.only && strings.Contains(result, ".hidden") {
+			t.Errorf("%v is error: %s", c.specs, result)
+		}
+	}
+
+	if _, err := LoadFilterChainFromFile(filepath.Join(root, "none.yaml"), root); err != ErrYamlFileNotExist {
+		t.Errorf("Loading missing file should fail: %v", err)
+	}
+}
+
+func TestRegisterFilterFactory(t *testing.T) {
+	factory := func(root string, args []string) (FilterSupport, error) {
+		is := &nameLengthSupport{max: 6}
+		is.SetName("short")
+		return is, nil
+	}
+	if err := RegisterFilterFactory("short", factory); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterFilterFactory("short", factory); err == nil {
+		t.Error("Registering a name twice should fail.")
+	}
+
+	root := makeTestTree(t, map[string]string{
+		"a.org":     "",
+		"long.org":  "",
+		"b.md":      "",
+		"long/c.md": "",
+	})
+	defer os.RemoveAll(root)
+
+	filter, err := NewFilterChain(root, []FilterSpec{{Type: "expr", Args: []string{"short or ext(md)"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	iterator, err := NewFileIteratorWithPrune(root, nil, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"a.org", "b.md", "long/c.md"}
+	result := iterateTestTree(t, root, iterator)
+	if strings.Join(result, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Iterate result is error:\n%s", strings.Join(result, "\n"))
+	}
+
+	for _, specs := range [][]FilterSpec{
+		{{Type: "unknown"}},
+		{{Type: "regexp"}},
+		{{Type: "ext"}},
+	} {
+		if _, err := NewFilterChain(root, specs); err == nil {
+			t.Errorf("Specs %v should be invalid.", specs)
+		}
+	}
+	if chain, err := NewFilterChain(root, nil); chain != nil || err != nil {
+		t.Error("Empty specs should build a nil chain.")
+	}
+}
@@ -0,0 +1,320 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package lib
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// FilterStats is the statistics of decisions made by a filter.
+type FilterStats struct {
+	Name     string
+	Accepted int
+	Ignored  int
+	Errored  int
+	// Samples are some of paths ignored or errored by the filter.
+	Samples []string
+}
+
+// FilterDecision is the decision made by a filter for a path.
+type FilterDecision struct {
+	Name    string
+	Ignored bool
+	Err     error
+	// Depth is the depth of operand in composite filters deciding with
+	// it, 0 for filters of chains.
+	Depth int
+}
+
+// FilterTracer records decisions of filters of walks it is given to by
+// IteratorOptions.Tracer, or of FilterWithTracer. It keeps statistics for
+// each filter, and records all decisions for the explained
+// path and its parent directories, which tells why the path is dropped
+// even if the iterator never reaches it.
+type FilterTracer struct {
+	mu sync.Mutex
+
+	samples int
+	stats   map[FilterSupport]*FilterStats
+	order   []*FilterStats
+
+	explained string
+	decisions map[string][]FilterDecision
+	// nesting is the depth of operands being evaluated for explained
+	// paths.
+	nesting map[string]int
+	// fs is the FileSystem of the traced walk, explained paths not visited
+	// are looked up in it.
+	fs FileSystem
+}
+
+// NewFilterTracer create a FilterTracer keeping at most samples sample
+// paths for each filter.
+func NewFilterTracer(samples int) *FilterTracer {
+	return &FilterTracer{
+		samples:   samples,
+		stats:     make(map[FilterSupport]*FilterStats),
+		order:     make([]*FilterStats, 0),
+		decisions: make(map[string][]FilterDecision),
+		nesting:   make(map[string]int),
+	}
+}
+
+// setFileSystem set the FileSystem of the traced walk.
+func (ft *FilterTracer) setFileSystem(fsys FileSystem) {
+	ft.mu.Lock()
+	ft.fs = fsys
+	ft.mu.Unlock()
+}
+
+// Explain records decisions for path and its parent directories.
+func (ft *FilterTracer) Explain(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	ft.mu.Lock()
+	ft.explained = abs
+	ft.mu.Unlock()
+	return nil
+}
+
+// filterName return the name of filter f without its following chain.
+func filterName(f FilterSupport) string {
+	if named, ok := f.(interface{ Name() string }); ok {
+		return named.Name()
+	}
+	return f.String()
+}
+
+// explains checks if decisions for path should be recorded, it should be
+// called with mu held.
+func (ft *FilterTracer) explains(path string) bool {
+	if ft.explained == "" {
+		return false
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	return abs == ft.explained || strings.HasPrefix(ft.explained, abs+string(filepath.Separator))
+}
+
+// Record records the decision of f for path.
+func (ft *FilterTracer) Record(f FilterSupport, path string, ignored bool, err error) {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+
+	stats, ok := ft.stats[f]
+	if !ok {
+		stats = &FilterStats{Name: filterName(f), Samples: make([]string, 0, ft.samples)}
+		ft.stats[f] = stats
+		ft.order = append(ft.order, stats)
+	}
+
+	switch {
+	case err != nil:
+		stats.Errored++
+	case ignored:
+		stats.Ignored++
+	default:
+		stats.Accepted++
+	}
+	if (err != nil || ignored) && len(stats.Samples) < ft.samples {
+		stats.Samples = append(stats.Samples, path)
+	}
+
+	ft.recordDecision(stats.Name, path, ignored, err)
+}
+
+// recordDecision records the decision of filter named name for path if
+// path is explained, it should be called with mu held.
+func (ft *FilterTracer) recordDecision(name, path string, ignored bool, err error) {
+	if !ft.explains(path) {
+		return
+	}
+	abs, _ := filepath.Abs(path)
+	ft.decisions[abs] = append(ft.decisions[abs], FilterDecision{
+		Name:    name,
+		Ignored: ignored,
+		Err:     err,
+		Depth:   ft.nesting[abs],
+	})
+}
+
+// recordOperand records the decision of f, a filter in operand of a
+// composite filter, for path. Operands are only recorded for explained
+// paths, statistics are kept for filters of chains.
+func (ft *FilterTracer) recordOperand(f FilterSupport, path string, ignored bool, err error) {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	ft.recordDecision(filterName(f), path, ignored, err)
+}
+
+// enterOperands increases the depth of decisions recorded for path, it is
+// called before a composite filter evaluates its operands and undone by
+// calling the returned function.
+func (ft *FilterTracer) enterOperands(path string) func() {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	if !ft.explains(path) {
+		return func() {}
+	}
+
+	abs, _ := filepath.Abs(path)
+	ft.nesting[abs]++
+	return func() {
+		ft.mu.Lock()
+		defer ft.mu.Unlock()
+		if ft.nesting[abs]--; ft.nesting[abs] == 0 {
+			delete(ft.nesting, abs)
+		}
+	}
+}
+
+// nestDecisions reorders decisions, where operands are recorded before
+// the composite filter deciding with them, so that every composite filter
+// is followed by its operands.
+func nestDecisions(decisions []FilterDecision) []FilterDecision {
+	type node struct {
+		decision FilterDecision
+		children []*node
+	}
+
+	pending := make([]*node, 0, len(decisions))
+	for _, decision := range decisions {
+		n := &node{decision: decision}
+		i := len(pending)
+		for i > 0 && pending[i-1].decision.Depth > decision.Depth {
+			i--
+		}
+		n.children = append(n.children, pending[i:]...)
+		pending = append(pending[:i], n)
+	}
+
+	nested := make([]FilterDecision, 0, len(decisions))
+	var walk func(nodes []*node)
+	walk = func(nodes []*node) {
+		for _, n := range nodes {
+			nested = append(nested, n.decision)
+			walk(n.children)
+		}
+	}
+	walk(pending)
+	return nested
+}
+
+// Stats return statistics of filters in the order they are first called.
+func (ft *FilterTracer) Stats() []FilterStats {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+
+	result := make([]FilterStats, 0, len(ft.order))
+	for _, stats := range ft.order {
+		s := *stats
+		s.Samples = append([]string(nil), stats.Samples...)
+		result = append(result, s)
+	}
+	return result
+}
+
+// Decisions return decisions recorded for path, nil if path is not
+// evaluated by any filter.
+func (ft *FilterTracer) Decisions(path string) []FilterDecision {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil
+	}
+
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	return append([]FilterDecision(nil), ft.decisions[abs]...)
+}
+
+// WriteStats writes a table of statistics to w.
+func (ft *FilterTracer) WriteStats(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "accepted\tignored\terrored\t\tfilter")
+	for _, stats := range ft.Stats() {
+		fmt.Fprintf(tw, "%d\t%d\t%d\t\t%s\n", stats.Accepted, stats.Ignored, stats.Errored, stats.Name)
+		for _, sample := range stats.Samples {
+			fmt.Fprintf(tw, "\t\t\t\t    %s\n", sample)
+		}
+	}
+	return tw.Flush()
+}
+
+// WriteExplain writes decisions for the explained path and its parent
+// directories to w.
+func (ft *FilterTracer) WriteExplain(w io.Writer) error {
+	ft.mu.Lock()
+	explained := ft.explained
+	fsys := fileSystemOrOS(ft.fs)
+	ft.mu.Unlock()
+	if explained == "" {
+		return nil
+	}
+
+	// parent directories from the top one with decisions
+	paths := []string{explained}
+	for dir := filepath.Dir(explained); dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		paths = append([]string{dir}, paths...)
+	}
+	for len(paths) > 1 && ft.Decisions(paths[0]) == nil {
+		paths = paths[1:]
+	}
+
+	for _, path := range paths {
+		decisions := ft.Decisions(path)
+		if len(decisions) == 0 {
+			if _, err := fsys.Lstat(path); err != nil {
+				fmt.Fprintf(w, "%s: %s\n", path, err)
+			} else {
+				fmt.Fprintf(w, "%s: not visited\n", path)
+			}
+			continue
+		}
+
+		verdict := "kept"
+		last := decisions[len(decisions)-1]
+		if last.Ignored {
+			verdict = "ignored by " + last.Name
+		}
+		fmt.Fprintf(w, "%s: %s\n", path, verdict)
+		// operands are indented under composite filters
+		for _, decision := range nestDecisions(decisions) {
+			result := "kept"
+			if decision.Ignored {
+				result = "ignored"
+			}
+			if decision.Err != nil {
+				result += " (" + decision.Err.Error() + ")"
+			}
+			fmt.Fprintf(w, "%s%s: %s\n", strings.Repeat("    ", decision.Depth+1), decision.Name, result)
+		}
+	}
+	return nil
+}
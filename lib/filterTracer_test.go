@@ -0,0 +1,204 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package lib
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFilterTracer(t *testing.T) {
+	root := makeTestTree(t, map[string]string{
+		"a.org":               "",
+		"b.txt":               "",
+		".hidden/c.org":       "",
+		"skipped/.nomagic":    "",
+		"skipped/deep/d.org":  "",
+		"notes/e.org":         "",
+		"notes/f.md":          "",
+		"notes/.draft.org":    "",
+		"notes/archive/g.org": "",
+	})
+	defer os.RemoveAll(root)
+
+	tracer := NewFilterTracer(1)
+	if err := tracer.Explain(filepath.Join(root, "skipped/deep/d.org")); err != nil {
+		t.Fatal(err)
+	}
+
+	filter, err := CompileFilterExpression(`not dot and ext(org)`, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iterator, err := NewFileIteratorWithOptions(root, IteratorOptions{
+		Prune:  DefaultPruneChain(),
+		Filter: filter,
+		Tracer: tracer,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	iterateTestTree(t, root, iterator)
+
+	// filters are ordered by the first call, directories and files are
+	// read in order of names
+	expected := []FilterStats{
+		{Name: "not dot", Accepted: 3, Ignored: 1},
+		{Name: "not dot", Accepted: 5, Ignored: 1},
+		{Name: "ext(org)", Accepted: 3, Ignored: 2},
+		{Name: "not mode(symlink,pipe,socket,device,irregular)", Accepted: 3},
+		{Name: `not marker(".nomagic")`, Accepted: 2, Ignored: 1},
+	}
+	stats := tracer.Stats()
+	if len(stats) != len(expected) {
+		t.Fatalf("Stats of tracer is error: %v", stats)
+	}
+	for i, s := range stats {
+		e := expected[i]
+		if s.Name != e.Name || s.Accepted != e.Accepted || s.Ignored != e.Ignored || s.Errored != e.Errored {
+			t.Errorf("Stats %d is error: %+v", i, s)
+		}
+		if len(s.Samples) != 0 && len(s.Samples) != 1 {
+			t.Errorf("Stats %d should keep at most 1 sample: %v", i, s.Samples)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := tracer.WriteExplain(&buf); err != nil {
+		t.Fatal(err)
+	}
+	explain := strings.Replace(buf.String(), root, "<root>", -1)
+	expectedExplain := `<root>/skipped: ignored by not marker(".nomagic")
+    not dot: kept
+    not mode(symlink,pipe,socket,device,irregular): kept
+    not marker(".nomagic"): ignored
+<root>/skipped/deep: not visited
+<root>/skipped/deep/d.org: not visited
+`
+	if explain != expectedExplain {
+		t.Errorf("Explain is error:\n%s", explain)
+	}
+
+	buf.Reset()
+	if err := tracer.WriteStats(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "ext(org)") {
+		t.Errorf("Stats table is error:\n%s", buf.String())
+	}
+}
+
+func TestFilterTracerOperands(t *testing.T) {
+	root := makeTestTree(t, map[string]string{
+		"notes/archive/g.org": "",
+	})
+	defer os.RemoveAll(root)
+
+	tracer := NewFilterTracer(1)
+	tracer.Explain(filepath.Join(root, "notes/archive/g.org"))
+
+	filter, err := CompileFilterExpression(`ext(md) or (ext(org) and not path("**/archive/**"))`, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iterator, err := NewFileIteratorWithOptions(root, IteratorOptions{Filter: filter, Tracer: tracer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	iterateTestTree(t, root, iterator)
+
+	var buf bytes.Buffer
+	tracer.WriteExplain(&buf)
+	explain := strings.Replace(buf.String(), root, "<root>", -1)
+	// and of operands is compiled to a chain
+	name := `(ext(md) or ext(org) and not path("**/archive/**"))`
+	expected := `<root>/notes/archive/g.org: ignored by ` + name + `
+    ` + name + `: ignored
+        ext(md): ignored
+        ext(org): kept
+        not path("**/archive/**"): ignored
+`
+	if explain != expected {
+		t.Errorf("Explain of operands is error:\n%s\nexpected:\n%s", explain, expected)
+	}
+	if stats := tracer.Stats(); len(stats) != 1 || stats[0].Name != name {
+		t.Errorf("Stats should only be kept for filters of chains: %v", stats)
+	}
+
+	// operands are recorded before the composite filters deciding with them
+	decisions := nestDecisions([]FilterDecision{
+		{Name: "a", Depth: 0},
+		{Name: "c", Depth: 2},
+		{Name: "d", Depth: 2},
+		{Name: "b", Depth: 1},
+		{Name: "e", Depth: 1},
+		{Name: "(b or e)", Depth: 0},
+	})
+	names := make([]string, 0, len(decisions))
+	for _, decision := range decisions {
+		names = append(names, strings.Repeat(" ", decision.Depth)+decision.Name)
+	}
+	if got := strings.Join(names, ","); got != "a,(b or e), b,  c,  d, e" {
+		t.Errorf("Nested decisions are error: %s", got)
+	}
+}
+
+func TestFilterTracerPerWalk(t *testing.T) {
+	mfs, _ := NewMemFileSystemWithFiles(map[string]string{
+		"/root/a.org":        "",
+		"/root/.hidden/b.md": "",
+	})
+
+	// walks with their own tracers do not share decisions
+	tracers := []*FilterTracer{NewFilterTracer(1), NewFilterTracer(1), nil}
+	for _, tracer := range tracers {
+		filter, _ := CompileFilterExpression(`not (dot or ext(md))`, "/root")
+		opts := IteratorOptions{Prune: filter, Filter: filter, FS: mfs, Tracer: tracer}
+		if tracer != nil {
+			tracer.Explain("/root/.hidden/b.md")
+		}
+		if err := WalkFiles(context.Background(), "/root", opts, func(FileEntry) error { return nil }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, tracer := range tracers[:2] {
+		if stats := tracer.Stats(); len(stats) != 1 || stats[0].Accepted != 1 || stats[0].Ignored != 1 {
+			t.Errorf("Stats of tracer %d is error: %+v", i, stats)
+		}
+	}
+
+	// explained paths not visited are looked up in the walked FileSystem
+	var buf bytes.Buffer
+	tracers[0].WriteExplain(&buf)
+	expected := `/root/.hidden: ignored by not (dot or ext(md))
+    not (dot or ext(md)): ignored
+        (dot or ext(md)): kept
+            dot: kept
+                not dot: ignored
+/root/.hidden/b.md: not visited
+`
+	if buf.String() != expected {
+		t.Errorf("Explain is error:\n%s", buf.String())
+	}
+}
@@ -21,8 +21,6 @@ package lib
 
 import (
 	"bufio"
-	"fmt"
-	"io"
 	"io/ioutil"
 	"os"
 	"os/user"
@@ -35,6 +33,13 @@ type FileIterator interface {
 	Next() (string, error)
 }
 
+// EntryIterator is a FileIterator returning entries of files, which carry
+// the information read during the walk.
+type EntryIterator interface {
+	FileIterator
+	NextEntry() (FileEntry, error)
+}
+
 // FileLineParser defines which file to be parsed and how to parse it.
 type FileLineParser interface {
 	// FilePath return the path of the file to be parsed
@@ -44,109 +49,95 @@ type FileLineParser interface {
 	Parse(line string) (interface{}, error)
 }
 
-// CopyFile copies a file from src to dst. If src and dst files exist, and are
-// the same, then return success. Otherise, copy the file contents from src to dst.
-func CopyFile(src, dst string) (err error) {
-	Logger.Debugf("Copy file %s to %s...", src, dst)
-	sfi, err := os.Stat(src)
-	if err != nil {
-		return
-	}
-	if !sfi.Mode().IsRegular() {
-		// cannot copy non-regular files (e.g., directories,
-		// symlinks, devices, etc.)
-		return fmt.Errorf("CopyFile: non-regular source file %s (%q)", sfi.Name(), sfi.Mode().String())
-	}
-	dfi, err := os.Stat(dst)
-	if err != nil {
-		if !os.IsNotExist(err) {
-			return
-		}
-	} else {
-		if !(dfi.Mode().IsRegular()) {
-			return fmt.Errorf("CopyFile: non-regular destination file %s (%q)", dfi.Name(), dfi.Mode().String())
-		}
-		if os.SameFile(sfi, dfi) {
-			return
-		}
-	}
-	err = copyFileContents(src, dst)
-	Logger.Debugf("Finish Copying from %s to %s...", src, dst)
-	return
-}
-
-// copyFileContents copies the contents of the file named src to the file named
-// by dst. The file will be created if it does not already exist. If the
-// destination file exists, all it's contents will be replaced by the contents
-// of the source file.
-func copyFileContents(src, dst string) (err error) {
-	in, err := os.Open(src)
-	if err != nil {
-		return
-	}
-	defer in.Close()
-	out, err := os.Create(dst)
-	if err != nil {
-		return
-	}
-	defer func() {
-		cerr := out.Close()
-		if err == nil {
-			err = cerr
-		}
-	}()
-	buf := make([]byte, os.Getpagesize())
-	for {
-		n, err := in.Read(buf)
-		if err != nil && err != io.EOF {
-			return err
-		}
-		if n == 0 {
-			break
-		}
-
-		if _, err := out.Write(buf[:n]); err != nil {
-			return err
-		}
-	}
-	err = out.Sync()
-	return
+func IsDir(path string) bool {
+	return IsDirFS(OSFS, path)
 }
 
-func IsDir(path string) bool {
-	fi, err := os.Stat(path)
+// IsDirFS checks if path is a directory in fsys.
+func IsDirFS(fsys FileSystem, path string) bool {
+	fi, err := fsys.Stat(path)
 	return err == nil && fi.IsDir()
 }
 
 func IsFile(path string) bool {
-	fi, err := os.Stat(path)
+	return IsFileFS(OSFS, path)
+}
+
+// IsFileFS checks if path is a regular file in fsys.
+func IsFileFS(fsys FileSystem, path string) bool {
+	fi, err := fsys.Stat(path)
 	return err == nil && fi.Mode()&os.ModeType == 0
 }
 
 func IsNotExist(path string) bool {
-	_, err := os.Stat(path)
+	return IsNotExistFS(OSFS, path)
+}
+
+// IsNotExistFS checks if path does not exist in fsys.
+func IsNotExistFS(fsys FileSystem, path string) bool {
+	_, err := fsys.Stat(path)
 	return os.IsNotExist(err)
 }
 
-// WriteFile write data to file, and create its directories if necessary
+// WriteFile write data to file, and create its directories if necessary.
+// Files in archives like `notes.zip!/a.org` could not be written.
 func WriteFile(path string, data []byte) error {
+	if NewArchiveFileSystem(OSFS).Contains(path) {
+		return readOnly("write", path)
+	}
 	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
 		Logger.Fatal(err)
 	}
 	return ioutil.WriteFile(path, data, 0664)
 }
 
+// WriteFileFS write data to file in fsys, and create its directories if
+// necessary.
+func WriteFileFS(fsys FileSystem, path string, data []byte) error {
+	if err := fsys.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := fsys.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0664)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
 // ReadFile just keep the same style as WriteFile
 func ReadFile(path string) ([]byte, error) {
 	return ioutil.ReadFile(path)
 }
 
+// ReadFileFS reads the whole file in fsys.
+func ReadFileFS(fsys FileSystem, path string) ([]byte, error) {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
 // ScanLines read file line by line and call Parse method of FileLineParser
 // for each line.
 //
 // This function only collect non-nil result returned by Parser().
 func ScanLines(parser FileLineParser) ([]interface{}, error) {
-	fileHandle, _ := os.Open(parser.FilePath())
+	return ScanLinesFS(OSFS, parser)
+}
+
+// ScanLinesFS reads file in fsys like ScanLines, files in archives like
+// `notes.zip!/a.org` are read by ArchiveFileSystem.
+func ScanLinesFS(fsys FileSystem, parser FileLineParser) ([]interface{}, error) {
+	fileHandle, err := fsys.Open(parser.FilePath())
+	if err != nil {
+		return nil, err
+	}
 	defer fileHandle.Close()
 
 	fileScanner := bufio.NewScanner(fileHandle)
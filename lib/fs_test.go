@@ -20,15 +20,21 @@
 package lib
 
 import (
+	"os"
 	"strings"
 	"testing"
 )
 
-type defaultFileLineParser struct{}
+type defaultFileLineParser struct {
+	path string
+}
 
 // FilePath ...
 func (dflp *defaultFileLineParser) FilePath() string {
-	return "./fs.go"
+	if dflp.path == "" {
+		return "./fs.go"
+	}
+	return dflp.path
 }
 
 // Parse ...
@@ -51,3 +57,20 @@ func TestScanLines(t *testing.T) {
 	}
 
 }
+
+func TestScanLinesFS(t *testing.T) {
+	mfs, err := NewMemFileSystemWithFiles(map[string]string{
+		"/src/a.go": "package a\nfunc A() {}\n\nfunc B() {}\n",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines, err := ScanLinesFS(mfs, &defaultFileLineParser{path: "/src/a.go"})
+	if err != nil || len(lines) != 2 || lines[1] != "func B() {}" {
+		t.Errorf("Scan result is error: %v, %v", lines, err)
+	}
+	if _, err := ScanLinesFS(mfs, &defaultFileLineParser{path: "/src/b.go"}); !os.IsNotExist(err) {
+		t.Errorf("Scanning missing file should fail: %v", err)
+	}
+}
@@ -0,0 +1,239 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// DefaultGitIgnoreFile is the name of ignore file loaded by default.
+const DefaultGitIgnoreFile = ".gitignore"
+
+// gitIgnorePattern is a compiled pattern line of ignore file.
+type gitIgnorePattern struct {
+	pattern *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// parseGitIgnorePattern compiles a line of ignore file, it returns nil for
+// blank lines and comments.
+func parseGitIgnorePattern(line string) (*gitIgnorePattern, error) {
+	// trailing spaces are ignored unless they are escaped
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+		line = line[:len(line)-1]
+	}
+	if line == "" || line[0] == '#' {
+		return nil, nil
+	}
+
+	p := &gitIgnorePattern{}
+	if line[0] == '!' {
+		p.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return nil, nil
+	}
+
+	// a pattern with a slash at the beginning or middle is relative to the
+	// directory of ignore file, otherwise it matches at any level below it
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	body, err := globToRegexp(line)
+	if err != nil {
+		return nil, err
+	}
+	if !anchored {
+		body = "(?:.*/)?" + body
+	}
+
+	p.pattern, err = regexp.Compile("^" + body + "$")
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// GitIgnoreSupport ignores files according to `.gitignore` style files.
+// Ignore files are loaded at each directory level as iterator goes down,
+// and patterns in deeper files take precedence over upper ones.
+type GitIgnoreSupport struct {
+	BaseSupport
+
+	root  string
+	names []string
+
+	mu       sync.Mutex
+	patterns map[string][]*gitIgnorePattern
+	ignored  map[string]bool
+}
+
+// NewFilterGitIgnoreSupport create a GitIgnoreSupport for files under root,
+// names are the ignore files to load in each directory, `.gitignore` is
+// used if names is empty.
+func NewFilterGitIgnoreSupport(root string, names ...string) (FilterSupport, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		names = []string{DefaultGitIgnoreFile}
+	}
+
+	is := &GitIgnoreSupport{
+		root:     root,
+		names:    names,
+		patterns: make(map[string][]*gitIgnorePattern),
+		ignored:  make(map[string]bool),
+	}
+	is.SetName("not gitignore(" + strings.Join(quoteFilterArgs(names), ",") + ")")
+	return is, nil
+}
+
+// loadPatterns return patterns of ignore files in dir, it should be called
+// with mu held.
+func (gis *GitIgnoreSupport) loadPatterns(dir string) ([]*gitIgnorePattern, error) {
+	if patterns, ok := gis.patterns[dir]; ok {
+		return patterns, nil
+	}
+
+	patterns := make([]*gitIgnorePattern, 0)
+	for _, name := range gis.names {
+		data, err := ReadFileFS(gis.FileSystem(), filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			p, err := parseGitIgnorePattern(scanner.Text())
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", filepath.Join(dir, name), err)
+			}
+			if p != nil {
+				patterns = append(patterns, p)
+			}
+		}
+	}
+
+	gis.patterns[dir] = patterns
+	return patterns, nil
+}
+
+// match checks rel, a slash separated path relative to root, against
+// patterns of its ancestors. It should be called with mu held.
+func (gis *GitIgnoreSupport) match(rel string, isDir bool) (bool, error) {
+	ignored := false
+	dir := gis.root
+	segments := strings.Split(rel, "/")
+	for i := range segments {
+		patterns, err := gis.loadPatterns(dir)
+		if err != nil {
+			return false, err
+		}
+
+		sub := strings.Join(segments[i:], "/")
+		for _, p := range patterns {
+			if p.dirOnly && !isDir {
+				continue
+			}
+			if p.pattern.MatchString(sub) {
+				ignored = !p.negate
+			}
+		}
+		dir = filepath.Join(dir, segments[i])
+	}
+	return ignored, nil
+}
+
+// isIgnoredDir checks if directory rel or any of its ancestors is ignored.
+// It should be called with mu held.
+func (gis *GitIgnoreSupport) isIgnoredDir(rel string) (bool, error) {
+	if rel == "." || rel == "" {
+		return false, nil
+	}
+	if ignored, ok := gis.ignored[rel]; ok {
+		return ignored, nil
+	}
+
+	ignored, err := gis.isIgnoredDir(pathDir(rel))
+	if err == nil && !ignored {
+		ignored, err = gis.match(rel, true)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	gis.ignored[rel] = ignored
+	return ignored, nil
+}
+
+// IsIgnore ...
+func (gis *GitIgnoreSupport) IsIgnore(path string, info os.FileInfo) (bool, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false, err
+	}
+	rel, err := filepath.Rel(gis.root, abs)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false, err
+	}
+	rel = filepath.ToSlash(rel)
+
+	gis.mu.Lock()
+	defer gis.mu.Unlock()
+
+	// a path can not be re-included if its parent directory is ignored
+	if ignored, err := gis.isIgnoredDir(pathDir(rel)); err != nil || ignored {
+		return ignored, err
+	}
+	if info.IsDir() {
+		return gis.isIgnoredDir(rel)
+	}
+	return gis.match(rel, false)
+}
+
+// pathDir return the parent of a slash separated relative path, or "." for
+// top level ones.
+func pathDir(rel string) string {
+	if i := strings.LastIndexByte(rel, '/'); i >= 0 {
+		return rel[:i]
+	}
+	return "."
+}
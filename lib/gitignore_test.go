@@ -0,0 +1,184 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// makeTestTree creates files with contents under a temporary directory,
+// keys of files are slash separated paths.
+func makeTestTree(t *testing.T, files map[string]string) string {
+	root, err := ioutil.TempDir("", "magician")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := WriteFile(filepath.Join(root, filepath.FromSlash(name)), []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// iterateTestTree return sorted slash separated paths relative to root
+// produced by iterator.
+func iterateTestTree(t *testing.T, root string, iterator FileIterator) []string {
+	result := make([]string, 0)
+	for iterator.HasNext() {
+		file, err := iterator.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		rel, _ := filepath.Rel(root, file)
+		result = append(result, filepath.ToSlash(rel))
+	}
+	sort.Strings(result)
+	return result
+}
+
+func TestGitIgnoreSupport(t *testing.T) {
+	root := makeTestTree(t, map[string]string{
+		".gitignore":           "# comment\n*.log\n!keep.log\n/build/\ndocs/**/*.tmp\n",
+		"a.go":                 "",
+		"a.log":                "",
+		"keep.log":             "",
+		"build/out":            "",
+		"src/build/main.go":    "",
+		"src/debug.log":        "",
+		"src/.gitignore":       "*.go\n!main.go\ncache/\n",
+		"src/util.go":          "",
+		"src/main.go":          "",
+		"src/cache/x":          "",
+		"docs/a.tmp":           "",
+		"docs/sub/deep/b.tmp":  "",
+		"docs/readme.md":       "",
+		"vendor/pkg/lib.go":    "",
+		"vendor/.gitignore":    "pkg\n!pkg/lib.go\n",
+		"vendor/other/lib.log": "",
+		"..notes/a.log":        "",
+		"..notes/a.md":         "",
+	})
+	defer os.RemoveAll(root)
+
+	filter, err := NewFilterGitIgnoreSupport(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iterator, err := NewFileIterator(root, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"..notes/a.md",
+		".gitignore",
+		"a.go",
+		"docs/readme.md",
+		"keep.log",
+		"src/.gitignore",
+		"src/build/main.go",
+		"src/main.go",
+		"vendor/.gitignore",
+	}
+	result := iterateTestTree(t, root, iterator)
+	if strings.Join(result, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Iterate result is error:\n%s", strings.Join(result, "\n"))
+	}
+}
+
+func TestGitIgnoreSupportWithoutIterator(t *testing.T) {
+	root := makeTestTree(t, map[string]string{
+		".ignore":       "build/\n",
+		"build/a/b.txt": "",
+		"c.txt":         "",
+	})
+	defer os.RemoveAll(root)
+
+	filter, err := NewFilterGitIgnoreSupport(root, ".ignore")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// files under ignored directory are ignored even if the directory is
+	// not visited before
+	cases := map[string]bool{
+		"build/a/b.txt": false,
+		"c.txt":         true,
+	}
+	for name, kept := range cases {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		info, err := os.Lstat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if Filter(filter, path, info) != kept {
+			t.Errorf("%s should be kept: %v", name, kept)
+		}
+	}
+}
+
+func TestParseGitIgnorePattern(t *testing.T) {
+	cases := []struct {
+		pattern string
+		path    string
+		matched bool
+	}{
+		{"*.o", "a/b/c.o", true},
+		{"/*.o", "a/c.o", false},
+		{"/*.o", "c.o", true},
+		{"a/*.o", "a/c.o", true},
+		{"a/*.o", "b/a/c.o", false},
+		{"**/foo", "x/y/foo", true},
+		{"**/foo", "foo", true},
+		{"a/**/b", "a/b", true},
+		{"a/**/b", "a/x/y/b", true},
+		{"abc/**", "abc/x/y", true},
+		{"abc/**", "abc", false},
+		{"f?o", "fao", true},
+		{"f?o", "f/o", false},
+		{"[a-c]x", "bx", true},
+		{"[!a-c]x", "dx", true},
+		{"[!a-c]x", "ax", false},
+		{`\!bang`, "!bang", true},
+		{`\#hash`, "#hash", true},
+		{"trail  ", "trail", true},
+	}
+	for _, c := range cases {
+		p, err := parseGitIgnorePattern(c.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.pattern.MatchString(c.path) != c.matched {
+			t.Errorf("Pattern %q matching %q should be %v.", c.pattern, c.path, c.matched)
+		}
+	}
+
+	for _, line := range []string{"", "# comment", "   "} {
+		if p, _ := parseGitIgnorePattern(line); p != nil {
+			t.Errorf("Line %q should be skipped.", line)
+		}
+	}
+}
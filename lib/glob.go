@@ -0,0 +1,259 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package lib
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// globToRegexp translates a slash separated glob to the body of a regexp.
+// `*` and `?` never match `/`, `[...]` is a character class (`[!...]` is
+// negated), and `**` as a whole path segment matches zero or more
+// directories.
+func globToRegexp(glob string) (string, error) {
+	var buf strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' &&
+				(i == 0 || glob[i-1] == '/') && (i+2 == len(glob) || glob[i+2] == '/') {
+				switch {
+				case i+2 == len(glob):
+					// trailing `**` matches everything
+					buf.WriteString(".*")
+				default:
+					// `**/` matches zero or more directories
+					buf.WriteString("(?:.*/)?")
+					i++
+				}
+				i++
+				continue
+			}
+			buf.WriteString("[^/]*")
+		case '?':
+			buf.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			// `]` right after `[` or `[!` is a member of class
+			if end == 0 || (end == 1 && glob[i+1] == '!') {
+				next := strings.IndexByte(glob[i+end+2:], ']')
+				if next < 0 {
+					end = -1
+				} else {
+					end += next + 1
+				}
+			}
+			if end < 0 {
+				return "", fmt.Errorf("glob %q: unclosed [", glob)
+			}
+			class := glob[i+1 : i+1+end]
+			buf.WriteString("[")
+			if strings.HasPrefix(class, "!") || strings.HasPrefix(class, "^") {
+				buf.WriteString("^/")
+				class = class[1:]
+			}
+			buf.WriteString(strings.Replace(strings.Replace(class, `\`, `\\`, -1), "[", `\[`, -1))
+			buf.WriteString("]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				buf.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+			}
+		default:
+			buf.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	return buf.String(), nil
+}
+
+// expandBraces expands `{a,b}` alternatives of glob, braces can be nested
+// and escaped by `\`. A brace without comma or closing brace is literal.
+func expandBraces(glob string) []string {
+	depth, start := 0, -1
+	commas := make([]int, 0)
+	for i := 0; i < len(glob); i++ {
+		switch glob[i] {
+		case '\\':
+			i++
+		case '{':
+			if depth == 0 {
+				start = i
+				commas = commas[:0]
+			}
+			depth++
+		case ',':
+			if depth == 1 {
+				commas = append(commas, i)
+			}
+		case '}':
+			if depth == 0 {
+				continue
+			}
+			depth--
+			if depth > 0 {
+				continue
+			}
+			if len(commas) == 0 {
+				// `{a}` is not an alternative, keep it and expand the rest
+				result := make([]string, 0)
+				for _, rest := range expandBraces(glob[i+1:]) {
+					result = append(result, glob[:i+1]+rest)
+				}
+				return result
+			}
+
+			alternatives := make([]string, 0, len(commas)+1)
+			prev := start
+			for _, comma := range append(commas, i) {
+				alternatives = append(alternatives, glob[prev+1:comma])
+				prev = comma
+			}
+
+			result := make([]string, 0)
+			for _, alternative := range alternatives {
+				result = append(result, expandBraces(glob[:start]+alternative+glob[i+1:])...)
+			}
+			return result
+		}
+	}
+	return []string{glob}
+}
+
+// CompileGlob compiles a glob to a regexp matching whole slash separated
+// paths. Besides `*`, `?`, `[...]` and `**`, `{a,b}` matches any of the
+// alternatives.
+func CompileGlob(glob string) (*regexp.Regexp, error) {
+	alternatives := expandBraces(glob)
+	bodies := make([]string, 0, len(alternatives))
+	for _, alternative := range alternatives {
+		body, err := globToRegexp(alternative)
+		if err != nil {
+			return nil, err
+		}
+		bodies = append(bodies, body)
+	}
+	return regexp.Compile("^(?:" + strings.Join(bodies, "|") + ")$")
+}
+
+// compileGlobs compiles globs to a single regexp matching any of them.
+func compileGlobs(globs []string) (*regexp.Regexp, error) {
+	if len(globs) == 0 {
+		return nil, fmt.Errorf("no glob pattern")
+	}
+
+	bodies := make([]string, 0, len(globs))
+	for _, glob := range globs {
+		pattern, err := CompileGlob(glob)
+		if err != nil {
+			return nil, err
+		}
+		bodies = append(bodies, pattern.String())
+	}
+	return regexp.Compile(strings.Join(bodies, "|"))
+}
+
+// relativeSlashPath return path relative to root with slash separators,
+// path is returned as is if it is not under root.
+func relativeSlashPath(root, path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		if rel, err := filepath.Rel(root, abs); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return filepath.ToSlash(path)
+}
+
+// GlobMatchSupport keeps only paths matched by any of globs. Globs match
+// the whole path relative to root, so `*.org` only matches files directly
+// under root while `**/*.org` matches them at any depth.
+type GlobMatchSupport struct {
+	BaseSupport
+
+	root    string
+	globs   []string
+	pattern *regexp.Regexp
+}
+
+// NewFilterGlobMatchSupport create a GlobMatchSupport for paths under root.
+func NewFilterGlobMatchSupport(root string, globs ...string) (FilterSupport, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	pattern, err := compileGlobs(globs)
+	if err != nil {
+		return nil, err
+	}
+
+	is := &GlobMatchSupport{
+		root:    root,
+		globs:   globs,
+		pattern: pattern,
+	}
+	is.SetName("path(" + strings.Join(quoteFilterArgs(globs), ",") + ")")
+	return is, nil
+}
+
+// IsIgnore ...
+func (gms *GlobMatchSupport) IsIgnore(path string, info os.FileInfo) (bool, error) {
+	return !gms.pattern.MatchString(relativeSlashPath(gms.root, path)), nil
+}
+
+// IgnoreGlobMatchSupport ignores paths matched by any of globs, globs
+// match like GlobMatchSupport.
+type IgnoreGlobMatchSupport struct {
+	BaseSupport
+
+	root    string
+	globs   []string
+	pattern *regexp.Regexp
+}
+
+// NewFilterIgnoreGlobMatchSupport create a IgnoreGlobMatchSupport for paths
+// under root.
+func NewFilterIgnoreGlobMatchSupport(root string, globs ...string) (FilterSupport, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	pattern, err := compileGlobs(globs)
+	if err != nil {
+		return nil, err
+	}
+
+	is := &IgnoreGlobMatchSupport{
+		root:    root,
+		globs:   globs,
+		pattern: pattern,
+	}
+	is.SetName("not path(" + strings.Join(quoteFilterArgs(globs), ",") + ")")
+	return is, nil
+}
+
+// IsIgnore ...
+func (igms *IgnoreGlobMatchSupport) IsIgnore(path string, info os.FileInfo) (bool, error) {
+	return igms.pattern.MatchString(relativeSlashPath(igms.root, path)), nil
+}
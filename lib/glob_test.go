@@ -0,0 +1,109 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompileGlob(t *testing.T) {
+	cases := []struct {
+		glob    string
+		path    string
+		matched bool
+	}{
+		{"*.org", "a.org", true},
+		{"*.org", "notes/a.org", false},
+		{"**/*.org", "a.org", true},
+		{"**/*.org", "notes/deep/a.org", true},
+		{"notes/**", "notes/a/b", true},
+		{"img/*.{png,jpg}", "img/a.jpg", true},
+		{"img/*.{png,jpg}", "img/a.gif", false},
+		{"{a,b{c,d}}/x", "bd/x", true},
+		{"{a,b{c,d}}/x", "b/x", false},
+		{"{single}", "{single}", true},
+		{"{open", "{open", true},
+		{`\{a,b\}`, "{a,b}", true},
+		{"file[0-9].txt", "file7.txt", true},
+		{"file[!0-9].txt", "filex.txt", true},
+		{"file[!0-9].txt", "file7.txt", false},
+		{"a?c", "abc", true},
+		{"a?c", "a/c", false},
+	}
+	for _, c := range cases {
+		pattern, err := CompileGlob(c.glob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pattern.MatchString(c.path) != c.matched {
+			t.Errorf("Glob %q matching %q should be %v.", c.glob, c.path, c.matched)
+		}
+	}
+
+	if _, err := CompileGlob("a[b"); err == nil {
+		t.Error("Glob with unclosed [ should be invalid.")
+	}
+}
+
+func TestGlobMatchSupport(t *testing.T) {
+	root := makeTestTree(t, map[string]string{
+		"a.org":           "",
+		"b.md":            "",
+		"img/a.png":       "",
+		"img/b.jpg":       "",
+		"img/c.gif":       "",
+		"img/tmp/d.png":   "",
+		"archive/old.png": "",
+		"..notes/e.png":   "",
+	})
+	defer os.RemoveAll(root)
+
+	include, err := NewFilterGlobMatchSupport(root, "**/*.{png,jpg}", "img", "img/tmp", "archive", "..notes", "..notes/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exclude, err := NewFilterIgnoreGlobMatchSupport(root, "archive/**", "img/tmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	include.SetNext(exclude)
+
+	iterator, err := NewFileIterator(root, include)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"..notes/e.png", "img/a.png", "img/b.jpg"}
+	result := iterateTestTree(t, root, iterator)
+	if strings.Join(result, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Iterate result is error:\n%s", strings.Join(result, "\n"))
+	}
+
+	info, err := os.Lstat(filepath.Join(root, "b.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Filter(include, filepath.Join(root, "b.md"), info) {
+		t.Error("b.md should be ignored.")
+	}
+}
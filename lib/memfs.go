@@ -0,0 +1,562 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package lib
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	errNotDir = errors.New("not a directory")
+	errIsDir  = errors.New("is a directory")
+	errClosed = errors.New("file already closed")
+)
+
+// memNode is a file, directory or symlink of MemFileSystem.
+// Nodes have no names, a node linked into several directories is named by
+// the path it is reached with.
+type memNode struct {
+	mode     os.FileMode
+	modTime  time.Time
+	data     []byte
+	target   string
+	children map[string]*memNode
+
+	// open reads the content of a read only file lazily instead of data,
+	// size is the length of content. Archive indexes use it.
+	open func() (io.ReadCloser, error)
+	size int64
+}
+
+// info return the FileInfo of node named name, it should be called with
+// lock held.
+func (n *memNode) info(name string) os.FileInfo {
+	size := int64(len(n.data))
+	if n.mode&os.ModeSymlink != 0 {
+		size = int64(len(n.target))
+	} else if n.open != nil {
+		size = n.size
+	}
+	return &memFileInfo{name: name, size: size, mode: n.mode, modTime: n.modTime}
+}
+
+// memFileInfo is the FileInfo of memNode.
+type memFileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (fi *memFileInfo) Name() string       { return fi.name }
+func (fi *memFileInfo) Size() int64        { return fi.size }
+func (fi *memFileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi *memFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *memFileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi *memFileInfo) Sys() interface{}   { return nil }
+
+// MemFileSystem is a FileSystem kept in memory, it is safe for concurrent
+// use. Relative paths are relative to the root directory.
+type MemFileSystem struct {
+	mu   sync.RWMutex
+	root *memNode
+}
+
+// NewMemFileSystem create a MemFileSystem with an empty root directory.
+func NewMemFileSystem() *MemFileSystem {
+	return &MemFileSystem{
+		root: &memNode{
+			mode:     os.ModeDir | 0755,
+			modTime:  time.Now(),
+			children: make(map[string]*memNode),
+		},
+	}
+}
+
+// NewMemFileSystemWithFiles create a MemFileSystem with files, keys of
+// files are slash separated paths, and directories are created if
+// necessary.
+func NewMemFileSystemWithFiles(files map[string]string) (*MemFileSystem, error) {
+	mfs := NewMemFileSystem()
+	for name, content := range files {
+		if err := WriteFileFS(mfs, filepath.FromSlash(name), []byte(content)); err != nil {
+			return nil, err
+		}
+	}
+	return mfs, nil
+}
+
+// split return the cleaned elements of name.
+func (mfs *MemFileSystem) split(name string) []string {
+	name = filepath.Clean(string(filepath.Separator) + name)
+	name = strings.Trim(name, string(filepath.Separator))
+	if name == "" {
+		return nil
+	}
+	return strings.Split(name, string(filepath.Separator))
+}
+
+// base return the last element of name, or the separator for the root.
+func (mfs *MemFileSystem) base(name string) string {
+	elems := mfs.split(name)
+	if len(elems) == 0 {
+		return string(filepath.Separator)
+	}
+	return elems[len(elems)-1]
+}
+
+// lookup return the node of name and its parent, following symlinks except
+// the last element if follow is false. node is nil if the last element
+// does not exist. It should be called with lock held.
+func (mfs *MemFileSystem) lookup(op, name string, follow bool) (parent, node *memNode, err error) {
+	return mfs.lookupElems(op, name, mfs.split(name), follow, 0)
+}
+
+func (mfs *MemFileSystem) lookupElems(op, name string, elems []string, follow bool, links int) (parent, node *memNode, err error) {
+	node = mfs.root
+	for i, elem := range elems {
+		if !node.mode.IsDir() {
+			return nil, nil, &os.PathError{Op: op, Path: name, Err: errNotDir}
+		}
+		parent, node = node, node.children[elem]
+		if node == nil {
+			if i < len(elems)-1 {
+				return nil, nil, &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+			}
+			return parent, nil, nil
+		}
+		if node.mode&os.ModeSymlink == 0 || (!follow && i == len(elems)-1) {
+			continue
+		}
+
+		if links++; links > maxSymlinks {
+			return nil, nil, &os.PathError{Op: op, Path: name, Err: errTooManySymlinks}
+		}
+		target := node.target
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(append([]string{string(filepath.Separator)}, elems[:i]...)...) +
+				string(filepath.Separator) + target
+		}
+		rest := append(mfs.split(target), elems[i+1:]...)
+		return mfs.lookupElems(op, name, rest, follow, links)
+	}
+	return parent, node, nil
+}
+
+// get return the existing node of name.
+func (mfs *MemFileSystem) get(op, name string, follow bool) (*memNode, error) {
+	_, node, err := mfs.lookup(op, name, follow)
+	if err == nil && node == nil {
+		err = &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	}
+	return node, err
+}
+
+// Open ...
+func (mfs *MemFileSystem) Open(name string) (File, error) {
+	return mfs.OpenFile(name, os.O_RDONLY, 0)
+}
+
+// OpenFile ...
+func (mfs *MemFileSystem) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	parent, node, err := mfs.lookup("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	writable := flag&(os.O_WRONLY|os.O_RDWR) != 0
+	switch {
+	case node == nil && flag&os.O_CREATE == 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	case node == nil:
+		node = &memNode{
+			mode:    perm & os.ModePerm,
+			modTime: time.Now(),
+		}
+		parent.children[mfs.base(name)] = node
+	case flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+	case node.mode.IsDir() && writable:
+		return nil, &os.PathError{Op: "open", Path: name, Err: errIsDir}
+	case node.open != nil && (writable || flag&os.O_TRUNC != 0):
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrPermission}
+	}
+
+	if flag&os.O_TRUNC != 0 && writable {
+		node.data = nil
+		node.modTime = time.Now()
+	}
+	file := &memFile{fs: mfs, node: node, name: name, writable: writable, readable: flag&os.O_WRONLY == 0}
+	if node.open != nil {
+		if file.rc, err = node.open(); err != nil {
+			return nil, &os.PathError{Op: "open", Path: name, Err: err}
+		}
+	}
+	if flag&os.O_APPEND != 0 {
+		file.offset = int64(len(node.data))
+	}
+	return file, nil
+}
+
+// Stat ...
+func (mfs *MemFileSystem) Stat(name string) (os.FileInfo, error) {
+	mfs.mu.RLock()
+	defer mfs.mu.RUnlock()
+
+	node, err := mfs.get("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return node.info(mfs.base(name)), nil
+}
+
+// Lstat ...
+func (mfs *MemFileSystem) Lstat(name string) (os.FileInfo, error) {
+	mfs.mu.RLock()
+	defer mfs.mu.RUnlock()
+
+	node, err := mfs.get("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return node.info(mfs.base(name)), nil
+}
+
+// MkdirAll ...
+func (mfs *MemFileSystem) MkdirAll(path string, perm os.FileMode) error {
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	elems := mfs.split(path)
+	for i := range elems {
+		sub := filepath.Join(append([]string{string(filepath.Separator)}, elems[:i+1]...)...)
+		parent, node, err := mfs.lookup("mkdir", sub, true)
+		if err != nil {
+			return err
+		}
+		if node == nil {
+			parent.children[elems[i]] = &memNode{
+				mode:     os.ModeDir | perm&os.ModePerm,
+				modTime:  time.Now(),
+				children: make(map[string]*memNode),
+			}
+		} else if !node.mode.IsDir() {
+			return &os.PathError{Op: "mkdir", Path: sub, Err: errNotDir}
+		}
+	}
+	return nil
+}
+
+// addLazyFile adds a read only file named name, whose content of size
+// bytes is read by open. Parent directories are created if necessary.
+func (mfs *MemFileSystem) addLazyFile(name string, perm os.FileMode, modTime time.Time,
+	size int64, open func() (io.ReadCloser, error)) error {
+	if err := mfs.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	parent, _, err := mfs.lookup("open", name, true)
+	if err == nil && parent == nil {
+		err = &os.PathError{Op: "open", Path: name, Err: errIsDir}
+	}
+	if err != nil {
+		return err
+	}
+	parent.children[mfs.base(name)] = &memNode{
+		mode:    perm & os.ModePerm,
+		modTime: modTime,
+		open:    open,
+		size:    size,
+	}
+	return nil
+}
+
+// Remove ...
+func (mfs *MemFileSystem) Remove(name string) error {
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	parent, node, err := mfs.lookup("remove", name, false)
+	if err == nil && (node == nil || parent == nil) {
+		err = &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+	if err != nil {
+		return err
+	}
+	if len(node.children) > 0 {
+		return &os.PathError{Op: "remove", Path: name, Err: errors.New("directory not empty")}
+	}
+	delete(parent.children, mfs.base(name))
+	return nil
+}
+
+// Rename ...
+func (mfs *MemFileSystem) Rename(oldpath, newpath string) error {
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	oldParent, node, err := mfs.lookup("rename", oldpath, false)
+	if err == nil && (node == nil || oldParent == nil) {
+		err = os.ErrNotExist
+	}
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
+	}
+	newParent, existing, err := mfs.lookup("rename", newpath, false)
+	if err == nil && newParent == nil {
+		err = os.ErrExist
+	}
+	if err == nil && existing != nil && existing.mode.IsDir() {
+		err = errIsDir
+	}
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
+	}
+
+	delete(oldParent.children, mfs.base(oldpath))
+	newParent.children[mfs.base(newpath)] = node
+	return nil
+}
+
+// Symlink ...
+func (mfs *MemFileSystem) Symlink(oldname, newname string) error {
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	parent, node, err := mfs.lookup("symlink", newname, false)
+	if err == nil && (node != nil || parent == nil) {
+		err = os.ErrExist
+	}
+	if err != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err}
+	}
+	parent.children[mfs.base(newname)] = &memNode{
+		mode:    os.ModeSymlink | 0777,
+		modTime: time.Now(),
+		target:  oldname,
+	}
+	return nil
+}
+
+// Link ...
+func (mfs *MemFileSystem) Link(oldname, newname string) error {
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	node, err := mfs.get("link", oldname, false)
+	if err == nil && node.mode.IsDir() {
+		err = errIsDir
+	}
+	if err != nil {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: err}
+	}
+	parent, existing, err := mfs.lookup("link", newname, false)
+	if err == nil && (existing != nil || parent == nil) {
+		err = os.ErrExist
+	}
+	if err != nil {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: err}
+	}
+	parent.children[mfs.base(newname)] = node
+	return nil
+}
+
+// Readlink ...
+func (mfs *MemFileSystem) Readlink(name string) (string, error) {
+	mfs.mu.RLock()
+	defer mfs.mu.RUnlock()
+
+	node, err := mfs.get("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	if node.mode&os.ModeSymlink == 0 {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: os.ErrInvalid}
+	}
+	return node.target, nil
+}
+
+// Chmod ...
+func (mfs *MemFileSystem) Chmod(name string, mode os.FileMode) error {
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	node, err := mfs.get("chmod", name, true)
+	if err != nil {
+		return err
+	}
+	node.mode = node.mode&os.ModeType | mode&os.ModePerm
+	return nil
+}
+
+// Chtimes ...
+func (mfs *MemFileSystem) Chtimes(name string, atime, mtime time.Time) error {
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	node, err := mfs.get("chtimes", name, true)
+	if err != nil {
+		return err
+	}
+	node.modTime = mtime
+	return nil
+}
+
+// memFile is a File opened from MemFileSystem.
+type memFile struct {
+	fs   *MemFileSystem
+	node *memNode
+	name string
+
+	readable, writable bool
+	closed             bool
+	offset             int64
+	// dirOffset is the number of entries returned by Readdir.
+	dirOffset int
+	// rc reads the content of a lazy node.
+	rc io.ReadCloser
+}
+
+// Name ...
+func (f *memFile) Name() string {
+	return f.name
+}
+
+// Read ...
+func (f *memFile) Read(p []byte) (int, error) {
+	f.fs.mu.RLock()
+	defer f.fs.mu.RUnlock()
+
+	if f.closed {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: errClosed}
+	}
+	if f.node.mode.IsDir() {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: errIsDir}
+	}
+	if !f.readable {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: os.ErrPermission}
+	}
+	if f.rc != nil {
+		n, err := f.rc.Read(p)
+		f.offset += int64(n)
+		return n, err
+	}
+	if f.offset >= int64(len(f.node.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.node.data[f.offset:])
+	f.offset += int64(n)
+	return n, nil
+}
+
+// Write ...
+func (f *memFile) Write(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if f.closed {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: errClosed}
+	}
+	if !f.writable {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: os.ErrPermission}
+	}
+	end := f.offset + int64(len(p))
+	if end > int64(len(f.node.data)) {
+		data := make([]byte, end)
+		copy(data, f.node.data)
+		f.node.data = data
+	}
+	copy(f.node.data[f.offset:], p)
+	f.offset = end
+	f.node.modTime = time.Now()
+	return len(p), nil
+}
+
+// Close ...
+func (f *memFile) Close() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if f.closed {
+		return &os.PathError{Op: "close", Path: f.name, Err: errClosed}
+	}
+	f.closed = true
+	if f.rc != nil {
+		return f.rc.Close()
+	}
+	return nil
+}
+
+// Readdir ...
+func (f *memFile) Readdir(n int) ([]os.FileInfo, error) {
+	f.fs.mu.RLock()
+	defer f.fs.mu.RUnlock()
+
+	if !f.node.mode.IsDir() {
+		return nil, &os.PathError{Op: "readdirent", Path: f.name, Err: errNotDir}
+	}
+	names := make([]string, 0, len(f.node.children))
+	for name := range f.node.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if f.dirOffset > len(names) {
+		f.dirOffset = len(names)
+	}
+	names = names[f.dirOffset:]
+	if n > 0 && len(names) == 0 {
+		return nil, io.EOF
+	}
+	if n > 0 && n < len(names) {
+		names = names[:n]
+	}
+	f.dirOffset += len(names)
+
+	infos := make([]os.FileInfo, 0, len(names))
+	for _, name := range names {
+		infos = append(infos, f.node.children[name].info(name))
+	}
+	return infos, nil
+}
+
+// Stat ...
+func (f *memFile) Stat() (os.FileInfo, error) {
+	f.fs.mu.RLock()
+	defer f.fs.mu.RUnlock()
+	return f.node.info(f.fs.base(f.name)), nil
+}
+
+// Sync ...
+func (f *memFile) Sync() error {
+	return nil
+}
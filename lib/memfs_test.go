@@ -0,0 +1,160 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package lib
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemFileSystem(t *testing.T) {
+	mfs := NewMemFileSystem()
+	if err := mfs.MkdirAll("/a/b", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFileFS(mfs, "/a/b/c.txt", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := mfs.OpenFile("/a/b/c.txt", os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte(" world"))
+	f.Close()
+	if data, _ := ReadFileFS(mfs, "/a/b/c.txt"); string(data) != "hello world" {
+		t.Errorf("Content is error: %q", data)
+	}
+	if _, err := mfs.OpenFile("/a/b/c.txt", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644); !os.IsExist(err) {
+		t.Errorf("Exclusive creation should fail: %v", err)
+	}
+	if _, err := mfs.Open("/a/x/c.txt"); !os.IsNotExist(err) {
+		t.Errorf("Opening missing file should fail: %v", err)
+	}
+	if err := WriteFileFS(mfs, "/a/b/c.txt/d", nil); err == nil {
+		t.Error("Creating file under a file should fail.")
+	}
+
+	mtime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	mfs.Chtimes("/a/b/c.txt", mtime, mtime)
+	mfs.Chmod("/a/b/c.txt", 0600)
+	info, err := mfs.Stat("a/b/c.txt")
+	if err != nil || info.Size() != 11 || info.Mode() != 0600 || !info.ModTime().Equal(mtime) || info.Name() != "c.txt" {
+		t.Errorf("Stat is error: %v, %v", info, err)
+	}
+
+	if err := mfs.Rename("/a/b/c.txt", "/a/d.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mfs.Remove("/a"); err == nil {
+		t.Error("Removing non-empty directory should fail.")
+	}
+	if err := mfs.Remove("/a/b"); err != nil {
+		t.Error(err)
+	}
+
+	dir, _ := mfs.Open("/a")
+	infos, err := dir.Readdir(0)
+	if err != nil || len(infos) != 1 || infos[0].Name() != "d.txt" {
+		t.Errorf("Readdir is error: %v, %v", infos, err)
+	}
+}
+
+func TestMemFileSystemReaddir(t *testing.T) {
+	mfs, _ := NewMemFileSystemWithFiles(map[string]string{
+		"/d/c": "", "/d/a": "", "/d/b": "",
+	})
+	dir, err := mfs.Open("/d")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := make([]string, 0)
+	for {
+		infos, err := dir.Readdir(2)
+		for _, info := range infos {
+			names = append(names, info.Name())
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if strings.Join(names, " ") != "a b c" {
+		t.Errorf("Readdir in batches is error: %v", names)
+	}
+}
+
+func TestMemFileSystemSymlink(t *testing.T) {
+	mfs, _ := NewMemFileSystemWithFiles(map[string]string{
+		"/real/f.txt": "f",
+	})
+	mfs.Symlink("real", "/link")
+	mfs.Symlink("/link/f.txt", "/abs.txt")
+	mfs.Symlink("loop", "/loop")
+
+	if data, err := ReadFileFS(mfs, "/abs.txt"); err != nil || string(data) != "f" {
+		t.Errorf("Reading through symlinks is error: %q, %v", data, err)
+	}
+	if info, _ := mfs.Lstat("/link"); info.Mode()&os.ModeSymlink == 0 {
+		t.Error("Lstat should not follow symlink.")
+	}
+	if info, _ := mfs.Stat("/link"); !info.IsDir() {
+		t.Error("Stat should follow symlink.")
+	}
+	if target, _ := mfs.Readlink("/link"); target != "real" {
+		t.Errorf("Readlink is error: %s", target)
+	}
+	if real, err := EvalSymlinks(mfs, "/abs.txt"); err != nil || real != "/real/f.txt" {
+		t.Errorf("EvalSymlinks is error: %s, %v", real, err)
+	}
+	if _, err := mfs.Stat("/loop"); err == nil {
+		t.Error("Stat of symlink loop should fail.")
+	}
+}
+
+func TestMemFileSystemLink(t *testing.T) {
+	mfs, _ := NewMemFileSystemWithFiles(map[string]string{
+		"/a.txt": "a",
+	})
+	if err := mfs.Link("/a.txt", "/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mfs.Link("/a.txt", "/b.txt"); !os.IsExist(err) {
+		t.Errorf("Linking to existing file should fail: %v", err)
+	}
+	WriteFileFS(mfs, "/b.txt", []byte("b"))
+	if data, _ := ReadFileFS(mfs, "/a.txt"); string(data) != "b" {
+		t.Errorf("Hardlinks should share content: %q", data)
+	}
+	if info, _ := mfs.Stat("/b.txt"); info.Name() != "b.txt" {
+		t.Errorf("Hardlink should be named by its path: %s", info.Name())
+	}
+
+	mfs.Remove("/a.txt")
+	if data, err := ReadFileFS(mfs, "/b.txt"); err != nil || string(data) != "b" {
+		t.Errorf("Removing a hardlink should keep the others: %q, %v", data, err)
+	}
+}
@@ -0,0 +1,556 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// compareOps are operators of comparison predicates, longer ones first.
+var compareOps = []string{"<=", ">=", "!=", "<", ">", "="}
+
+// compareResult checks result of comparison satisfies op.
+func compareResult(result int, op string) bool {
+	switch op {
+	case "=":
+		return result == 0
+	case "!=":
+		return result != 0
+	case "<":
+		return result < 0
+	case "<=":
+		return result <= 0
+	case ">":
+		return result > 0
+	case ">=":
+		return result >= 0
+	}
+	return false
+}
+
+// compareInt64 compares a with b, it returns -1, 0 or 1.
+func compareInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func checkCompareOp(op string) error {
+	for _, candidate := range compareOps {
+		if op == candidate {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown operator: %s", op)
+}
+
+// sizeUnits are units of sizes, largest first.
+var sizeUnits = []struct {
+	name string
+	size int64
+}{
+	{"TB", 1 << 40},
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+// ParseSize parses sizes like `512`, `4KB`, `10M` or `1GiB`, units are
+// 1024 based and case insensitive.
+func ParseSize(s string) (int64, error) {
+	upper := strings.ToUpper(strings.TrimSpace(s))
+	if strings.HasSuffix(upper, "IB") {
+		upper = strings.TrimSuffix(upper, "IB")
+	} else {
+		upper = strings.TrimSuffix(upper, "B")
+	}
+	unit := int64(1)
+	for _, u := range sizeUnits[:len(sizeUnits)-1] {
+		if strings.HasSuffix(upper, u.name[:1]) {
+			unit = u.size
+			upper = strings.TrimSuffix(upper, u.name[:1])
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(upper, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size: %s", s)
+	}
+	return n * unit, nil
+}
+
+// FormatSize formats size with the largest unit dividing it exactly.
+func FormatSize(size int64) string {
+	for _, u := range sizeUnits {
+		if size != 0 && size%u.size == 0 {
+			return strconv.FormatInt(size/u.size, 10) + u.name
+		}
+	}
+	return "0B"
+}
+
+// SizeSupport keeps only paths whose size satisfies the comparison.
+type SizeSupport struct {
+	BaseSupport
+
+	op   string
+	size int64
+}
+
+// NewFilterSizeSupport create a SizeSupport comparing size of path with
+// size by op, op is one of `<`, `<=`, `>`, `>=`, `=` and `!=`.
+func NewFilterSizeSupport(op string, size int64) (FilterSupport, error) {
+	if err := checkCompareOp(op); err != nil {
+		return nil, err
+	}
+
+	is := &SizeSupport{
+		op:   op,
+		size: size,
+	}
+	is.SetName("size" + op + FormatSize(size))
+	return is, nil
+}
+
+// IsIgnore ...
+func (ss *SizeSupport) IsIgnore(path string, info os.FileInfo) (bool, error) {
+	return !compareResult(compareInt64(info.Size(), ss.size), ss.op), nil
+}
+
+// ageUnits are units of ages, largest first.
+var ageUnits = []struct {
+	name     string
+	duration time.Duration
+}{
+	{"w", 7 * 24 * time.Hour},
+	{"d", 24 * time.Hour},
+	{"h", time.Hour},
+	{"m", time.Minute},
+	{"s", time.Second},
+}
+
+// ParseAge parses ages like `30d`, `2w` or `12h`, units are w, d, h, m and
+// s.
+func ParseAge(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	for _, u := range ageUnits {
+		if strings.HasSuffix(s, u.name) {
+			n, err := strconv.ParseInt(strings.TrimSuffix(s, u.name), 10, 64)
+			if err != nil || n < 0 {
+				break
+			}
+			return time.Duration(n) * u.duration, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid age: %s", s)
+}
+
+// FormatAge formats age with the largest unit dividing it exactly.
+func FormatAge(age time.Duration) string {
+	for _, u := range ageUnits {
+		if age%u.duration == 0 {
+			return strconv.FormatInt(int64(age/u.duration), 10) + u.name
+		}
+	}
+	return strconv.FormatInt(int64(age/time.Second), 10) + "s"
+}
+
+// ErrStatNotSupported is returned when status of file, like owner, atime
+// and ctime, is not available on the platform.
+var ErrStatNotSupported = errors.New("file status is not supported")
+
+// statInfo is the platform dependent status of a file.
+type statInfo struct {
+	uid   uint32
+	gid   uint32
+	atime time.Time
+	ctime time.Time
+	dev   uint64
+	ino   uint64
+}
+
+// unboundedArg is the argument of an open bound of ranges.
+const unboundedArg = "*"
+
+// SizeRangeSupport keeps only paths whose size is in [min, max], a negative
+// bound is open.
+type SizeRangeSupport struct {
+	BaseSupport
+
+	min int64
+	max int64
+}
+
+// NewFilterSizeRangeSupport create a SizeRangeSupport.
+func NewFilterSizeRangeSupport(min, max int64) (FilterSupport, error) {
+	if min >= 0 && max >= 0 && min > max {
+		return nil, fmt.Errorf("invalid size range: %d > %d", min, max)
+	}
+
+	is := &SizeRangeSupport{
+		min: min,
+		max: max,
+	}
+	bounds := []string{unboundedArg, unboundedArg}
+	if min >= 0 {
+		bounds[0] = FormatSize(min)
+	}
+	if max >= 0 {
+		bounds[1] = FormatSize(max)
+	}
+	is.SetName("size(" + strings.Join(bounds, ",") + ")")
+	return is, nil
+}
+
+// IsIgnore ...
+func (srs *SizeRangeSupport) IsIgnore(path string, info os.FileInfo) (bool, error) {
+	size := info.Size()
+	return (srs.min >= 0 && size < srs.min) || (srs.max >= 0 && size > srs.max), nil
+}
+
+// timeLayouts are layouts of absolute times in local time zone.
+var timeLayouts = []string{"2006-01-02", "2006-01-02T15:04:05"}
+
+// timeFields are timestamps of file could be compared.
+var timeFields = map[string]bool{"mtime": true, "atime": true, "ctime": true}
+
+// timeBound is either an age relative to now or an absolute time.
+type timeBound struct {
+	age    time.Duration
+	at     time.Time
+	useAge bool
+}
+
+// parseTimeBound parses an age like `30d` or an absolute time like
+// `2020-01-01`.
+func parseTimeBound(value string) (timeBound, error) {
+	if age, err := ParseAge(value); err == nil {
+		return timeBound{age: age, useAge: true}, nil
+	}
+
+	for _, layout := range timeLayouts {
+		if at, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return timeBound{at: at}, nil
+		}
+	}
+	return timeBound{}, fmt.Errorf("invalid age or time: %s", value)
+}
+
+// resolve return the absolute time of bound.
+func (tb timeBound) resolve(now time.Time) time.Time {
+	if tb.useAge {
+		return now.Add(-tb.age)
+	}
+	return tb.at
+}
+
+func (tb timeBound) String() string {
+	if tb.useAge {
+		return FormatAge(tb.age)
+	}
+	return formatTime(tb.at)
+}
+
+// formatTime formats t with the shortest layout keeping it.
+func formatTime(t time.Time) string {
+	if t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 {
+		return t.Format(timeLayouts[0])
+	}
+	return t.Format(timeLayouts[1])
+}
+
+// fileTime return the timestamp named field of info.
+func fileTime(field string, info os.FileInfo) (time.Time, error) {
+	if field == "mtime" {
+		return info.ModTime(), nil
+	}
+
+	st, err := statOf(info)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if field == "atime" {
+		return st.atime, nil
+	}
+	return st.ctime, nil
+}
+
+// TimeSupport keeps only paths whose timestamp satisfies the comparison.
+// It compares either the age of timestamp, which is how long ago it is,
+// or the timestamp itself with an absolute time. So `mtime<30d` keeps
+// files modified in the last 30 days, and `mtime<2020-01-01` keeps files
+// modified before 2020.
+type TimeSupport struct {
+	BaseSupport
+
+	field string
+	op    string
+	bound timeBound
+}
+
+// NewFilterTimeSupport create a TimeSupport comparing field of path by op,
+// field is one of mtime, atime and ctime, value is an age like `30d` or an
+// absolute time like `2020-01-01`.
+func NewFilterTimeSupport(field, op, value string) (FilterSupport, error) {
+	if !timeFields[field] {
+		return nil, fmt.Errorf("unknown time field: %s", field)
+	}
+	if err := checkCompareOp(op); err != nil {
+		return nil, err
+	}
+	bound, err := parseTimeBound(value)
+	if err != nil {
+		return nil, err
+	}
+
+	is := &TimeSupport{
+		field: field,
+		op:    op,
+		bound: bound,
+	}
+	is.SetName(field + op + bound.String())
+	return is, nil
+}
+
+// IsIgnore ...
+func (ts *TimeSupport) IsIgnore(path string, info os.FileInfo) (bool, error) {
+	t, err := fileTime(ts.field, info)
+	if err != nil {
+		return false, err
+	}
+
+	if ts.bound.useAge {
+		age := time.Since(t)
+		return !compareResult(compareInt64(int64(age), int64(ts.bound.age)), ts.op), nil
+	}
+	return !compareResult(compareInt64(t.UnixNano(), ts.bound.at.UnixNano()), ts.op), nil
+}
+
+// TimeWindowSupport keeps only paths whose timestamp is between two bounds,
+// each bound is an age, an absolute time or open. So `mtime(30d,7d)` keeps
+// files modified between 30 and 7 days ago.
+type TimeWindowSupport struct {
+	BaseSupport
+
+	field  string
+	bounds []*timeBound
+}
+
+// NewFilterTimeWindowSupport create a TimeWindowSupport for field of path,
+// from and to are ages, absolute times or `*` for open bounds.
+func NewFilterTimeWindowSupport(field, from, to string) (FilterSupport, error) {
+	if !timeFields[field] {
+		return nil, fmt.Errorf("unknown time field: %s", field)
+	}
+
+	is := &TimeWindowSupport{
+		field:  field,
+		bounds: make([]*timeBound, 2),
+	}
+	names := []string{unboundedArg, unboundedArg}
+	for i, value := range []string{from, to} {
+		if value == unboundedArg {
+			continue
+		}
+		bound, err := parseTimeBound(value)
+		if err != nil {
+			return nil, err
+		}
+		is.bounds[i] = &bound
+		names[i] = bound.String()
+	}
+	is.SetName(field + "(" + strings.Join(names, ",") + ")")
+	return is, nil
+}
+
+// IsIgnore ...
+func (tws *TimeWindowSupport) IsIgnore(path string, info os.FileInfo) (bool, error) {
+	t, err := fileTime(tws.field, info)
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now()
+	from, to := tws.bounds[0], tws.bounds[1]
+	if from != nil && to != nil && from.resolve(now).After(to.resolve(now)) {
+		// bounds like (7d,30d) are in reverse order
+		from, to = to, from
+	}
+	if from != nil && t.Before(from.resolve(now)) {
+		return true, nil
+	}
+	if to != nil && t.After(to.resolve(now)) {
+		return true, nil
+	}
+	return false, nil
+}
+
+// OwnerSupport keeps only paths whose owner uid or gid satisfies the
+// comparison.
+type OwnerSupport struct {
+	BaseSupport
+
+	field string
+	op    string
+	id    uint32
+}
+
+// NewFilterOwnerSupport create a OwnerSupport comparing field of path by op,
+// field is uid or gid, and value is an id or name of user or group.
+func NewFilterOwnerSupport(field, op, value string) (FilterSupport, error) {
+	if field != "uid" && field != "gid" {
+		return nil, fmt.Errorf("unknown owner field: %s", field)
+	}
+	if err := checkCompareOp(op); err != nil {
+		return nil, err
+	}
+
+	id, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		switch field {
+		case "uid":
+			u, lookupErr := user.Lookup(value)
+			if lookupErr != nil {
+				return nil, lookupErr
+			}
+			value = u.Uid
+		case "gid":
+			g, lookupErr := user.LookupGroup(value)
+			if lookupErr != nil {
+				return nil, lookupErr
+			}
+			value = g.Gid
+		}
+		if id, err = strconv.ParseUint(value, 10, 32); err != nil {
+			return nil, fmt.Errorf("invalid %s: %s", field, value)
+		}
+	}
+
+	is := &OwnerSupport{
+		field: field,
+		op:    op,
+		id:    uint32(id),
+	}
+	is.SetName(field + op + strconv.FormatUint(id, 10))
+	return is, nil
+}
+
+// IsIgnore ...
+func (ows *OwnerSupport) IsIgnore(path string, info os.FileInfo) (bool, error) {
+	st, err := statOf(info)
+	if err != nil {
+		return false, err
+	}
+
+	id := st.uid
+	if ows.field == "gid" {
+		id = st.gid
+	}
+	return !compareResult(compareInt64(int64(id), int64(ows.id)), ows.op), nil
+}
+
+// PermMatch is how PermSupport matches permission bits.
+type PermMatch string
+
+const (
+	// PermAll matches if all bits of mask are set.
+	PermAll PermMatch = "all"
+	// PermAny matches if any bit of mask is set.
+	PermAny PermMatch = "any"
+	// PermExact matches if permission bits equal to mask.
+	PermExact PermMatch = "exact"
+)
+
+// unixPermBits maps special bits of unix permission to bits of os.FileMode,
+// the lower 9 bits are the same.
+var unixPermBits = []struct {
+	unix uint32
+	mode os.FileMode
+}{
+	{04000, os.ModeSetuid},
+	{02000, os.ModeSetgid},
+	{01000, os.ModeSticky},
+}
+
+// fileModeToUnixPerm converts permission bits of os.FileMode to unix
+// permission.
+func fileModeToUnixPerm(mode os.FileMode) uint32 {
+	perm := uint32(mode.Perm())
+	for _, bit := range unixPermBits {
+		if mode&bit.mode != 0 {
+			perm |= bit.unix
+		}
+	}
+	return perm
+}
+
+// PermSupport keeps only paths whose permission bits match mask.
+type PermSupport struct {
+	BaseSupport
+
+	match PermMatch
+	mask  uint32
+}
+
+// NewFilterPermSupport create a PermSupport, mask is unix permission like
+// 0002 or 04000.
+func NewFilterPermSupport(match PermMatch, mask uint32) (FilterSupport, error) {
+	if mask&^07777 != 0 {
+		return nil, fmt.Errorf("invalid permission: %o", mask)
+	}
+
+	is := &PermSupport{
+		match: match,
+		mask:  mask,
+	}
+	switch match {
+	case PermAll:
+		is.SetName(fmt.Sprintf("perm(%04o)", mask))
+	case PermAny, PermExact:
+		is.SetName(fmt.Sprintf("perm(%s,%04o)", match, mask))
+	default:
+		return nil, fmt.Errorf("unknown permission match: %s", match)
+	}
+	return is, nil
+}
+
+// IsIgnore ...
+func (ps *PermSupport) IsIgnore(path string, info os.FileInfo) (bool, error) {
+	perm := fileModeToUnixPerm(info.Mode())
+	switch ps.match {
+	case PermAny:
+		return perm&ps.mask == 0, nil
+	case PermExact:
+		return perm != ps.mask, nil
+	}
+	return perm&ps.mask != ps.mask, nil
+}
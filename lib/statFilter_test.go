@@ -0,0 +1,146 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// filterTestTree iterates root with the filter compiled from expr.
+func filterTestTree(t *testing.T, root, expr string) []string {
+	filter, err := CompileFilterExpression(expr, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iterator, err := NewFileIteratorWithPrune(root, nil, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return iterateTestTree(t, root, iterator)
+}
+
+func TestSizeAndTimeSupports(t *testing.T) {
+	root := makeTestTree(t, map[string]string{
+		"empty":    "",
+		"small":    strings.Repeat("x", 100),
+		"medium":   strings.Repeat("x", 2048),
+		"large":    strings.Repeat("x", 8192),
+		"old":      "",
+		"very_old": "",
+	})
+	defer os.RemoveAll(root)
+
+	now := time.Now()
+	for name, age := range map[string]time.Duration{"old": 10 * 24 * time.Hour, "very_old": 200 * 24 * time.Hour} {
+		at := now.Add(-age)
+		if err := os.Chtimes(filepath.Join(root, name), at, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		expr     string
+		expected []string
+	}{
+		{`size(1KB,4KB)`, []string{"medium"}},
+		{`size(2KB,*)`, []string{"large", "medium"}},
+		{`size(*,100B)`, []string{"empty", "old", "small", "very_old"}},
+		{`mtime>180d`, []string{"very_old"}},
+		{`mtime(30d,7d)`, []string{"old"}},
+		{`mtime(7d,30d)`, []string{"old"}},
+		{`mtime(*,30d)`, []string{"very_old"}},
+		{`mtime<` + now.AddDate(0, 0, -100).Format("2006-01-02"), []string{"very_old"}},
+		{`atime(1d,*) and not size(*,0B)`, []string{"large", "medium", "small"}},
+	}
+	if runtime.GOOS != "linux" {
+		cases = cases[:len(cases)-1]
+	}
+	for _, c := range cases {
+		result := filterTestTree(t, root, c.expr)
+		if strings.Join(result, "\n") != strings.Join(c.expected, "\n") {
+			t.Errorf("Filter %s result is error:\n%s", c.expr, strings.Join(result, "\n"))
+		}
+	}
+
+	if _, err := NewFilterSizeRangeSupport(10, 1); err == nil {
+		t.Error("Size range with min > max should be invalid.")
+	}
+}
+
+func TestOwnerAndPermSupports(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("owner is only supported on linux")
+	}
+
+	root := makeTestTree(t, map[string]string{
+		"private":  "",
+		"public":   "",
+		"script":   "",
+		"writable": "",
+	})
+	defer os.RemoveAll(root)
+
+	modes := map[string]os.FileMode{
+		"private":  0600,
+		"public":   0644,
+		"script":   0755 | os.ModeSetuid,
+		"writable": 0666,
+	}
+	for name, mode := range modes {
+		if err := os.Chmod(filepath.Join(root, name), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	uid := strconv.Itoa(os.Getuid())
+	cases := []struct {
+		expr     string
+		expected []string
+	}{
+		{`perm(0002)`, []string{"writable"}},
+		{`perm(0044)`, []string{"public", "script", "writable"}},
+		{`perm(any,0111)`, []string{"script"}},
+		{`perm(exact,0600)`, []string{"private"}},
+		{`perm(04000)`, []string{"script"}},
+		{`uid=` + uid + ` and not perm(any,0044)`, []string{"private"}},
+		{`uid!=` + uid, []string{}},
+		{`gid=` + strconv.Itoa(os.Getgid()) + ` and perm(0020)`, []string{"writable"}},
+	}
+	for _, c := range cases {
+		result := filterTestTree(t, root, c.expr)
+		if strings.Join(result, "\n") != strings.Join(c.expected, "\n") {
+			t.Errorf("Filter %s result is error:\n%s", c.expr, strings.Join(result, "\n"))
+		}
+	}
+
+	filter, err := CompileFilterExpression(`perm(any,111) and perm(2) and uid>=0 and ctime(2020-01-01,*)`, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filter.String() != `perm(any,0111) and perm(0002) and uid>=0 and ctime(2020-01-01,*)` {
+		t.Errorf("String of filter is error: %s", filter.String())
+	}
+}
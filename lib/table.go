@@ -0,0 +1,318 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package lib
+
+import (
+	"regexp"
+	"strings"
+
+	runewidth "github.com/mattn/go-runewidth"
+)
+
+// TableAlign is the alignment of a table column.
+type TableAlign uint8
+
+const (
+	AlignNone TableAlign = iota
+	AlignLeft
+	AlignRight
+	AlignCenter
+)
+
+var (
+	reTableSeparator = regexp.MustCompile(`^\s*:?-+:?\s*$`)
+	reTableCookie    = regexp.MustCompile(`^<([lrc])[0-9]*>$`)
+	reTableNumber    = regexp.MustCompile(`^[-+]?(?:[0-9]+(?:\.[0-9]*)?|\.[0-9]+)(?:[eE][-+]?[0-9]+)?%?$`)
+	reBlockBegin     = regexp.MustCompile(`^\s*#\+(?i:begin)_`)
+	reBlockEnd       = regexp.MustCompile(`^\s*#\+(?i:end)_`)
+)
+
+// TableRow is a row of table, Hline is true for horizontal lines which
+// have no cells.
+type TableRow struct {
+	Cells []string
+	Hline bool
+}
+
+// Table is a `|` delimited table of org or markdown.
+type Table struct {
+	Indent   string
+	Markdown bool
+	Rows     []TableRow
+	// Aligns is the alignment of columns declared by markdown separator
+	// lines.
+	Aligns []TableAlign
+}
+
+// IsTableLine checks if line is a row of table.
+func IsTableLine(line string) bool {
+	return strings.HasPrefix(strings.TrimLeft(line, " \t"), "|")
+}
+
+// ParseTable parses lines of a table.
+func ParseTable(lines []string, markdown bool) *Table {
+	table := &Table{
+		Markdown: markdown,
+		Rows:     make([]TableRow, 0, len(lines)),
+		Aligns:   make([]TableAlign, 0),
+	}
+	if len(lines) > 0 {
+		table.Indent = lines[0][:len(lines[0])-len(strings.TrimLeft(lines[0], " \t"))]
+	}
+
+	for _, line := range lines {
+		cells := splitTableCells(strings.TrimSpace(line), markdown)
+		if !markdown && strings.HasPrefix(strings.TrimSpace(line), "|-") {
+			table.Rows = append(table.Rows, TableRow{Hline: true})
+			continue
+		}
+
+		if markdown && isMarkdownSeparator(cells) {
+			table.Rows = append(table.Rows, TableRow{Hline: true})
+			table.Aligns = make([]TableAlign, len(cells))
+			for i, cell := range cells {
+				table.Aligns[i] = separatorAlign(cell)
+			}
+			continue
+		}
+
+		table.Rows = append(table.Rows, TableRow{Cells: cells})
+	}
+	return table
+}
+
+// splitTableCells split a row to cells, the leading `|` and the optional
+// trailing `|` are dropped. `\|` in markdown is not a delimiter.
+//
+// Cells are not found by FindAlignChar: it pairs the n-th `|` of every
+// line by byte offset, so it miscounts rows with fewer cells or escaped
+// `\|`, and byte offsets are not display columns of CJK text.
+func splitTableCells(line string, markdown bool) []string {
+	line = strings.TrimPrefix(line, "|")
+	cells := make([]string, 0)
+	var cell strings.Builder
+	for i := 0; i < len(line); i++ {
+		switch {
+		case markdown && line[i] == '\\' && i+1 < len(line) && line[i+1] == '|':
+			cell.WriteString(`\|`)
+			i++
+		case line[i] == '|':
+			cells = append(cells, strings.TrimSpace(cell.String()))
+			cell.Reset()
+		default:
+			cell.WriteByte(line[i])
+		}
+	}
+	if rest := strings.TrimSpace(cell.String()); rest != "" {
+		cells = append(cells, rest)
+	}
+	return cells
+}
+
+func isMarkdownSeparator(cells []string) bool {
+	if len(cells) == 0 {
+		return false
+	}
+	for _, cell := range cells {
+		if !reTableSeparator.MatchString(cell) {
+			return false
+		}
+	}
+	return true
+}
+
+func separatorAlign(cell string) TableAlign {
+	cell = strings.TrimSpace(cell)
+	left, right := strings.HasPrefix(cell, ":"), strings.HasSuffix(cell, ":")
+	switch {
+	case left && right:
+		return AlignCenter
+	case right:
+		return AlignRight
+	case left:
+		return AlignLeft
+	}
+	return AlignNone
+}
+
+// columns return the number of columns.
+func (t *Table) columns() int {
+	n := len(t.Aligns)
+	for _, row := range t.Rows {
+		if len(row.Cells) > n {
+			n = len(row.Cells)
+		}
+	}
+	return n
+}
+
+// columnAligns return the alignment of each column. Markdown tables use
+// the separator line, org tables use `<l>`, `<r>`, `<c>` cookies or right
+// align columns with mostly numbers like org-mode does.
+func (t *Table) columnAligns(n int) []TableAlign {
+	aligns := make([]TableAlign, n)
+	if t.Markdown {
+		copy(aligns, t.Aligns)
+		return aligns
+	}
+
+	for i := range aligns {
+		numbers, nonEmpty := 0, 0
+		for _, row := range t.Rows {
+			if i >= len(row.Cells) || row.Cells[i] == "" {
+				continue
+			}
+			if m := reTableCookie.FindStringSubmatch(row.Cells[i]); m != nil {
+				aligns[i] = map[string]TableAlign{"l": AlignLeft, "r": AlignRight, "c": AlignCenter}[m[1]]
+				break
+			}
+			nonEmpty++
+			if reTableNumber.MatchString(row.Cells[i]) {
+				numbers++
+			}
+		}
+		if aligns[i] == AlignNone && nonEmpty > 0 && numbers*2 > nonEmpty {
+			aligns[i] = AlignRight
+		}
+	}
+	return aligns
+}
+
+// Format realigns columns of table by display width of cells, and return
+// lines of table.
+func (t *Table) Format() []string {
+	n := t.columns()
+	widths := make([]int, n)
+	for i := range widths {
+		widths[i] = 1
+		if t.Markdown {
+			// separator needs at least 3 dashes
+			widths[i] = 3
+		}
+	}
+	for _, row := range t.Rows {
+		for i, cell := range row.Cells {
+			if w := runewidth.StringWidth(cell); w > widths[i] {
+				widths[i] = w
+			}
+		}
+	}
+	aligns := t.columnAligns(n)
+
+	lines := make([]string, 0, len(t.Rows))
+	for _, row := range t.Rows {
+		var line strings.Builder
+		line.WriteString(t.Indent)
+		if row.Hline {
+			t.formatHline(&line, widths, aligns)
+			lines = append(lines, line.String())
+			continue
+		}
+
+		line.WriteString("|")
+		for i, width := range widths {
+			cell := ""
+			if i < len(row.Cells) {
+				cell = row.Cells[i]
+			}
+			line.WriteString(" ")
+			line.WriteString(padCell(cell, width, aligns[i]))
+			line.WriteString(" |")
+		}
+		lines = append(lines, line.String())
+	}
+	return lines
+}
+
+func (t *Table) formatHline(line *strings.Builder, widths []int, aligns []TableAlign) {
+	line.WriteString("|")
+	for i, width := range widths {
+		dashes := strings.Repeat("-", width+2)
+		if t.Markdown {
+			switch aligns[i] {
+			case AlignLeft:
+				dashes = ":" + dashes[1:]
+			case AlignRight:
+				dashes = dashes[1:] + ":"
+			case AlignCenter:
+				dashes = ":" + dashes[2:] + ":"
+			}
+		}
+		line.WriteString(dashes)
+
+		if i == len(widths)-1 {
+			line.WriteString("|")
+		} else if t.Markdown {
+			line.WriteString("|")
+		} else {
+			line.WriteString("+")
+		}
+	}
+}
+
+func padCell(cell string, width int, align TableAlign) string {
+	padding := width - runewidth.StringWidth(cell)
+	switch align {
+	case AlignRight:
+		return strings.Repeat(" ", padding) + cell
+	case AlignCenter:
+		left := padding / 2
+		return strings.Repeat(" ", left) + cell + strings.Repeat(" ", padding-left)
+	}
+	return cell + strings.Repeat(" ", padding)
+}
+
+// FormatTables realigns all tables in lines of an org or markdown file,
+// tables inside blocks or code fences are kept. changed is true if any
+// line is modified.
+func FormatTables(lines []string, markdown bool) (result []string, changed bool) {
+	result = make([]string, 0, len(lines))
+	inBlock := false
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		switch {
+		case markdown && strings.HasPrefix(strings.TrimSpace(line), "```"):
+			inBlock = !inBlock
+		case !markdown && !inBlock && reBlockBegin.MatchString(line):
+			inBlock = true
+		case !markdown && inBlock && reBlockEnd.MatchString(line):
+			inBlock = false
+		}
+
+		if inBlock || !IsTableLine(line) {
+			result = append(result, line)
+			continue
+		}
+
+		end := i
+		for end < len(lines) && IsTableLine(lines[end]) {
+			end++
+		}
+		formatted := ParseTable(lines[i:end], markdown).Format()
+		for j, f := range formatted {
+			if f != lines[i+j] {
+				changed = true
+			}
+		}
+		result = append(result, formatted...)
+		i = end - 1
+	}
+	return result, changed
+}
@@ -0,0 +1,78 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package lib
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFormatOrgTables(t *testing.T) {
+	lines := []string{
+		"* Table",
+		"  |名字|count|note|",
+		"  |-",
+		"  | 苹果 | 12 |red|",
+		"  |banana|3",
+		"#+BEGIN_SRC text",
+		"|kept|as is|",
+		"#+END_SRC",
+	}
+	expected := []string{
+		"* Table",
+		"  | 名字   | count | note |",
+		"  |--------+-------+------|",
+		"  | 苹果   |    12 | red  |",
+		"  | banana |     3 |      |",
+		"#+BEGIN_SRC text",
+		"|kept|as is|",
+		"#+END_SRC",
+	}
+
+	result, changed := FormatTables(lines, false)
+	if !changed {
+		t.Error("Tables should be changed.")
+	}
+	if strings.Join(result, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Format result is error:\n%s", strings.Join(result, "\n"))
+	}
+
+	if _, changed := FormatTables(result, false); changed {
+		t.Error("Formatted tables should not be changed again.")
+	}
+}
+
+func TestFormatMarkdownTables(t *testing.T) {
+	lines := []string{
+		"|a|b \\| c|d|",
+		"|:-|-:|:-:|",
+		"|中文|1|x|",
+	}
+	expected := []string{
+		"| a    | b \\| c |  d  |",
+		"|:-----|-------:|:---:|",
+		"| 中文 |      1 |  x  |",
+	}
+
+	result, _ := FormatTables(lines, true)
+	if strings.Join(result, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Format result is error:\n%s", strings.Join(result, "\n"))
+	}
+}
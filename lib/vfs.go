@@ -0,0 +1,202 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package lib
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// File is a file opened from a FileSystem.
+type File interface {
+	io.Reader
+	io.Writer
+	io.Closer
+
+	Name() string
+	// Readdir reads entries of directory like os.File.Readdir.
+	Readdir(n int) ([]os.FileInfo, error)
+	Stat() (os.FileInfo, error)
+	Sync() error
+}
+
+// FileSystem is the filesystem used by iterators, filters and fs helpers,
+// its methods behave like the functions of os package with the same names.
+type FileSystem interface {
+	Open(name string) (File, error)
+	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+	Stat(name string) (os.FileInfo, error)
+	Lstat(name string) (os.FileInfo, error)
+	MkdirAll(path string, perm os.FileMode) error
+	Remove(name string) error
+	Rename(oldpath, newpath string) error
+	Symlink(oldname, newname string) error
+	Link(oldname, newname string) error
+	Readlink(name string) (string, error)
+	Chmod(name string, mode os.FileMode) error
+	Chtimes(name string, atime, mtime time.Time) error
+}
+
+// osFileSystem is the FileSystem of operating system.
+type osFileSystem struct{}
+
+// OSFS is the FileSystem of operating system, it is used if no FileSystem
+// is given.
+var OSFS FileSystem = osFileSystem{}
+
+// Open ...
+func (osFileSystem) Open(name string) (File, error) {
+	return openOSFile(os.Open(name))
+}
+
+// OpenFile ...
+func (osFileSystem) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	return openOSFile(os.OpenFile(name, flag, perm))
+}
+
+// openOSFile avoids returning a nil *os.File as a non-nil File.
+func openOSFile(f *os.File, err error) (File, error) {
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Stat ...
+func (osFileSystem) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+// Lstat ...
+func (osFileSystem) Lstat(name string) (os.FileInfo, error) {
+	return os.Lstat(name)
+}
+
+// MkdirAll ...
+func (osFileSystem) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+// Remove ...
+func (osFileSystem) Remove(name string) error {
+	return os.Remove(name)
+}
+
+// Rename ...
+func (osFileSystem) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+// Symlink ...
+func (osFileSystem) Symlink(oldname, newname string) error {
+	return os.Symlink(oldname, newname)
+}
+
+// Link ...
+func (osFileSystem) Link(oldname, newname string) error {
+	return os.Link(oldname, newname)
+}
+
+// Readlink ...
+func (osFileSystem) Readlink(name string) (string, error) {
+	return os.Readlink(name)
+}
+
+// Chmod ...
+func (osFileSystem) Chmod(name string, mode os.FileMode) error {
+	return os.Chmod(name, mode)
+}
+
+// Chtimes ...
+func (osFileSystem) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+
+// fileSystemOrOS return fsys, or OSFS if fsys is nil.
+func fileSystemOrOS(fsys FileSystem) FileSystem {
+	if fsys == nil {
+		return OSFS
+	}
+	return fsys
+}
+
+// maxSymlinks is the maximum number of symlinks followed to resolve a path.
+const maxSymlinks = 40
+
+var errTooManySymlinks = errors.New("too many levels of symbolic links")
+
+// EvalSymlinks return path after resolving symlinks in fsys, like
+// filepath.EvalSymlinks.
+func EvalSymlinks(fsys FileSystem, path string) (string, error) {
+	fsys = fileSystemOrOS(fsys)
+	if fsys == OSFS {
+		return filepath.EvalSymlinks(path)
+	}
+
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	resolved := filepath.VolumeName(path) + string(filepath.Separator)
+	rest := path[len(resolved):]
+	for links := 0; rest != ""; {
+		var name string
+		name, rest = splitFirst(rest)
+		next := filepath.Join(resolved, name)
+
+		info, err := fsys.Lstat(next)
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		if links++; links > maxSymlinks {
+			return "", &os.PathError{Op: "evalsymlinks", Path: path, Err: errTooManySymlinks}
+		}
+		target, err := fsys.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(resolved, target)
+		}
+		// resolve the target again from the root
+		rest = filepath.Join(target, rest)
+		resolved = filepath.VolumeName(rest) + string(filepath.Separator)
+		rest = rest[len(resolved):]
+	}
+	return resolved, nil
+}
+
+// splitFirst splits the first element from a relative path.
+func splitFirst(path string) (string, string) {
+	for i := 0; i < len(path); i++ {
+		if os.IsPathSeparator(path[i]) {
+			return path[:i], path[i+1:]
+		}
+	}
+	return path, ""
+}
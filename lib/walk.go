@@ -0,0 +1,186 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package lib
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sort"
+)
+
+// readDirBatch is the number of entries read from a directory between two
+// checks of context.
+const readDirBatch = 256
+
+var (
+	// SkipDir is returned by WalkFunc to skip the directory, or the rest of
+	// the directory when it is returned for a file.
+	SkipDir = errors.New("skip this directory")
+	// StopWalk is returned by WalkFunc to stop the walk without error.
+	StopWalk = errors.New("stop the walk")
+
+	errReportRequired = errors.New("error report is required to collect errors")
+)
+
+// WalkFunc is called by WalkFiles for each directory kept by the prune
+// chain and each file kept by the filter chain. Returning SkipDir or
+// StopWalk changes the walk, any other error stops the walk and is returned
+// by WalkFiles.
+type WalkFunc func(entry FileEntry) error
+
+// readDirContext reads dir in fsys like ioutil.ReadDir in batches, it
+// stops with the error of ctx once ctx is done.
+func readDirContext(ctx context.Context, fsys FileSystem, dir string) ([]os.FileInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f, err := fsys.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	infos := make([]os.FileInfo, 0)
+	for {
+		batch, err := f.Readdir(readDirBatch)
+		infos = append(infos, batch...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos, nil
+}
+
+// WalkFiles walks the tree under dir in the order of iterator, calling fn
+// for entries kept by opts. Directories are passed to fn before their
+// contents. The walk stops with the error of ctx once ctx is done, and an
+// error is returned if dir can not be read, whatever the policy is.
+func WalkFiles(ctx context.Context, dir string, opts IteratorOptions, fn WalkFunc) error {
+	w, err := newWalker(ctx, opts)
+	if err != nil {
+		return err
+	}
+	current, err := rootEntry(w.fs, dir)
+	if err != nil {
+		return err
+	}
+	w.descends(current)
+	infos, err := readDirContext(ctx, w.fs, dir)
+	if err != nil {
+		return err
+	}
+
+	for {
+		err = walkEntries(ctx, w, current, infos, fn)
+		if err == StopWalk {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// read the next directory, skip unreadable ones by policy
+		for {
+			if w.queue.Len() == 0 {
+				return nil
+			}
+			current, infos, err = w.queue.Pop()
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			if err == nil {
+				break
+			}
+			if err = w.handleError(&PathError{Op: "readdir", Path: current.Path, Err: err}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// walkEntries loads infos under dir and calls fn for returned ones,
+// directories not skipped by fn are pushed to queue.
+func walkEntries(ctx context.Context, w *walker, dir FileEntry, infos []os.FileInfo, fn WalkFunc) error {
+	entries, loadErr := w.load(dir, infos)
+
+	dirs := make([]FileEntry, 0)
+	defer func() { w.queue.Push(dirs...) }()
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		var err error
+		if w.returns(entry) {
+			err = fn(entry)
+		}
+		isDir := entry.Info.IsDir()
+		switch {
+		case err == SkipDir && isDir:
+			// the directory is not queued
+		case err == SkipDir:
+			return nil
+		case err != nil:
+			return err
+		case isDir && w.descends(entry):
+			dirs = append(dirs, entry)
+		}
+	}
+	return loadErr
+}
+
+// StreamFiles walks files under dir like WalkFiles in a new goroutine and
+// sends them over the returned entry channel, which is closed when the walk
+// is over. The result of walk is then sent over the error channel.
+//
+// The walk goroutine blocks until each entry is received, so callers
+// abandoning the stream before the entry channel is closed must cancel ctx,
+// or the goroutine and the directories it holds open are leaked.
+func StreamFiles(ctx context.Context, dir string, opts IteratorOptions) (<-chan FileEntry, <-chan error) {
+	entries := make(chan FileEntry)
+	errc := make(chan error, 1)
+	go func() {
+		err := WalkFiles(ctx, dir, opts, func(entry FileEntry) error {
+			if entry.Info.IsDir() {
+				return nil
+			}
+			select {
+			case entries <- entry:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		close(entries)
+		errc <- err
+		close(errc)
+	}()
+	return entries, errc
+}
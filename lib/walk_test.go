@@ -0,0 +1,205 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package lib
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// walkTestTree return slash separated paths relative to root passed to fn
+// in order.
+func walkTestTree(t *testing.T, root string, opts IteratorOptions, fn WalkFunc) ([]string, error) {
+	result := make([]string, 0)
+	err := WalkFiles(context.Background(), root, opts, func(entry FileEntry) error {
+		rel, _ := filepath.Rel(root, entry.Path)
+		result = append(result, filepath.ToSlash(rel))
+		return fn(entry)
+	})
+	return result, err
+}
+
+func TestWalkFiles(t *testing.T) {
+	root := makeTestTree(t, map[string]string{
+		"a.txt":         "",
+		"b/c.txt":       "",
+		"b/d/e.txt":     "",
+		"f/g.txt":       "",
+		"f/h.txt":       "",
+		"i/.nomagic":    "",
+		"i/j.txt":       "",
+		".hidden/k.txt": "",
+	})
+	defer os.RemoveAll(root)
+
+	opts := IteratorOptions{Prune: DefaultPruneChain(), Filter: defaultFilterChain()}
+	cases := []struct {
+		fn       WalkFunc
+		expected string
+	}{
+		{
+			fn:       func(entry FileEntry) error { return nil },
+			expected: "a.txt b f b/c.txt b/d f/g.txt f/h.txt b/d/e.txt",
+		},
+		{
+			fn: func(entry FileEntry) error {
+				if entry.Info.Name() == "b" {
+					return SkipDir
+				}
+				return nil
+			},
+			expected: "a.txt b f f/g.txt f/h.txt",
+		},
+		{
+			// SkipDir on a file skips the rest of its directory
+			fn: func(entry FileEntry) error {
+				if entry.Info.Name() == "g.txt" {
+					return SkipDir
+				}
+				return nil
+			},
+			expected: "a.txt b f b/c.txt b/d f/g.txt b/d/e.txt",
+		},
+		{
+			fn: func(entry FileEntry) error {
+				if entry.Info.Name() == "c.txt" {
+					return StopWalk
+				}
+				return nil
+			},
+			expected: "a.txt b f b/c.txt",
+		},
+	}
+	for i, c := range cases {
+		result, err := walkTestTree(t, root, opts, c.fn)
+		if err != nil {
+			t.Errorf("Case %d: %v", i, err)
+		}
+		if strings.Join(result, " ") != c.expected {
+			t.Errorf("Case %d: walk result is error: %s", i, strings.Join(result, " "))
+		}
+	}
+
+	// files are walked in the same order as iterator
+	files := make([]string, 0)
+	WalkFiles(context.Background(), root, opts, func(entry FileEntry) error {
+		if !entry.Info.IsDir() {
+			files = append(files, entry.Path)
+		}
+		return nil
+	})
+	iterator, _ := NewFileIteratorWithOptions(root, opts)
+	for i := 0; iterator.HasNext(); i++ {
+		file, _ := iterator.Next()
+		if i >= len(files) || files[i] != file {
+			t.Fatalf("File %d of walk is different from iterator: %s", i, file)
+		}
+	}
+}
+
+func TestWalkFilesCancel(t *testing.T) {
+	root := makeTestTree(t, map[string]string{
+		"a/b.txt": "",
+		"c/d.txt": "",
+		"e/f.txt": "",
+	})
+	defer os.RemoveAll(root)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	visited := 0
+	err := WalkFiles(ctx, root, IteratorOptions{}, func(entry FileEntry) error {
+		visited++
+		if entry.Info.Name() == "a" {
+			cancel()
+		}
+		return nil
+	})
+	if err != context.Canceled || visited != 1 {
+		t.Errorf("Walk should stop after cancel: %v, %d visited", err, visited)
+	}
+
+	// a cancelled context stops before reading root
+	if err := WalkFiles(ctx, root, IteratorOptions{}, func(FileEntry) error { return nil }); err != context.Canceled {
+		t.Errorf("Walk with cancelled context is error: %v", err)
+	}
+	if _, err := NewFileIteratorWithContext(ctx, root, IteratorOptions{}); err != context.Canceled {
+		t.Errorf("Iterator with cancelled context is error: %v", err)
+	}
+}
+
+func TestFileIteratorWithContext(t *testing.T) {
+	root := makeTestTree(t, map[string]string{
+		"a.txt":   "",
+		"b/c.txt": "",
+	})
+	defer os.RemoveAll(root)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	iterator, err := NewFileIteratorWithContext(ctx, root, IteratorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file, err := iterator.Next(); err != nil || filepath.Base(file) != "a.txt" {
+		t.Fatalf("First file is error: %s, %v", file, err)
+	}
+
+	// the walk stops before reading the next directory
+	cancel()
+	if _, err := iterator.Next(); err != context.Canceled {
+		t.Errorf("Iterator should stop after cancel: %v", err)
+	}
+	if iterator.HasNext() {
+		t.Error("Iterator should be over after cancel.")
+	}
+}
+
+func TestStreamFiles(t *testing.T) {
+	root := makeTestTree(t, map[string]string{
+		"a.txt":   "",
+		"b/c.txt": "",
+		"b/d.txt": "",
+	})
+	defer os.RemoveAll(root)
+
+	entries, errc := StreamFiles(context.Background(), root, IteratorOptions{})
+	result := make([]string, 0)
+	for entry := range entries {
+		rel, _ := filepath.Rel(root, entry.Path)
+		result = append(result, filepath.ToSlash(rel))
+	}
+	if err := <-errc; err != nil {
+		t.Error(err)
+	}
+	if strings.Join(result, " ") != "a.txt b/c.txt b/d.txt" {
+		t.Errorf("Stream result is error: %s", strings.Join(result, " "))
+	}
+
+	// the walk goroutine exits if receiver stops and cancels ctx
+	ctx, cancel := context.WithCancel(context.Background())
+	entries, errc = StreamFiles(ctx, root, IteratorOptions{})
+	<-entries
+	cancel()
+	if err := <-errc; err != context.Canceled {
+		t.Errorf("Stream should stop after cancel: %v", err)
+	}
+}
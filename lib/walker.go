@@ -0,0 +1,245 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package lib
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// TraversalOrder decides the order directories are walked in.
+type TraversalOrder int
+
+const (
+	// BreadthFirst walks all directories of a level before the next level.
+	BreadthFirst TraversalOrder = iota
+	// DepthFirst walks all sub directories of a directory before its next
+	// sibling.
+	DepthFirst
+)
+
+var traversalOrderNames = map[TraversalOrder]string{
+	BreadthFirst: "bfs",
+	DepthFirst:   "dfs",
+}
+
+func (to TraversalOrder) String() string {
+	return traversalOrderNames[to]
+}
+
+// ParseTraversalOrder parses name of order, which is bfs or dfs.
+func ParseTraversalOrder(name string) (TraversalOrder, error) {
+	for order, n := range traversalOrderNames {
+		if n == name {
+			return order, nil
+		}
+	}
+	return BreadthFirst, fmt.Errorf("unknown traversal order: %s", name)
+}
+
+// SortKey decides the order of entries in a directory.
+type SortKey int
+
+const (
+	// SortByName sorts entries by name.
+	SortByName SortKey = iota
+	// SortBySize sorts entries from the smallest to the largest.
+	SortBySize
+	// SortByMTime sorts entries from the oldest to the newest.
+	SortByMTime
+)
+
+var sortKeyNames = map[SortKey]string{
+	SortByName:  "name",
+	SortBySize:  "size",
+	SortByMTime: "mtime",
+}
+
+func (sk SortKey) String() string {
+	return sortKeyNames[sk]
+}
+
+// ParseSortKey parses name of key, which is one of name, size and mtime.
+func ParseSortKey(name string) (SortKey, error) {
+	for key, n := range sortKeyNames {
+		if n == name {
+			return key, nil
+		}
+	}
+	return SortByName, fmt.Errorf("unknown sort key: %s", name)
+}
+
+// sortEntries sorts entries sorted by name by key, entries with the same
+// key keep sorted by name.
+func sortEntries(entries []FileEntry, key SortKey) {
+	switch key {
+	case SortBySize:
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].Info.Size() < entries[j].Info.Size()
+		})
+	case SortByMTime:
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].Info.ModTime().Before(entries[j].Info.ModTime())
+		})
+	}
+}
+
+// fileID identifies a directory, by device and inode if they are supported
+// or by the real path.
+type fileID struct {
+	dev, ino uint64
+	path     string
+}
+
+// walker decides which entries are returned and which directories are
+// walked, it is shared by iterators and WalkFiles.
+type walker struct {
+	opts  IteratorOptions
+	fs    FileSystem
+	queue *dirQueue
+
+	// visited records directories walked when following symlinks.
+	visited map[fileID]bool
+}
+
+// newWalker create a walker configured by opts.
+func newWalker(ctx context.Context, opts IteratorOptions) (*walker, error) {
+	if opts.ErrorPolicy == ErrorSkipCollect && opts.Report == nil {
+		return nil, errReportRequired
+	}
+	if opts.MinDepth < 0 || opts.MaxDepth < 0 {
+		return nil, fmt.Errorf("depth limits should not be negative: %d, %d", opts.MinDepth, opts.MaxDepth)
+	}
+
+	fsys := walkFileSystem(opts)
+	if opts.FS != nil || opts.Archives {
+		SetChainFileSystem(opts.Prune, fsys)
+		SetChainFileSystem(opts.Filter, fsys)
+	}
+	SetChainFilterTracer(opts.Prune, opts.Tracer)
+	SetChainFilterTracer(opts.Filter, opts.Tracer)
+	if opts.Tracer != nil {
+		opts.Tracer.setFileSystem(fsys)
+	}
+
+	return &walker{
+		opts:    opts,
+		fs:      fsys,
+		queue:   newDirQueue(ctx, fsys, opts.Workers, opts.Order == DepthFirst),
+		visited: make(map[fileID]bool),
+	}, nil
+}
+
+// walkFileSystem return the FileSystem to walk configured by opts.
+func walkFileSystem(opts IteratorOptions) FileSystem {
+	fsys := fileSystemOrOS(opts.FS)
+	if opts.Archives {
+		fsys = NewArchiveFileSystem(fsys)
+	}
+	return fsys
+}
+
+// handleError handles err by error policy, it return err only under
+// ErrorAbort policy.
+func (w *walker) handleError(err *PathError) error {
+	if herr := w.opts.ErrorPolicy.Handle(err, w.opts.Report); herr != nil {
+		return herr
+	}
+	if w.opts.skipped != nil {
+		w.opts.skipped(err)
+	}
+	return nil
+}
+
+// load return entries of infos under dir kept by filters, in the order of
+// opts. An error is returned under ErrorAbort policy, with entries kept
+// before it.
+func (w *walker) load(dir FileEntry, infos []os.FileInfo) ([]FileEntry, error) {
+	entries := make([]FileEntry, 0, len(infos))
+	for _, info := range infos {
+		if w.opts.FollowSymlinks && info.Mode()&os.ModeSymlink != 0 {
+			// a broken link is kept as a symlink
+			if target, err := w.fs.Stat(filepath.Join(dir.Path, info.Name())); err == nil {
+				info = target
+			}
+		}
+		if w.opts.Archives && info.Mode().IsRegular() && IsArchiveName(info.Name()) {
+			info = archiveDirInfo{info}
+		}
+		entries = append(entries, dir.child(info))
+	}
+	sortEntries(entries, w.opts.SortBy)
+
+	kept := entries[:0]
+	for _, entry := range entries {
+		chain := w.opts.Filter
+		if entry.Info.IsDir() {
+			chain = w.opts.Prune
+		}
+
+		ok, err := FilterWithTracer(chain, entry.Path, entry.Info, w.opts.Tracer)
+		if err != nil {
+			// errors of FilterWithTracer are always *PathError
+			if err = w.handleError(err.(*PathError)); err != nil {
+				return kept, err
+			}
+			continue
+		}
+		if ok {
+			kept = append(kept, entry)
+		}
+	}
+	return kept, nil
+}
+
+// returns checks if entry is deep enough to be returned, entries deeper
+// than MaxDepth are never loaded.
+func (w *walker) returns(entry FileEntry) bool {
+	return entry.Depth >= w.opts.MinDepth
+}
+
+// descends checks if directory entry should be walked, it is false beyond
+// MaxDepth or for a directory walked before through symlinks.
+func (w *walker) descends(entry FileEntry) bool {
+	if w.opts.MaxDepth > 0 && entry.Depth >= w.opts.MaxDepth {
+		return false
+	}
+	if !w.opts.FollowSymlinks {
+		return true
+	}
+
+	id := fileID{dev: entry.Dev, ino: entry.Ino}
+	if id.dev == 0 && id.ino == 0 {
+		real, err := EvalSymlinks(w.fs, entry.Abs)
+		if err != nil {
+			real = entry.Abs
+		}
+		id.path = real
+	}
+	if w.visited[id] {
+		Logger.Debugf("skip directory walked before: %s", entry.Path)
+		return false
+	}
+	w.visited[id] = true
+	return true
+}
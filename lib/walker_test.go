@@ -0,0 +1,152 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package lib
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// iterateRel return slash separated relative paths of entries produced by
+// iterator in order.
+func iterateRel(t *testing.T, root string, opts IteratorOptions) []string {
+	iterator, err := NewEntryIterator(context.Background(), root, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := make([]string, 0)
+	for iterator.HasNext() {
+		entry, err := iterator.NextEntry()
+		if err != nil {
+			t.Fatal(err)
+		}
+		result = append(result, filepath.ToSlash(entry.Rel))
+	}
+	return result
+}
+
+func TestTraversalOptions(t *testing.T) {
+	root := makeTestTree(t, map[string]string{
+		"a.txt":       "aaa",
+		"b.txt":       "b",
+		"x/c.txt":     "",
+		"x/y/d.txt":   "",
+		"x/y/z/e.txt": "",
+		"w/f.txt":     "",
+	})
+	defer os.RemoveAll(root)
+
+	// b.txt is older than a.txt
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(filepath.Join(root, "b.txt"), old, old)
+
+	cases := []struct {
+		opts     IteratorOptions
+		expected string
+	}{
+		{IteratorOptions{}, "a.txt b.txt w/f.txt x/c.txt x/y/d.txt x/y/z/e.txt"},
+		{IteratorOptions{MaxDepth: 1}, "a.txt b.txt"},
+		{IteratorOptions{MaxDepth: 2}, "a.txt b.txt w/f.txt x/c.txt"},
+		{IteratorOptions{MinDepth: 3}, "x/y/d.txt x/y/z/e.txt"},
+		{IteratorOptions{MinDepth: 2, MaxDepth: 3}, "w/f.txt x/c.txt x/y/d.txt"},
+		{IteratorOptions{Order: DepthFirst}, "a.txt b.txt w/f.txt x/c.txt x/y/d.txt x/y/z/e.txt"},
+		{IteratorOptions{SortBy: SortBySize}, "b.txt a.txt w/f.txt x/c.txt x/y/d.txt x/y/z/e.txt"},
+		{IteratorOptions{SortBy: SortByMTime, MaxDepth: 1}, "b.txt a.txt"},
+		{IteratorOptions{MinDepth: -1}, ""},
+	}
+	for i, c := range cases {
+		if c.opts.MinDepth < 0 {
+			if _, err := NewEntryIterator(context.Background(), root, c.opts); err == nil {
+				t.Errorf("Case %d: negative depth should be invalid.", i)
+			}
+			continue
+		}
+		if result := strings.Join(iterateRel(t, root, c.opts), " "); result != c.expected {
+			t.Errorf("Case %d: iterate result is error: %s", i, result)
+		}
+	}
+}
+
+func TestTraversalOrder(t *testing.T) {
+	root := makeTestTree(t, map[string]string{
+		"a/a1/f.txt": "",
+		"a/f.txt":    "",
+		"b/b1/f.txt": "",
+		"b/f.txt":    "",
+		"f.txt":      "",
+	})
+	defer os.RemoveAll(root)
+
+	for _, workers := range []int{0, 4} {
+		bfs := iterateRel(t, root, IteratorOptions{Workers: workers})
+		if strings.Join(bfs, " ") != "f.txt a/f.txt b/f.txt a/a1/f.txt b/b1/f.txt" {
+			t.Errorf("Breadth first order is error: %v", bfs)
+		}
+		dfs := iterateRel(t, root, IteratorOptions{Workers: workers, Order: DepthFirst})
+		if strings.Join(dfs, " ") != "f.txt a/f.txt a/a1/f.txt b/f.txt b/b1/f.txt" {
+			t.Errorf("Depth first order is error: %v", dfs)
+		}
+
+		walked := make([]string, 0)
+		WalkFiles(context.Background(), root, IteratorOptions{Workers: workers, Order: DepthFirst}, func(entry FileEntry) error {
+			walked = append(walked, filepath.ToSlash(entry.Rel))
+			return nil
+		})
+		if strings.Join(walked, " ") != "a b f.txt a/a1 a/f.txt a/a1/f.txt b/b1 b/f.txt b/b1/f.txt" {
+			t.Errorf("Depth first walk is error: %v", walked)
+		}
+	}
+}
+
+func TestFollowSymlinks(t *testing.T) {
+	root := makeTestTree(t, map[string]string{
+		"real/f.txt":  "",
+		"other/g.txt": "",
+	})
+	defer os.RemoveAll(root)
+
+	links := map[string]string{
+		"real/loop":  "..",
+		"link":       "other",
+		"file.txt":   "real/f.txt",
+		"broken.txt": "missing",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Skip(err)
+		}
+	}
+
+	result := iterateRel(t, root, IteratorOptions{Filter: defaultFilterChain()})
+	if strings.Join(result, " ") != "other/g.txt real/f.txt" {
+		t.Errorf("Symlinks should not be followed: %v", result)
+	}
+
+	// other is walked once through link, and root through real/loop is not
+	// walked again
+	result = iterateRel(t, root, IteratorOptions{Filter: defaultFilterChain(), FollowSymlinks: true})
+	if strings.Join(result, " ") != "file.txt link/g.txt real/f.txt" {
+		t.Errorf("Symlinks are not followed: %v", result)
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+//go:build linux
+// +build linux
+
+package lib
+
+import (
+	"bytes"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// xattrBufferSize is the initial size of buffers of xattr names and
+// values.
+const xattrBufferSize = 1024
+
+// copyXattrs copies extended attributes of src to dst, files of file
+// systems not supporting xattrs have none.
+func copyXattrs(src, dst string) error {
+	names, err := getXattr(func(buf []byte) (int, error) {
+		return unix.Listxattr(src, buf)
+	})
+	if err == unix.ENOTSUP {
+		return nil
+	}
+	if err != nil {
+		return &os.PathError{Op: "listxattr", Path: src, Err: err}
+	}
+
+	for _, name := range bytes.Split(names, []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		value, err := getXattr(func(buf []byte) (int, error) {
+			return unix.Getxattr(src, string(name), buf)
+		})
+		if err != nil {
+			return &os.PathError{Op: "getxattr", Path: src, Err: err}
+		}
+		if err := unix.Setxattr(dst, string(name), value, 0); err != nil {
+			return &os.PathError{Op: "setxattr", Path: dst, Err: err}
+		}
+	}
+	return nil
+}
+
+// getXattr calls get with buffers growing until the result fits.
+func getXattr(get func(buf []byte) (int, error)) ([]byte, error) {
+	size := xattrBufferSize
+	for {
+		buf := make([]byte, size)
+		n, err := get(buf)
+		if err == unix.ERANGE {
+			// query the size needed
+			if n, err = get(nil); err != nil {
+				return nil, err
+			}
+			size = n + xattrBufferSize
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+}
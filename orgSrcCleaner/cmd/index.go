@@ -0,0 +1,181 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/MephistoMMM/magician/lib"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	indexVerify  bool
+	indexHash    bool
+	indexCommand string
+)
+
+// indexCmd represents the index command
+var indexCmd = &cobra.Command{
+	Use:   "index <directory>",
+	Short: "Rebuild or verify the index of org files used by --incremental.",
+	Long: `index rebuilds the index of org files under directory, which records
+size, mtime, inode and optionally the content hash of each file. With
+--incremental, lint, table and markdown only handle files added or changed
+since the index is updated. Each of them keeps its own index, which is
+updated only after the command succeeds, and --command chooses the one to
+rebuild or verify.
+
+With --verify, the index is not changed, and files added, changed or
+removed since the index is updated are printed as 'kind path'. index
+exits with 1 if any file is found changed.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if err := cobra.ExactArgs(1)(cmd, args); err != nil {
+			return err
+		}
+		if !lib.IsDir(args[0]) {
+			return fmt.Errorf("src is not a directory: %s", args[0])
+		}
+		if !incrementalCommands[indexCommand] {
+			return fmt.Errorf("command has no --incremental: %s", indexCommand)
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		directory, err := filepath.Abs(args[0])
+		if err != nil {
+			log.Fatalln(err)
+		}
+		opts, err := orgIteratorOptions(directory)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		path, err := orgIndexPath(directory, indexCommand)
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		if indexVerify {
+			index, err := lib.LoadFileIndex(path)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			changes, err := index.Verify(walkCtx, opts)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			for _, change := range changes {
+				fmt.Printf("%s %s\n", change.Kind, change.Path)
+			}
+			if len(changes) > 0 {
+				reportFilters()
+				os.Exit(1)
+			}
+			return
+		}
+
+		index, err := lib.BuildFileIndex(walkCtx, directory, opts, indexHash)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if err := index.Save(path); err != nil {
+			log.Fatalln(err)
+		}
+		fmt.Printf("%d files indexed in %s\n", len(index.Entries), path)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(indexCmd)
+
+	indexCmd.Flags().BoolVar(&indexVerify, "verify", false, "print files changed since the index is updated, and compare all hashes")
+	indexCmd.Flags().BoolVar(&indexHash, "hash", false, "record content hashes of files")
+	indexCmd.Flags().StringVar(&indexCommand, "command", lintCmd.Name(), "command whose index is rebuilt or verified: lint, table or markdown")
+}
+
+// orgIndexPath return the path of index file of directory used by command,
+// it is given by `--index-file` with command inserted before the extension,
+// or named by command and the hash of directory in user cache directory.
+func orgIndexPath(directory, command string) (string, error) {
+	if path := viper.GetString("index-file"); path != "" {
+		ext := filepath.Ext(path)
+		return strings.TrimSuffix(path, ext) + "-" + command + ext, nil
+	}
+
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(directory))
+	name := "orgSrcCleaner-" + command + "-" + hex.EncodeToString(sum[:8]) + ".json"
+	return filepath.Join(cache, "magician", name), nil
+}
+
+// changedOrgFiles return org files under directory added or changed since
+// the last successful run of command. All files are returned if there is
+// no index yet. The index is saved by commit, which should be called only
+// after the files are handled.
+func changedOrgFiles(directory, command string) (files []string, commit func() error, err error) {
+	directory, err = filepath.Abs(directory)
+	if err != nil {
+		return nil, nil, err
+	}
+	opts, err := orgIteratorOptions(directory)
+	if err != nil {
+		return nil, nil, err
+	}
+	path, err := orgIndexPath(directory, command)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	index, err := lib.LoadFileIndex(path)
+	if err != nil || index.Root != directory {
+		if err != nil && !os.IsNotExist(err) {
+			log.Warnln(err)
+		}
+		if index, err = lib.NewFileIndex(directory, false); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	iterator, err := lib.NewChangeIterator(walkCtx, index, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	files = make([]string, 0)
+	for iterator.HasNext() {
+		change, err := iterator.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		log.Debugf("%s %s", change.Kind, change.Path)
+		if change.Kind != lib.FileRemoved {
+			files = append(files, change.Path)
+		}
+	}
+	return files, func() error { return index.Save(path) }, nil
+}
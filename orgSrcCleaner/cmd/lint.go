@@ -0,0 +1,122 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/MephistoMMM/magician/lib"
+	"github.com/MephistoMMM/magician/orgSrcCleaner/linter"
+	"github.com/spf13/cobra"
+)
+
+// exit codes of lint, fit for pre-commit hooks
+const (
+	lintExitProblems = 1
+	lintExitError    = 2
+)
+
+// lintCmd represents the lint command
+var lintCmd = &cobra.Command{
+	Use:   "lint <path>...",
+	Short: "Report structural problems in org files.",
+	Long: `lint checks org files, or org files under directories, and reports
+structural problems: headline level jumps, duplicate :ID:s, malformed
+timestamps, unclosed #+BEGIN_ blocks, property drawers outside headlines
+and links to missing files.
+
+Each problem is printed as 'file:line:col: message'. lint exits with 0 if
+no problem is found, 1 if any problem is found and 2 if it fails to run.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if err := cobra.MinimumNArgs(1)(cmd, args); err != nil {
+			return err
+		}
+
+		for _, src := range args {
+			if lib.IsNotExist(src) {
+				return fmt.Errorf("src is not exist: %s", src)
+			}
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		ids := linter.NewIDSet()
+		count := 0
+		commits := make([]func() error, 0, len(args))
+		for _, src := range args {
+			files, commit, err := orgFiles(cmd, src)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				lintExit(lintExitError)
+			}
+			commits = append(commits, commit)
+
+			for _, file := range files {
+				problems, err := lintFile(file, ids)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					lintExit(lintExitError)
+				}
+
+				for _, problem := range problems {
+					fmt.Println(problem)
+				}
+				count += len(problems)
+			}
+		}
+
+		// files with problems are linted again in the next run
+		if count > 0 {
+			lintExit(lintExitProblems)
+		}
+		if err := commitAll(commits); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			lintExit(lintExitError)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(lintCmd)
+	addIncrementalFlag(lintCmd)
+}
+
+// lintExit reports filters and exits with code.
+func lintExit(code int) {
+	reportFilters()
+	os.Exit(code)
+}
+
+// lintFile return problems found in file.
+func lintFile(file string, ids *linter.IDSet) ([]*linter.Problem, error) {
+	parser := linter.NewOrgLintParser(file, ids)
+	results, err := lib.ScanLinesFS(orgFS, parser)
+	if err != nil {
+		return nil, err
+	}
+
+	problems := make([]*linter.Problem, 0)
+	for _, result := range results {
+		problems = append(problems, result.([]*linter.Problem)...)
+	}
+	return append(problems, parser.Finish()...), nil
+}
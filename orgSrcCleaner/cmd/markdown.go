@@ -0,0 +1,93 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/MephistoMMM/magician/lib"
+	"github.com/MephistoMMM/magician/orgSrcCleaner/exporter"
+	"github.com/spf13/cobra"
+)
+
+var markdownAssets string
+
+// markdownCmd represents the markdown command
+var markdownCmd = &cobra.Command{
+	Use:   "markdown <src> <dst>",
+	Short: "Convert org files to markdown files.",
+	Long: `markdown converts org file, or org files under directory src, to
+markdown files under directory dst. Headlines become '#' headings, links to
+files and images become markdown links, and links to org files are pointed
+to the converted markdown files.
+
+Linked assets under src are copied to the same relative path under dst, and
+the others are copied into the assets directory, so every note linking the
+same asset points to the same copy.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if err := cobra.ExactArgs(2)(cmd, args); err != nil {
+			return err
+		}
+
+		if lib.IsNotExist(args[0]) {
+			return fmt.Errorf("src is not exist: %s", args[0])
+		}
+		if lib.IsFile(args[1]) {
+			return fmt.Errorf("dst is not a directory: %s", args[1])
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		src, dst := args[0], args[1]
+		srcRoot := src
+		if !lib.IsDir(src) {
+			srcRoot = filepath.Dir(src)
+		}
+
+		converter, err := exporter.NewMarkdownConverter(srcRoot, dst, markdownAssets)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		converter.SetFileSystem(orgFS)
+
+		files, commit, err := orgFiles(cmd, src)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		for _, file := range files {
+			log.Debugf("Convert %s to %s", file, converter.Target(file))
+			if err := converter.Convert(file); err != nil {
+				log.Fatalln(err)
+			}
+		}
+		if err := commit(); err != nil {
+			log.Fatalln(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(markdownCmd)
+	addIncrementalFlag(markdownCmd)
+
+	markdownCmd.Flags().StringVar(&markdownAssets, "assets", "assets", "directory under dst for assets outside src")
+}
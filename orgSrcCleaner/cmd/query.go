@@ -0,0 +1,138 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/MephistoMMM/magician/lib"
+	"github.com/MephistoMMM/magician/orgSrcCleaner/parser"
+	"github.com/MephistoMMM/magician/orgSrcCleaner/query"
+	"github.com/spf13/cobra"
+)
+
+var (
+	queryMatch      string
+	queryTodo       []string
+	queryProperties []string
+	queryRegexp     string
+)
+
+// queryCmd represents the query command
+var queryCmd = &cobra.Command{
+	Use:   "query <path>...",
+	Short: "Search headlines by tags, TODO state, properties and text.",
+	Long: `query searches headlines in org files, or org files under directories,
+and prints each matching headline as 'file:line: breadcrumb'.
+
+--match accepts org-mode tags/property match strings, like '+work-done',
+'work|home/NEXT' or 'EFFORT>1+PRIORITY="A"'. All given conditions should
+be satisfied by a matching headline.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if err := cobra.MinimumNArgs(1)(cmd, args); err != nil {
+			return err
+		}
+
+		for _, src := range args {
+			if lib.IsNotExist(src) {
+				return fmt.Errorf("src is not exist: %s", src)
+			}
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		matcher, err := queryMatcher()
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		commits := make([]func() error, 0, len(args))
+		for _, src := range args {
+			files, commit, err := orgFiles(cmd, src)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			commits = append(commits, commit)
+
+			for _, file := range files {
+				headlines, err := lib.ScanLinesFS(orgFS, parser.NewOrgHeadlineParser(file))
+				if err != nil {
+					log.Fatalln(err)
+				}
+
+				for _, result := range headlines {
+					headline := result.(*parser.OrgHeadline)
+					if matcher(headline) {
+						fmt.Printf("%s:%d: %s\n", headline.File, headline.Line, headline.Breadcrumb())
+					}
+				}
+			}
+		}
+		if err := commitAll(commits); err != nil {
+			log.Fatalln(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(queryCmd)
+
+	queryCmd.Flags().StringVarP(&queryMatch, "match", "m", "", "tags/property match string")
+	queryCmd.Flags().StringSliceVarP(&queryTodo, "todo", "t", nil, "TODO states to match, any of them")
+	queryCmd.Flags().StringArrayVarP(&queryProperties, "property", "p", nil, "property to match, as KEY=VALUE")
+	queryCmd.Flags().StringVarP(&queryRegexp, "regexp", "r", "", "regexp to match headline text")
+}
+
+// queryMatcher create a Matcher from flags.
+func queryMatcher() (query.Matcher, error) {
+	matchers := make([]query.Matcher, 0)
+	if queryMatch != "" {
+		m, err := query.Compile(queryMatch)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+
+	if len(queryTodo) > 0 {
+		matchers = append(matchers, query.MatchTodo(queryTodo...))
+	}
+
+	for _, property := range queryProperties {
+		kv := strings.SplitN(property, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("property should be KEY=VALUE: %s", property)
+		}
+		matchers = append(matchers, query.MatchProperty(kv[0], kv[1]))
+	}
+
+	if queryRegexp != "" {
+		pattern, err := regexp.Compile(queryRegexp)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, query.MatchTitle(pattern))
+	}
+
+	return query.All(matchers...), nil
+}
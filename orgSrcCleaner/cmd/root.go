@@ -20,10 +20,14 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/MephistoMMM/magician/lib"
 	"github.com/MephistoMMM/magician/orgSrcCleaner/fileIterator"
@@ -35,6 +39,28 @@ import (
 
 var cfgFile string
 
+// explainFilters is the value of `--explain-filters`, noExplainedPath if no
+// path is given.
+var explainFilters string
+
+const noExplainedPath = "-"
+
+var (
+	filterTracer *lib.FilterTracer
+	walkErrors   = lib.NewErrorReport()
+	reportOnce   sync.Once
+)
+
+// orgFS is the file system org files are read from, files in archives like
+// `notes.zip!/a.org` are read from it with `--archives`.
+var orgFS lib.FileSystem = lib.OSFS
+
+// walkCtx stops walks of org files on interrupt or after `--timeout`.
+var (
+	walkCtx    = context.Background()
+	cancelWalk = func() {}
+)
+
 var log = lib.Logger
 
 // rootCmd represents the base command when called without any subcommands
@@ -58,16 +84,38 @@ headline.`,
 
 		return nil
 	},
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		walkCtx, cancelWalk = newWalkContext(viper.GetDuration("timeout"))
+		if viper.GetBool("archives") {
+			orgFS = lib.NewArchiveFileSystem(lib.OSFS)
+		}
+		if explainFilters == "" {
+			return nil
+		}
+
+		filterTracer = lib.NewFilterTracer(5)
+		if explainFilters != noExplainedPath {
+			if err := filterTracer.Explain(explainFilters); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	PersistentPostRun: func(cmd *cobra.Command, args []string) {
+		cancelWalk()
+		reportFilters()
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		directory, err := filepath.Abs(args[0])
 		if err != nil {
 			log.Fatalln(err)
 		}
 		log.Debug(directory)
-		iterator, err := fileIterator.NewFileIterator(directory)
+		files, commit, err := orgFiles(cmd, directory)
 		if err != nil {
 			log.Fatalln(err)
 		}
+		var failed int32
 
 		var wg, wg2 sync.WaitGroup
 		wg.Add(1)
@@ -81,18 +129,15 @@ headline.`,
 			wg.Done()
 		}(orgLinks)
 
-		for iterator.HasNext() {
-			file, err := iterator.Next()
-			if err != nil {
-				log.Fatalln(err)
-			}
+		for _, file := range files {
 			log.Debug(file)
 
 			wg2.Add(1)
 			go func(file string) {
-				links, err := lib.ScanLines(parser.NewOrgLinkParser(file))
+				links, err := lib.ScanLinesFS(orgFS, parser.NewOrgLinkParser(file))
 				if err != nil {
 					log.Errorln(err)
+					atomic.StoreInt32(&failed, 1)
 					wg2.Done()
 					return
 				}
@@ -109,9 +154,164 @@ headline.`,
 		close(orgLinks)
 
 		wg.Wait()
+		if atomic.LoadInt32(&failed) == 0 {
+			if err := commit(); err != nil {
+				log.Fatalln(err)
+			}
+		}
 	},
 }
 
+// newWalkContext return a context cancelled on the first interrupt, or after
+// timeout if it is positive. A second interrupt kills the process as usual.
+func newWalkContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		select {
+		case <-interrupts:
+			log.Warnln("interrupted, stopping walk")
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(interrupts)
+	}()
+	return ctx, cancel
+}
+
+// reportFilters writes errors skipped during walks, statistics of filters,
+// and decisions for the path given by `--explain-filters`, to stderr once.
+func reportFilters() {
+	reportOnce.Do(func() {
+		walkErrors.WriteTo(os.Stderr)
+		if filterTracer != nil {
+			filterTracer.WriteStats(os.Stderr)
+			filterTracer.WriteExplain(os.Stderr)
+		}
+	})
+}
+
+// orgIteratorOptions return options of iterating org files under directory
+// kept by filters declared in config and the filter expression of
+// `--filter`, errors are handled by `--on-error`. Filters are declared in
+// config like:
+//
+//	prune:
+//	  - type: dot
+//	  - type: ignoreMarker
+//	filters:
+//	  - type: expr
+//	    args: ['not path("archive/**")']
+func orgIteratorOptions(directory string) (lib.IteratorOptions, error) {
+	specs := make([]lib.FilterSpec, 0)
+	if err := viper.UnmarshalKey("filters", &specs); err != nil {
+		return lib.IteratorOptions{}, err
+	}
+	if expr := viper.GetString("filter"); expr != "" {
+		specs = append(specs, lib.FilterSpec{Type: "expr", Args: []string{expr}})
+	}
+	filter, err := lib.NewFilterChain(directory, specs)
+	if err != nil {
+		return lib.IteratorOptions{}, err
+	}
+	if filter != nil {
+		log.Debugf("filter: %s", filter)
+	}
+
+	policy, err := lib.ParseErrorPolicy(viper.GetString("on-error"))
+	if err != nil {
+		return lib.IteratorOptions{}, err
+	}
+
+	opts := fileIterator.OrgIteratorOptions(filter)
+	opts.ErrorPolicy = policy
+	opts.Report = walkErrors
+	opts.Tracer = filterTracer
+	opts.Workers = viper.GetInt("workers")
+	opts.Archives = viper.GetBool("archives")
+
+	pruneSpecs := make([]lib.FilterSpec, 0)
+	if err := viper.UnmarshalKey("prune", &pruneSpecs); err != nil {
+		return lib.IteratorOptions{}, err
+	}
+	if len(pruneSpecs) > 0 {
+		if opts.Prune, err = lib.NewFilterChain(directory, pruneSpecs); err != nil {
+			return lib.IteratorOptions{}, err
+		}
+	}
+	return opts, nil
+}
+
+// newOrgFileIterator create a iterator of org files under directory, the
+// walk stops with walkCtx.
+func newOrgFileIterator(directory string) (lib.FileIterator, error) {
+	opts, err := orgIteratorOptions(directory)
+	if err != nil {
+		return nil, err
+	}
+	return lib.NewFileIteratorWithContext(walkCtx, directory, opts)
+}
+
+// incrementalCommands are names of commands with `--incremental`.
+var incrementalCommands = make(map[string]bool)
+
+// addIncrementalFlag adds `--incremental` to cmd, which handles each org file
+// alone, so files not changed since its last run could be skipped. Commands
+// whose results cover all files, like query, do not have it.
+func addIncrementalFlag(cmd *cobra.Command) {
+	cmd.Flags().Bool("incremental", false, "only handle org files added or changed since the last successful run")
+	incrementalCommands[cmd.Name()] = true
+}
+
+// orgFiles return src itself if it is a file, or org files under it. Only
+// files added or changed since the last successful run of cmd are returned
+// with `--incremental`, and commit records the run after cmd handles the
+// files successfully.
+func orgFiles(cmd *cobra.Command, src string) (files []string, commit func() error, err error) {
+	commit = func() error { return nil }
+	if !lib.IsDir(src) {
+		return []string{src}, commit, nil
+	}
+	if incremental, _ := cmd.Flags().GetBool("incremental"); incremental {
+		return changedOrgFiles(src, cmd.Name())
+	}
+
+	iterator, err := newOrgFileIterator(src)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	files = make([]string, 0)
+	for iterator.HasNext() {
+		file, err := iterator.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		files = append(files, file)
+	}
+	return files, commit, nil
+}
+
+// commitAll calls all commit functions returned by orgFiles, and return the
+// first error.
+func commitAll(commits []func() error) error {
+	var err error
+	for _, commit := range commits {
+		if cerr := commit(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
@@ -128,6 +328,22 @@ func init() {
 	// Cobra supports persistent flags, which, if defined here,
 	// will be global for your application.
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.orgSrcCleaner.yaml)")
+	rootCmd.PersistentFlags().String("filter", "", `filter expression of org files, like 'not path("archive/**") and mtime<30d'`)
+	viper.BindPFlag("filter", rootCmd.PersistentFlags().Lookup("filter"))
+	rootCmd.PersistentFlags().StringVar(&explainFilters, "explain-filters", "",
+		"print statistics of filters, and which filter decided for the given path")
+	rootCmd.PersistentFlags().Lookup("explain-filters").NoOptDefVal = noExplainedPath
+	rootCmd.PersistentFlags().String("on-error", lib.ErrorSkipCollect.String(),
+		"what to do when a path fails to be read: collect, log or abort")
+	viper.BindPFlag("on-error", rootCmd.PersistentFlags().Lookup("on-error"))
+	rootCmd.PersistentFlags().Duration("timeout", 0, "stop walking org files after the duration, like 30s or 5m")
+	viper.BindPFlag("timeout", rootCmd.PersistentFlags().Lookup("timeout"))
+	rootCmd.PersistentFlags().Int("workers", 1, "number of directories read concurrently")
+	viper.BindPFlag("workers", rootCmd.PersistentFlags().Lookup("workers"))
+	rootCmd.PersistentFlags().Bool("archives", false, "walk into zip and tar archives, like notes.zip!/a.org")
+	viper.BindPFlag("archives", rootCmd.PersistentFlags().Lookup("archives"))
+	rootCmd.PersistentFlags().String("index-file", "", "index file of --incremental, suffixed with the command name (default is in user cache directory)")
+	viper.BindPFlag("index-file", rootCmd.PersistentFlags().Lookup("index-file"))
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
@@ -0,0 +1,123 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/MephistoMMM/magician/lib"
+	"github.com/spf13/cobra"
+)
+
+var (
+	tableWrite bool
+	tableList  bool
+)
+
+// tableCmd represents the table command
+var tableCmd = &cobra.Command{
+	Use:   "table <path>...",
+	Short: "Realign tables in org and markdown files.",
+	Long: `table realigns '|' delimited tables in org files, or org files under
+directories, by display width of cells, so CJK text is aligned too. Markdown
+files given as arguments are formatted as markdown tables.
+
+By default the formatted files are printed to stdout. With --list only the
+files whose tables are not aligned are printed, and with --write they are
+written back in place. Files in archives are read only, so they are skipped
+by --write.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if err := cobra.MinimumNArgs(1)(cmd, args); err != nil {
+			return err
+		}
+
+		for _, src := range args {
+			if lib.IsNotExist(src) {
+				return fmt.Errorf("src is not exist: %s", src)
+			}
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		commits := make([]func() error, 0, len(args))
+		for _, src := range args {
+			files, commit, err := orgFiles(cmd, src)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			commits = append(commits, commit)
+
+			for _, file := range files {
+				if err := formatTables(file); err != nil {
+					log.Fatalln(err)
+				}
+			}
+		}
+		if err := commitAll(commits); err != nil {
+			log.Fatalln(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(tableCmd)
+	addIncrementalFlag(tableCmd)
+
+	tableCmd.Flags().BoolVarP(&tableWrite, "write", "w", false, "write result to source file instead of stdout")
+	tableCmd.Flags().BoolVarP(&tableList, "list", "l", false, "list files whose tables are not aligned")
+}
+
+// formatTables realigns tables in file and outputs it according to flags.
+func formatTables(file string) error {
+	data, err := lib.ReadFileFS(orgFS, file)
+	if err != nil {
+		return err
+	}
+
+	ext := strings.ToLower(filepath.Ext(file))
+	markdown := ext == ".md" || ext == ".markdown"
+	lines, changed := lib.FormatTables(strings.Split(string(data), "\n"), markdown)
+	result := strings.Join(lines, "\n")
+
+	if tableList {
+		if changed {
+			fmt.Println(file)
+		}
+		return nil
+	}
+
+	if tableWrite {
+		if !changed {
+			return nil
+		}
+		if afs, ok := orgFS.(*lib.ArchiveFileSystem); ok && afs.Contains(file) {
+			log.Warnf("Skip %s in archive, which is read only", file)
+			return nil
+		}
+		log.Debugf("Realign tables in %s", file)
+		return lib.WriteFile(file, []byte(result))
+	}
+
+	fmt.Print(result)
+	return nil
+}
@@ -0,0 +1,353 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package exporter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	re "regexp"
+	"strconv"
+	"strings"
+
+	"github.com/MephistoMMM/magician/lib"
+	"github.com/MephistoMMM/magician/orgSrcCleaner/parser"
+)
+
+var (
+	reKeyword     = re.MustCompile(`^\s*#\+(\w+):\s*(.*)$`)
+	reBlockBegin  = re.MustCompile(`^\s*#\+(?i:begin)_(\S+)\s*(\S*)`)
+	reBlockEnd    = re.MustCompile(`^\s*#\+(?i:end)_(\S+)`)
+	reComment     = re.MustCompile(`^\s*#(?:\s|$)`)
+	reDrawerBegin = re.MustCompile(`^\s*:(?i:PROPERTIES|LOGBOOK):\s*$`)
+	reDrawerEnd   = re.MustCompile(`^\s*:(?i:END):\s*$`)
+)
+
+// imageExts are extensions of files linked as images.
+var imageExts = map[string]bool{
+	".png":  true,
+	".jpg":  true,
+	".jpeg": true,
+	".gif":  true,
+	".svg":  true,
+	".webp": true,
+	".bmp":  true,
+}
+
+// MarkdownConverter converts org files under a source directory to markdown
+// files under a target directory. Linked assets inside the source directory
+// are copied to the same relative path under the target directory, and the
+// others are copied into the assets directory of target once, so links to
+// the same asset are rewritten consistently across the whole directory.
+type MarkdownConverter struct {
+	srcRoot   string
+	dstRoot   string
+	assetsDir string
+	fs        lib.FileSystem
+
+	// assets maps absolute path of source asset to its target path, and
+	// targets is the reverse map used to avoid conflicts of names.
+	assets  map[string]string
+	targets map[string]string
+}
+
+// NewMarkdownConverter create a new MarkdownConverter, assetsDir is relative
+// to dstRoot.
+func NewMarkdownConverter(srcRoot, dstRoot, assetsDir string) (*MarkdownConverter, error) {
+	srcRoot, err := filepath.Abs(srcRoot)
+	if err != nil {
+		return nil, err
+	}
+	dstRoot, err = filepath.Abs(dstRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MarkdownConverter{
+		srcRoot:   srcRoot,
+		dstRoot:   dstRoot,
+		assetsDir: filepath.Join(dstRoot, assetsDir),
+		fs:        lib.OSFS,
+		assets:    make(map[string]string),
+		targets:   make(map[string]string),
+	}, nil
+}
+
+// SetFileSystem sets the file system org files and assets are read from.
+func (mc *MarkdownConverter) SetFileSystem(fsys lib.FileSystem) {
+	mc.fs = fsys
+}
+
+// inSrcRoot checks if path is under source directory.
+func (mc *MarkdownConverter) inSrcRoot(path string) bool {
+	rel, err := filepath.Rel(mc.srcRoot, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Target return path of markdown file converted from orgFile.
+func (mc *MarkdownConverter) Target(orgFile string) string {
+	orgFile, _ = filepath.Abs(orgFile)
+	rel, _ := filepath.Rel(mc.srcRoot, orgFile)
+	return filepath.Join(mc.dstRoot, strings.TrimSuffix(rel, filepath.Ext(rel))+".md")
+}
+
+// assetTarget return the target path of asset, and copies asset at the
+// first time it is linked.
+func (mc *MarkdownConverter) assetTarget(asset string) (string, error) {
+	if target, ok := mc.assets[asset]; ok {
+		return target, nil
+	}
+
+	var target string
+	if mc.inSrcRoot(asset) {
+		rel, _ := filepath.Rel(mc.srcRoot, asset)
+		target = filepath.Join(mc.dstRoot, rel)
+	} else {
+		ext := filepath.Ext(asset)
+		base := strings.TrimSuffix(filepath.Base(asset), ext)
+		target = filepath.Join(mc.assetsDir, base+ext)
+		for i := 1; mc.targets[target] != ""; i++ {
+			target = filepath.Join(mc.assetsDir, fmt.Sprintf("%s_%d%s", base, i, ext))
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return "", err
+	}
+	if err := lib.CopyFileFS(mc.fs, asset, target); err != nil {
+		return "", err
+	}
+
+	mc.assets[asset] = target
+	mc.targets[target] = asset
+	return target, nil
+}
+
+// Convert converts orgFile to markdown file, and copies assets linked by it.
+func (mc *MarkdownConverter) Convert(orgFile string) error {
+	orgFile, err := filepath.Abs(orgFile)
+	if err != nil {
+		return err
+	}
+
+	lines, err := lib.ScanLinesFS(mc.fs, newMarkdownLineParser(mc, orgFile))
+	if err != nil {
+		return err
+	}
+
+	var buf strings.Builder
+	for _, line := range lines {
+		buf.WriteString(line.(string))
+		buf.WriteString("\n")
+	}
+	return lib.WriteFile(mc.Target(orgFile), []byte(buf.String()))
+}
+
+// markdownLineParser implements FileLineParser, is used to convert lines
+// of org file to markdown. Dropped lines are returned as nil.
+type markdownLineParser struct {
+	converter *MarkdownConverter
+	file      string
+
+	started bool
+	block   string
+	drawer  bool
+	// todoKeywords are stripped from headings with priorities and tags.
+	todoKeywords map[string]bool
+}
+
+// newMarkdownLineParser create a markdownLineParser converting file.
+func newMarkdownLineParser(converter *MarkdownConverter, file string) *markdownLineParser {
+	todoKeywords := make(map[string]bool)
+	for _, keyword := range parser.DefaultTodoKeywords {
+		todoKeywords[keyword] = true
+	}
+	return &markdownLineParser{converter: converter, file: file, todoKeywords: todoKeywords}
+}
+
+// FilePath return the path of file to be parsed
+func (mp *markdownLineParser) FilePath() string {
+	return mp.file
+}
+
+// Parse converts a line of org file to markdown.
+func (mp *markdownLineParser) Parse(line string) (interface{}, error) {
+	if mp.block != "" {
+		if m := reBlockEnd.FindStringSubmatch(line); m != nil && strings.EqualFold(m[1], mp.block) {
+			block := strings.ToUpper(mp.block)
+			mp.block = ""
+			if block == "SRC" || block == "EXAMPLE" {
+				return "```", nil
+			}
+			return nil, nil
+		}
+
+		switch strings.ToUpper(mp.block) {
+		case "SRC", "EXAMPLE":
+			return line, nil
+		case "QUOTE":
+			converted, err := mp.convertLinks(line)
+			return strings.TrimRight("> "+converted, " "), err
+		}
+		return mp.convertLinks(line)
+	}
+
+	if mp.drawer {
+		mp.drawer = !reDrawerEnd.MatchString(line)
+		return nil, nil
+	}
+
+	if header, ok := parser.MatchHeader(line); ok {
+		mp.started = true
+		level := header.Level
+		if level > 6 {
+			level = 6
+		}
+		_, _, title, _ := parser.SplitHeadline(header.Text, mp.todoKeywords)
+		converted, err := mp.convertLinks(title)
+		return strings.Repeat("#", level) + " " + converted, err
+	}
+
+	if m := reBlockBegin.FindStringSubmatch(line); m != nil {
+		mp.block = m[1]
+		switch strings.ToUpper(m[1]) {
+		case "SRC":
+			return "```" + m[2], nil
+		case "EXAMPLE":
+			return "```", nil
+		}
+		return nil, nil
+	}
+
+	if keywords, ok := parser.TodoKeywords(line); ok {
+		for _, keyword := range keywords {
+			mp.todoKeywords[keyword] = true
+		}
+		return nil, nil
+	}
+
+	if m := reKeyword.FindStringSubmatch(line); m != nil {
+		if strings.EqualFold(m[1], "TITLE") && !mp.started {
+			mp.started = true
+			return "---\ntitle: " + strconv.Quote(m[2]) + "\n---", nil
+		}
+		return nil, nil
+	}
+
+	if reDrawerBegin.MatchString(line) {
+		mp.drawer = true
+		return nil, nil
+	}
+
+	if reComment.MatchString(line) {
+		return nil, nil
+	}
+
+	if strings.TrimSpace(line) != "" {
+		mp.started = true
+	}
+	return mp.convertLinks(line)
+}
+
+// convertLinks rewrites org links in line to markdown links.
+func (mp *markdownLineParser) convertLinks(line string) (string, error) {
+	var buf strings.Builder
+	last := 0
+	for _, link := range parser.MatchLinks(line) {
+		buf.WriteString(line[last:link.Start])
+		last = link.Start + len(link.Link)
+
+		converted, err := mp.convertLink(link)
+		if err != nil {
+			return "", err
+		}
+		buf.WriteString(converted)
+	}
+	buf.WriteString(line[last:])
+	return buf.String(), nil
+}
+
+// convertLink rewrites a org link to markdown link.
+func (mp *markdownLineParser) convertLink(link parser.LinkMatch) (string, error) {
+	target := link.Type + ":" + link.Path
+	image := false
+	switch link.Type {
+	case "file", "img":
+		path, err := mp.rewritePath(link.Path)
+		if err != nil {
+			return "", err
+		}
+		target = path
+		image = link.Type == "img" ||
+			(link.Desc == "" && imageExts[strings.ToLower(filepath.Ext(path))])
+	default:
+		if link.Desc == "" {
+			return "<" + target + ">", nil
+		}
+	}
+
+	if strings.ContainsAny(target, " ()") {
+		target = "<" + target + ">"
+	}
+	if image {
+		return "![" + link.Desc + "](" + target + ")", nil
+	}
+	desc := link.Desc
+	if desc == "" {
+		desc = link.Path
+	}
+	return "[" + desc + "](" + target + ")", nil
+}
+
+// rewritePath return path of linked file relative to markdown file. Linked
+// org files are pointed to their markdown files, and other files are
+// copied as assets.
+func (mp *markdownLineParser) rewritePath(path string) (string, error) {
+	if i := strings.Index(path, "::"); i >= 0 {
+		path = path[:i]
+	}
+
+	linked := path
+	if !filepath.IsAbs(linked) {
+		linked = filepath.Join(filepath.Dir(mp.file), linked)
+	}
+
+	mc := mp.converter
+	var target string
+	switch {
+	case strings.EqualFold(filepath.Ext(linked), ".org") && mc.inSrcRoot(linked):
+		target = mc.Target(linked)
+	case lib.IsFileFS(mc.fs, linked):
+		asset, err := mc.assetTarget(linked)
+		if err != nil {
+			return "", err
+		}
+		target = asset
+	default:
+		lib.Logger.Warnf("%s links to missing file %s", mp.file, path)
+		return filepath.ToSlash(path), nil
+	}
+
+	rel, err := filepath.Rel(filepath.Dir(mc.Target(mp.file)), target)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
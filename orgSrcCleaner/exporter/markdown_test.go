@@ -0,0 +1,87 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package exporter
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/MephistoMMM/magician/lib"
+)
+
+func TestMarkdownConverter(t *testing.T) {
+	dst, err := ioutil.TempDir("", "exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+
+	converter, err := NewMarkdownConverter("testdata/notes", dst, "assets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{"testdata/notes/a.org", "testdata/notes/sub/c.org"} {
+		if err := converter.Convert(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := map[string]string{
+		"a.md": `---
+title: "Note A"
+---
+
+# Intro
+See [note C](sub/c.md) and [site](https://example.com).
+## Picture
+![](assets/pic.png)
+![](img/local.png)
+` + "```go" + `
+fmt.Println("[[file:kept.org]]")
+` + "```" + `
+> quoted [missing](missing.txt)
+>
+## Task
+`,
+		"sub/c.md": `# C
+Back to [A](../a.md), same picture [pic](../assets/pic.png).
+`,
+	}
+	for file, content := range expected {
+		data, err := lib.ReadFile(filepath.Join(dst, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != content {
+			t.Errorf("Content of %s is error:\n%s", file, data)
+		}
+	}
+
+	for _, asset := range []string{"assets/pic.png", "img/local.png"} {
+		if !lib.IsFile(filepath.Join(dst, asset)) {
+			t.Errorf("Asset %s is not copied.", asset)
+		}
+	}
+	if !lib.IsNotExist(filepath.Join(dst, "assets/pic_1.png")) {
+		t.Error("Asset linked twice should be copied once.")
+	}
+}
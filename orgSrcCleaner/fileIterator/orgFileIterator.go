@@ -33,12 +33,30 @@ func orgFilterChain() lib.FilterSupport {
 	if err != nil {
 		panic(err)
 	}
-	filterChain.SetNext(tmp)
+	filterChain.Next().SetNext(tmp)
 	return filterChain
 }
 
 // NewOrgFileIterator create a file iterator to return org files one by one
 func NewOrgFileIterator(directory string) (lib.FileIterator, error) {
-	iterator, _ := lib.NewFileIterator(directory, orgFilterChain())
-	return iterator, nil
+	return NewOrgFileIteratorWithFilter(directory, nil)
+}
+
+// NewOrgFileIteratorWithFilter create a file iterator to return org files
+// also kept by filter one by one, filter could be nil.
+func NewOrgFileIteratorWithFilter(directory string, filter lib.FilterSupport) (lib.FileIterator, error) {
+	return lib.NewFileIteratorWithOptions(directory, OrgIteratorOptions(filter))
+}
+
+// OrgIteratorOptions return options of iterator to return org files also
+// kept by filter, filter could be nil.
+func OrgIteratorOptions(filter lib.FilterSupport) lib.IteratorOptions {
+	filterChain := orgFilterChain()
+	if filter != nil {
+		filterChain.Next().Next().SetNext(filter)
+	}
+	return lib.IteratorOptions{
+		Prune:  lib.DefaultPruneChain(),
+		Filter: filterChain,
+	}
 }
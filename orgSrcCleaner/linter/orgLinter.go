@@ -0,0 +1,343 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package linter
+
+import (
+	"fmt"
+	"path/filepath"
+	re "regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/MephistoMMM/magician/lib"
+	"github.com/MephistoMMM/magician/orgSrcCleaner/parser"
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+var (
+	reBlockBegin = re.MustCompile(`^\s*#\+(?i:begin)_(\S+)`)
+	reBlockEnd   = re.MustCompile(`^\s*#\+(?i:end)_(\S+)`)
+	reDrawer     = re.MustCompile(`^\s*:([\w-]+):\s*$`)
+	reDrawerEnd  = re.MustCompile(`^\s*:(?i:end):\s*$`)
+	reProperty   = re.MustCompile(`^\s*:([^:\s]+):\s*(.*?)\s*$`)
+	rePlanning   = re.MustCompile(`^\s*(?:SCHEDULED|DEADLINE|CLOSED):`)
+	reComment    = re.MustCompile(`^\s*#(?:\s|\+|$)`)
+
+	// reTimestampCandidate matches anything looking like a timestamp,
+	// reTimestamp matches the well formed ones.
+	reTimestampCandidate = re.MustCompile(`[<\[]\d{4}-\d{1,2}-\d{1,2}[^<>\[\]]*[>\]]`)
+	reTimestamp          = re.MustCompile(`^[<\[](\d{4}-\d{2}-\d{2})(?: +[^\s\d>\]+-]+)?(?: +(\d{1,2}):(\d{2})(?:-(\d{1,2}):(\d{2}))?)?(?: +(?:[.+]?\+|--?)\d+[hdwmy](?:/\d+[hdwmy])?)*([>\]])$`)
+)
+
+// localLinkTypes are link types pointing to files in local filesystem.
+var localLinkTypes = map[string]bool{
+	"file": true,
+	"img":  true,
+}
+
+// Problem is a structural problem found in an org file.
+type Problem struct {
+	File    string
+	Line    int
+	Col     int
+	Message string
+}
+
+// String formats problem as `file:line:col: message`, so editors could
+// jump to it.
+func (p *Problem) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s", p.File, p.Line, p.Col, p.Message)
+}
+
+// IDSet records where each `:ID:` property is defined. It is shared by all
+// OrgLintParsers of one run to find duplicate ids across files, and it is
+// not safe for concurrent use.
+type IDSet struct {
+	ids map[string]*Problem
+}
+
+// NewIDSet create a new IDSet
+func NewIDSet() *IDSet {
+	return &IDSet{ids: make(map[string]*Problem)}
+}
+
+// add records id defined at location, and return the location of the first
+// definition if id is duplicate.
+func (s *IDSet) add(id string, location *Problem) *Problem {
+	if first, ok := s.ids[id]; ok {
+		return first
+	}
+	s.ids[id] = location
+	return nil
+}
+
+// OrgLintParser implements FileLineParser, is used to find structural
+// problems in org file. Each call of Parse returns a []*Problem or nil,
+// and Finish should be called after the last line to report problems
+// spanning the whole file.
+type OrgLintParser struct {
+	file string
+	ids  *IDSet
+
+	line      int
+	lastLevel int
+	// afterHeader is true while the previous line is a headline or a
+	// planning line, where a property drawer is allowed
+	afterHeader bool
+	// seenContent is true once the file has content besides comments
+	// and blank lines
+	seenContent bool
+
+	block     string
+	blockLine int
+	blockCol  int
+
+	drawer     string
+	drawerLine int
+	drawerCol  int
+}
+
+// NewOrgLintParser create a new OrgLintParser. file is reported as is in
+// problems.
+func NewOrgLintParser(file string, ids *IDSet) *OrgLintParser {
+	if ids == nil {
+		ids = NewIDSet()
+	}
+
+	return &OrgLintParser{
+		file: file,
+		ids:  ids,
+	}
+}
+
+// FilePath return the path of file to be parsed
+func (lp *OrgLintParser) FilePath() string {
+	return lp.file
+}
+
+// newProblem create a problem at byte offset index of line.
+func (lp *OrgLintParser) newProblem(line string, index int, format string, a ...interface{}) *Problem {
+	return &Problem{
+		File:    lp.file,
+		Line:    lp.line,
+		Col:     utf8.RuneCountInString(line[:index]) + 1,
+		Message: fmt.Sprintf(format, a...),
+	}
+}
+
+// Parse checks a line, and returns problems found in it.
+func (lp *OrgLintParser) Parse(line string) (interface{}, error) {
+	lp.line++
+	problems := lp.parse(line)
+	if len(problems) == 0 {
+		return nil, nil
+	}
+	return problems, nil
+}
+
+func (lp *OrgLintParser) parse(line string) []*Problem {
+	problems := make([]*Problem, 0)
+	afterHeader := lp.afterHeader
+	lp.afterHeader = false
+
+	// content of blocks is not org structure, only look for the end
+	if lp.block != "" {
+		if m := reBlockEnd.FindStringSubmatchIndex(line); m != nil {
+			if name := line[m[2]:m[3]]; !strings.EqualFold(name, lp.block) {
+				problems = append(problems, lp.newProblem(line, m[2],
+					"#+END_%s does not match #+BEGIN_%s at line %d", name, lp.block, lp.blockLine))
+			}
+			lp.block = ""
+		}
+		return problems
+	}
+
+	if header, ok := parser.MatchHeader(line); ok {
+		problems = append(problems, lp.closeDrawer()...)
+		if lp.lastLevel > 0 && header.Level > lp.lastLevel+1 {
+			problems = append(problems, lp.newProblem(line, 0,
+				"headline level jumps from %d to %d", lp.lastLevel, header.Level))
+		}
+		lp.lastLevel = header.Level
+		lp.afterHeader = true
+		lp.seenContent = true
+		return append(problems, lp.checkInline(line)...)
+	}
+
+	if m := reBlockBegin.FindStringSubmatchIndex(line); m != nil {
+		lp.block = line[m[2]:m[3]]
+		lp.blockLine = lp.line
+		lp.blockCol = utf8.RuneCountInString(line[:m[0]]) + 1
+		lp.seenContent = true
+		return problems
+	}
+
+	if m := reBlockEnd.FindStringSubmatchIndex(line); m != nil {
+		lp.seenContent = true
+		return append(problems, lp.newProblem(line, m[2],
+			"#+END_%s without #+BEGIN_%s", line[m[2]:m[3]], line[m[2]:m[3]]))
+	}
+
+	if lp.drawer != "" {
+		if reDrawerEnd.MatchString(line) {
+			lp.drawer = ""
+			return problems
+		}
+		return append(problems, lp.checkProperty(line)...)
+	}
+
+	if m := reDrawer.FindStringSubmatchIndex(line); m != nil &&
+		strings.EqualFold(line[m[2]:m[3]], "PROPERTIES") {
+		if !afterHeader && lp.seenContent {
+			problems = append(problems, lp.newProblem(line, m[2]-1,
+				"property drawer is not placed right after a headline"))
+		}
+		lp.drawer = "PROPERTIES"
+		lp.drawerLine = lp.line
+		lp.drawerCol = utf8.RuneCountInString(line[:m[2]-1]) + 1
+		lp.seenContent = true
+		return problems
+	}
+
+	if rePlanning.MatchString(line) {
+		lp.afterHeader = afterHeader
+	}
+	if strings.TrimSpace(line) != "" && !reComment.MatchString(line) {
+		lp.seenContent = true
+	}
+
+	return append(problems, lp.checkInline(line)...)
+}
+
+// checkProperty records `:ID:` property.
+func (lp *OrgLintParser) checkProperty(line string) []*Problem {
+	m := reProperty.FindStringSubmatchIndex(line)
+	if m == nil || !strings.EqualFold(line[m[2]:m[3]], "ID") {
+		return nil
+	}
+
+	id := line[m[4]:m[5]]
+	location := lp.newProblem(line, m[4], "")
+	if first := lp.ids.add(id, location); first != nil {
+		location.Message = fmt.Sprintf("duplicate :ID: %s, first defined at %s:%d:%d",
+			id, first.File, first.Line, first.Col)
+		return []*Problem{location}
+	}
+	return nil
+}
+
+// checkInline checks timestamps and links in line.
+func (lp *OrgLintParser) checkInline(line string) []*Problem {
+	problems := make([]*Problem, 0)
+	for _, m := range reTimestampCandidate.FindAllStringIndex(line, -1) {
+		if !isValidTimestamp(line[m[0]:m[1]]) {
+			problems = append(problems, lp.newProblem(line, m[0],
+				"malformed timestamp %s", line[m[0]:m[1]]))
+		}
+	}
+
+	for _, link := range parser.MatchLinks(line) {
+		if !localLinkTypes[link.Type] {
+			continue
+		}
+		if path := lp.resolve(link.Path); lib.IsNotExist(path) {
+			problems = append(problems, lp.newProblem(line, link.Start,
+				"link to missing file %s", link.Path))
+		}
+	}
+	return problems
+}
+
+// resolve return the path of file linked by org file.
+func (lp *OrgLintParser) resolve(path string) string {
+	if i := strings.Index(path, "::"); i >= 0 {
+		path = path[:i]
+	}
+	if expanded, err := homedir.Expand(path); err == nil {
+		path = expanded
+	}
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(filepath.Dir(lp.file), path)
+}
+
+// closeDrawer reports the drawer opened but not closed.
+func (lp *OrgLintParser) closeDrawer() []*Problem {
+	if lp.drawer == "" {
+		return nil
+	}
+
+	problem := &Problem{
+		File:    lp.file,
+		Line:    lp.drawerLine,
+		Col:     lp.drawerCol,
+		Message: fmt.Sprintf("unclosed :%s: drawer", lp.drawer),
+	}
+	lp.drawer = ""
+	return []*Problem{problem}
+}
+
+// Finish reports problems found at the end of file.
+func (lp *OrgLintParser) Finish() []*Problem {
+	problems := lp.closeDrawer()
+	if lp.block != "" {
+		problems = append(problems, &Problem{
+			File:    lp.file,
+			Line:    lp.blockLine,
+			Col:     lp.blockCol,
+			Message: fmt.Sprintf("unclosed #+BEGIN_%s", lp.block),
+		})
+		lp.block = ""
+	}
+	return problems
+}
+
+// isValidTimestamp checks the format, date and time of timestamp.
+func isValidTimestamp(timestamp string) bool {
+	m := reTimestamp.FindStringSubmatch(timestamp)
+	if m == nil {
+		return false
+	}
+
+	// brackets should be paired
+	if (timestamp[0] == '<') != (m[6] == ">") {
+		return false
+	}
+
+	if _, err := time.Parse("2006-01-02", m[1]); err != nil {
+		return false
+	}
+
+	for i := 2; i < 6; i += 2 {
+		if m[i] == "" {
+			continue
+		}
+		hour, _ := strconv.Atoi(m[i])
+		minute, _ := strconv.Atoi(m[i+1])
+		// 24:00 is the end of day
+		if hour > 24 || minute > 59 || hour == 24 && minute > 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,87 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package linter
+
+import (
+	"testing"
+
+	"github.com/MephistoMMM/magician/lib"
+)
+
+func TestOrgLintParser(t *testing.T) {
+	parser := NewOrgLintParser("test.org", nil)
+	results, err := lib.ScanLines(parser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	problems := make([]string, 0)
+	for _, result := range results {
+		for _, problem := range result.([]*Problem) {
+			problems = append(problems, problem.String())
+		}
+	}
+	for _, problem := range parser.Finish() {
+		problems = append(problems, problem.String())
+	}
+
+	expected := []string{
+		"test.org:10:1: headline level jumps from 1 to 3",
+		"test.org:11:12: malformed timestamp <2020-01-32 Mon>",
+		"test.org:13:6: duplicate :ID: 0001, first defined at test.org:8:6",
+		"test.org:17:1: property drawer is not placed right after a headline",
+		"test.org:21:31: link to missing file missing.png",
+		"test.org:26:7: #+END_EXAMPLE does not match #+BEGIN_SRC at line 24",
+		"test.org:27:1: unclosed #+BEGIN_QUOTE",
+	}
+
+	if len(problems) != len(expected) {
+		t.Fatalf("Number of problems is error, hope %d, but get %d: %v",
+			len(expected), len(problems), problems)
+	}
+	for i := range expected {
+		if problems[i] != expected[i] {
+			t.Errorf("Problem %d is error, hope '%s', but get '%s'.", i, expected[i], problems[i])
+		}
+	}
+}
+
+func TestIsValidTimestamp(t *testing.T) {
+	cases := map[string]bool{
+		"<2020-08-07 Fri>":             true,
+		"[2020-08-07]":                 true,
+		"<2020-08-07 Fri 8:00-9:30>":   true,
+		"<2020-08-07 Fri .+1d/3d>":     true,
+		"<2020-08-07 Fri -2d>":         true,
+		"<2020-02-30 Sun>":             false,
+		"<2020-08-07 Fri 25:00>":       false,
+		"<2020-08-07 Fri 24:30>":       false,
+		"<2020-08-07 Fri 23:00-24:00>": true,
+		"<2020-8-07 Fri>":              false,
+		"<2020-08-07 Fri]":             false,
+		"<2020-08-07 Fri 10:00 extra>": false,
+	}
+
+	for timestamp, valid := range cases {
+		if isValidTimestamp(timestamp) != valid {
+			t.Errorf("Timestamp %s should be valid: %v", timestamp, valid)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package parser
+
+import (
+	re "regexp"
+	"strings"
+)
+
+var (
+	reHeadlineTags   = re.MustCompile(`\s+(:[^\s:]+(?::[^\s:]+)*:)\s*$`)
+	reHeadlinePrio   = re.MustCompile(`^\[#([A-Za-z0-9])\]\s*`)
+	reTodoKeywords   = re.MustCompile(`^#\+(?i:(?:SEQ_|TYP_)?TODO):(.*)$`)
+	reFileTags       = re.MustCompile(`^#\+(?i:FILETAGS):(.*)$`)
+	rePropertyLine   = re.MustCompile(`^\s*:([^:\s]+):\s*(.*?)\s*$`)
+	reDrawerBegin    = re.MustCompile(`^\s*:(?i:PROPERTIES):\s*$`)
+	reDrawerFinished = re.MustCompile(`^\s*:(?i:END):\s*$`)
+)
+
+// DefaultTodoKeywords are TODO keywords known without `#+TODO:` line.
+var DefaultTodoKeywords = []string{"TODO", "DONE"}
+
+// OrgHeadline is a data struct describing a headline with the headers
+// above it, its TODO state, priority, tags and properties.
+type OrgHeadline struct {
+	File string
+	Line int
+	// Headers is the breadcrumb path of headline, the last one is the
+	// headline itself.
+	Headers []OrgHeader
+
+	Todo     string
+	Priority string
+	Title    string
+	// Tags are tags of headline itself, and InheritedTags are tags of
+	// file and headers above it.
+	Tags          []string
+	InheritedTags []string
+
+	Properties map[string]string
+}
+
+// AllTags return tags of headline including inherited ones.
+func (oh *OrgHeadline) AllTags() []string {
+	tags := make([]string, 0, len(oh.Tags)+len(oh.InheritedTags))
+	tags = append(tags, oh.InheritedTags...)
+	return append(tags, oh.Tags...)
+}
+
+// Breadcrumb join text of headers with " / ".
+func (oh *OrgHeadline) Breadcrumb() string {
+	texts := make([]string, len(oh.Headers))
+	for i, header := range oh.Headers {
+		texts[i] = header.Text
+	}
+	return strings.Join(texts, " / ")
+}
+
+// OrgHeadlineParser implements FileLineParser, is used to parse org file
+// to get OrgHeadline. Properties are filled into the latest returned
+// OrgHeadline while parsing its property drawer.
+type OrgHeadlineParser struct {
+	file         string
+	line         int
+	todoKeywords map[string]bool
+	fileTags     []string
+
+	curHeadlines []*OrgHeadline
+	inDrawer     bool
+}
+
+// NewOrgHeadlineParser create a new OrgHeadlineParser
+func NewOrgHeadlineParser(file string) *OrgHeadlineParser {
+	todoKeywords := make(map[string]bool)
+	for _, keyword := range DefaultTodoKeywords {
+		todoKeywords[keyword] = true
+	}
+
+	return &OrgHeadlineParser{
+		file:         file,
+		todoKeywords: todoKeywords,
+		fileTags:     make([]string, 0),
+		curHeadlines: make([]*OrgHeadline, 0, 4),
+	}
+}
+
+// FilePath return the path of file to be parsed
+func (hp *OrgHeadlineParser) FilePath() string {
+	return hp.file
+}
+
+// latest return the latest parsed headline or nil.
+func (hp *OrgHeadlineParser) latest() *OrgHeadline {
+	if len(hp.curHeadlines) == 0 {
+		return nil
+	}
+	return hp.curHeadlines[len(hp.curHeadlines)-1]
+}
+
+// Parse parse org file, if line is a headline, Parse return a OrgHeadline
+// with headers above it.
+func (hp *OrgHeadlineParser) Parse(line string) (interface{}, error) {
+	hp.line++
+
+	if header, ok := MatchHeader(line); ok {
+		hp.inDrawer = false
+		for latest := hp.latest(); latest != nil &&
+			latest.Headers[len(latest.Headers)-1].Level >= header.Level; latest = hp.latest() {
+			hp.curHeadlines = hp.curHeadlines[:len(hp.curHeadlines)-1]
+		}
+
+		headline := hp.newHeadline(header)
+		hp.curHeadlines = append(hp.curHeadlines, headline)
+		return headline, nil
+	}
+
+	if hp.inDrawer {
+		if reDrawerFinished.MatchString(line) {
+			hp.inDrawer = false
+		} else if m := rePropertyLine.FindStringSubmatch(line); m != nil {
+			hp.latest().Properties[strings.ToUpper(m[1])] = m[2]
+		}
+		return nil, nil
+	}
+
+	if keywords, ok := TodoKeywords(line); ok {
+		for _, keyword := range keywords {
+			hp.todoKeywords[keyword] = true
+		}
+	} else if m := reFileTags.FindStringSubmatch(line); m != nil {
+		hp.fileTags = append(hp.fileTags, splitTags(strings.TrimSpace(m[1]))...)
+	} else if hp.latest() != nil && reDrawerBegin.MatchString(line) {
+		hp.inDrawer = true
+	}
+	return nil, nil
+}
+
+// newHeadline create a OrgHeadline from header under current headlines.
+func (hp *OrgHeadlineParser) newHeadline(header OrgHeader) *OrgHeadline {
+	headers := make([]OrgHeader, 0, len(hp.curHeadlines)+1)
+	inherited := make([]string, 0)
+	inherited = append(inherited, hp.fileTags...)
+	for _, parent := range hp.curHeadlines {
+		headers = append(headers, parent.Headers[len(parent.Headers)-1])
+		inherited = append(inherited, parent.Tags...)
+	}
+	headers = append(headers, header)
+
+	headline := &OrgHeadline{
+		File:          hp.file,
+		Line:          hp.line,
+		Headers:       headers,
+		InheritedTags: inherited,
+		Properties:    make(map[string]string),
+	}
+
+	headline.Todo, headline.Priority, headline.Title, headline.Tags =
+		SplitHeadline(header.Text, hp.todoKeywords)
+	return headline
+}
+
+// TodoKeywords return keywords declared by line if it is a `#+TODO:` line.
+func TodoKeywords(line string) ([]string, bool) {
+	m := reTodoKeywords.FindStringSubmatch(line)
+	if m == nil {
+		return nil, false
+	}
+
+	keywords := make([]string, 0)
+	for _, keyword := range strings.Fields(m[1]) {
+		if keyword == "|" {
+			continue
+		}
+		// drop fast access key and logging settings, like `WAIT(w@/!)`
+		if i := strings.Index(keyword, "("); i > 0 {
+			keyword = keyword[:i]
+		}
+		keywords = append(keywords, keyword)
+	}
+	return keywords, true
+}
+
+// SplitHeadline split text of a headline to its TODO keyword, one of
+// todoKeywords, priority, title and tags.
+func SplitHeadline(text string, todoKeywords map[string]bool) (todo, priority, title string, tags []string) {
+	tags = make([]string, 0)
+	text = strings.TrimSpace(text)
+	if i := strings.IndexAny(text, " \t"); i > 0 && todoKeywords[text[:i]] {
+		todo = text[:i]
+		text = strings.TrimSpace(text[i:])
+	} else if todoKeywords[text] {
+		todo = text
+		text = ""
+	}
+
+	if m := reHeadlinePrio.FindStringSubmatch(text); m != nil {
+		priority = m[1]
+		text = text[len(m[0]):]
+	}
+
+	if m := reHeadlineTags.FindStringSubmatchIndex(text); m != nil {
+		tags = splitTags(text[m[2]:m[3]])
+		text = text[:m[0]]
+	}
+
+	return todo, priority, strings.TrimSpace(text), tags
+}
+
+// splitTags split `:a:b:` to tags.
+func splitTags(tags string) []string {
+	result := make([]string, 0)
+	for _, tag := range strings.Split(tags, ":") {
+		if tag != "" {
+			result = append(result, tag)
+		}
+	}
+	return result
+}
@@ -0,0 +1,61 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package parser
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/MephistoMMM/magician/lib"
+)
+
+func TestOrgHeadlineParser(t *testing.T) {
+	results, err := lib.ScanLines(NewOrgHeadlineParser("./headline.org"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count := len(results); count != 6 {
+		t.Fatalf("Number of headlines is error, hope 6, but get %d.\n", count)
+	}
+
+	headline := results[1].(*OrgHeadline)
+	expected := &OrgHeadline{
+		File: "./headline.org",
+		Line: 5,
+		Headers: []OrgHeader{
+			{Stars: "*", Level: 1, Text: "Projects :work:"},
+			{Stars: "**", Level: 2, Text: "NEXT [#A] Write report :boss:"},
+		},
+		Todo:          "NEXT",
+		Priority:      "A",
+		Title:         "Write report",
+		Tags:          []string{"boss"},
+		InheritedTags: []string{"notes", "work"},
+		Properties:    map[string]string{"EFFORT": "2", "OWNER": "mephis"},
+	}
+	if !reflect.DeepEqual(headline, expected) {
+		t.Errorf("Headline is error, hope %+v, but get %+v.", expected, headline)
+	}
+
+	if todo := results[4].(*OrgHeadline).Todo; todo != "" {
+		t.Errorf("Headline Home should have no TODO state, but get %s.", todo)
+	}
+}
@@ -26,9 +26,50 @@ import (
 
 var (
 	reHeader = re.MustCompile(`^(?P<Level>\*+)\s`)
-	reLink   = re.MustCompile(`\[\[(?P<Type>\w+):(?P<Path>.+?)\]\]`)
+	reLink   = re.MustCompile(`\[\[(?P<Type>\w+):(?P<Path>[^\]]+)\](?:\[(?P<Desc>[^\]]*)\])?\]`)
 )
 
+// LinkMatch describes a link found in a single line. Path is the raw
+// path written in org file, and Start is the byte offset of the link.
+type LinkMatch struct {
+	Link  string
+	Type  string
+	Path  string
+	Desc  string
+	Start int
+}
+
+// MatchHeader parses line as a headline, ok is false if line is not
+// a headline.
+func MatchHeader(line string) (header OrgHeader, ok bool) {
+	indexes := reHeader.FindStringSubmatchIndex(line)
+	if indexes == nil {
+		return OrgHeader{}, false
+	}
+
+	stars := reHeader.ExpandString([]byte{}, "$Level", line, indexes)
+	return OrgHeader{
+		Stars: string(stars),
+		Level: len(stars),
+		Text:  line[len(stars)+1:],
+	}, true
+}
+
+// MatchLinks finds all links in line.
+func MatchLinks(line string) []LinkMatch {
+	matches := make([]LinkMatch, 0)
+	for _, indexes := range reLink.FindAllStringSubmatchIndex(line, -1) {
+		matches = append(matches, LinkMatch{
+			Link:  line[indexes[0]:indexes[1]],
+			Type:  string(reLink.ExpandString([]byte{}, "$Type", line, indexes)),
+			Path:  string(reLink.ExpandString([]byte{}, "$Path", line, indexes)),
+			Desc:  string(reLink.ExpandString([]byte{}, "$Desc", line, indexes)),
+			Start: indexes[0],
+		})
+	}
+	return matches
+}
+
 type OrgHeader struct {
 	Stars string
 	Level int
@@ -108,15 +149,7 @@ func (fp *OrgLinkParser) FilePath() string {
 // OrgLink with file name and headers above link.
 func (fp *OrgLinkParser) Parse(line string) (interface{}, error) {
 	// first match stars at the beginning of line
-	if reHeader.MatchString(line) {
-		stars := reHeader.ExpandString([]byte{}, "$Level", line,
-			reHeader.FindStringSubmatchIndex(line))
-		curHeader := OrgHeader{
-			Stars: string(stars),
-			Level: len(stars),
-			Text:  line[len(stars)+1:],
-		}
-
+	if curHeader, ok := MatchHeader(line); ok {
 		latest := fp.getHeader()
 		for latest.Level >= curHeader.Level {
 			fp.popHeader()
@@ -129,20 +162,16 @@ func (fp *OrgLinkParser) Parse(line string) (interface{}, error) {
 	}
 
 	// second match link element
-	if reLink.MatchString(line) {
+	if links := MatchLinks(line); len(links) > 0 {
 		// TODO find all links in single line
-		link := reLink.FindString(line)
-		indexes := reLink.FindStringSubmatchIndex(line)
-		typ := reLink.ExpandString([]byte{}, "$Type", line, indexes)
-		path := string(reLink.ExpandString(
-			[]byte{}, "$Path", line, indexes))
-		path = filepath.Join(filepath.Dir(fp.file), path)
+		link := links[0]
+		path := filepath.Join(filepath.Dir(fp.file), link.Path)
 
 		return &OrgLink{
 			File:    fp.FilePath(),
 			Headers: fp.cloneHeader(),
-			Link:    link,
-			Type:    string(typ),
+			Link:    link.Link,
+			Type:    link.Type,
 			Path:    path,
 		}, nil
 	}
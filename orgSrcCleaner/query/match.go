@@ -0,0 +1,398 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package query
+
+import (
+	"fmt"
+	re "regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/MephistoMMM/magician/orgSrcCleaner/parser"
+)
+
+// Match string follows the syntax of org-mode tags/property matches. A match
+// string is made up of groups separated by `|`, a headline is matched if
+// any group matches. A group is made up of terms prefixed by `+` (or `&`) to
+// require them and `-` to exclude them. A term is one of:
+//
+//   tag          headline has the tag, inherited tags included
+//   {regexp}     headline has a tag matched by regexp
+//   PROP="str"   property compared with string, ops are = <> < <= > >=
+//   PROP={re}    property matched (or not matched with <>) by regexp
+//   PROP=3       property compared as number
+//
+// TODO, LEVEL, PRIORITY and ITEM are special properties of headline. A part
+// after `/` matches TODO state, like `work/NEXT|WAIT` or `+work/-DONE`.
+
+// Matcher checks if a headline is matched.
+type Matcher func(headline *parser.OrgHeadline) bool
+
+// All return a Matcher matching headline matched by all matchers.
+func All(matchers ...Matcher) Matcher {
+	return func(headline *parser.OrgHeadline) bool {
+		for _, m := range matchers {
+			if !m(headline) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Any return a Matcher matching headline matched by any matcher.
+func Any(matchers ...Matcher) Matcher {
+	return func(headline *parser.OrgHeadline) bool {
+		for _, m := range matchers {
+			if m(headline) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Not return a Matcher matching headline not matched by m.
+func Not(m Matcher) Matcher {
+	return func(headline *parser.OrgHeadline) bool {
+		return !m(headline)
+	}
+}
+
+// MatchTag return a Matcher matching headline with tag.
+func MatchTag(tag string) Matcher {
+	return func(headline *parser.OrgHeadline) bool {
+		for _, t := range headline.AllTags() {
+			if t == tag {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// MatchTodo return a Matcher matching headline in any of states.
+func MatchTodo(states ...string) Matcher {
+	return func(headline *parser.OrgHeadline) bool {
+		for _, state := range states {
+			if headline.Todo == state {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// MatchTitle return a Matcher matching headline whose title is matched
+// by pattern.
+func MatchTitle(pattern *re.Regexp) Matcher {
+	return func(headline *parser.OrgHeadline) bool {
+		return pattern.MatchString(headline.Title)
+	}
+}
+
+// MatchProperty return a Matcher matching headline with property key
+// equal to value.
+func MatchProperty(key, value string) Matcher {
+	key = strings.ToUpper(key)
+	return func(headline *parser.OrgHeadline) bool {
+		v, ok := property(headline, key)
+		return ok && v == value
+	}
+}
+
+// property return the value of property key, special properties included.
+func property(headline *parser.OrgHeadline, key string) (string, bool) {
+	switch key {
+	case "TODO":
+		return headline.Todo, true
+	case "LEVEL":
+		return strconv.Itoa(headline.Headers[len(headline.Headers)-1].Level), true
+	case "PRIORITY":
+		return headline.Priority, true
+	case "ITEM":
+		return headline.Title, true
+	}
+
+	v, ok := headline.Properties[key]
+	return v, ok
+}
+
+// Compile parse match string to a Matcher.
+func Compile(match string) (Matcher, error) {
+	tags, todo := match, ""
+	if i := todoSeparator(match); i >= 0 {
+		tags, todo = match[:i], match[i+1:]
+	}
+
+	matchers := make([]Matcher, 0, 2)
+	if strings.TrimSpace(tags) != "" {
+		m, err := (&matchParser{input: tags}).parse()
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+	if strings.TrimSpace(todo) != "" {
+		m, err := (&matchParser{input: todo, todo: true}).parse()
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+	return All(matchers...), nil
+}
+
+// todoSeparator return the index of the first `/` of s outside of
+// `{regexp}`s and strings, or -1 if there is none.
+func todoSeparator(s string) int {
+	braces, quoted := false, false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '"' && !braces:
+			quoted = !quoted
+		case s[i] == '{' && !quoted:
+			braces = true
+		case s[i] == '}' && !quoted:
+			braces = false
+		case s[i] == '/' && !braces && !quoted:
+			return i
+		}
+	}
+	return -1
+}
+
+// matchParser parses a part of match string. In todo mode words match
+// TODO state instead of tags.
+type matchParser struct {
+	input string
+	pos   int
+	todo  bool
+}
+
+func (mp *matchParser) errorf(format string, a ...interface{}) error {
+	return fmt.Errorf("invalid match %q at %d: %s", mp.input, mp.pos, fmt.Sprintf(format, a...))
+}
+
+func (mp *matchParser) peek() byte {
+	if mp.pos >= len(mp.input) {
+		return 0
+	}
+	return mp.input[mp.pos]
+}
+
+func (mp *matchParser) skipSpaces() {
+	for mp.pos < len(mp.input) && mp.input[mp.pos] == ' ' {
+		mp.pos++
+	}
+}
+
+func (mp *matchParser) parse() (Matcher, error) {
+	groups := make([]Matcher, 0, 1)
+	for {
+		group, err := mp.parseGroup()
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+
+		if mp.peek() != '|' {
+			break
+		}
+		mp.pos++
+	}
+
+	if mp.pos < len(mp.input) {
+		return nil, mp.errorf("unexpected %q", mp.input[mp.pos])
+	}
+	return Any(groups...), nil
+}
+
+func (mp *matchParser) parseGroup() (Matcher, error) {
+	terms := make([]Matcher, 0)
+	for {
+		mp.skipSpaces()
+		exclude := false
+		switch mp.peek() {
+		case 0, '|':
+			if len(terms) == 0 {
+				return nil, mp.errorf("empty group")
+			}
+			return All(terms...), nil
+		case '+', '&':
+			mp.pos++
+		case '-':
+			exclude = true
+			mp.pos++
+		}
+
+		term, err := mp.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		if exclude {
+			term = Not(term)
+		}
+		terms = append(terms, term)
+	}
+}
+
+func (mp *matchParser) parseTerm() (Matcher, error) {
+	if mp.peek() == '{' {
+		pattern, err := mp.parseRegexp()
+		if err != nil {
+			return nil, err
+		}
+		return func(headline *parser.OrgHeadline) bool {
+			if mp.todo {
+				return pattern.MatchString(headline.Todo)
+			}
+			for _, tag := range headline.AllTags() {
+				if pattern.MatchString(tag) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	}
+
+	word := mp.parseWord()
+	if word == "" {
+		return nil, mp.errorf("expect a tag or property")
+	}
+
+	if mp.todo {
+		return MatchTodo(word), nil
+	}
+	if strings.IndexByte("=<>", mp.peek()) < 0 {
+		return MatchTag(word), nil
+	}
+	return mp.parseComparison(strings.ToUpper(word))
+}
+
+func (mp *matchParser) parseWord() string {
+	start := mp.pos
+	for _, r := range mp.input[mp.pos:] {
+		if !(unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("_@#%", r)) {
+			break
+		}
+		mp.pos += len(string(r))
+	}
+	return mp.input[start:mp.pos]
+}
+
+func (mp *matchParser) parseRegexp() (*re.Regexp, error) {
+	end := strings.IndexByte(mp.input[mp.pos:], '}')
+	if end < 0 {
+		return nil, mp.errorf("unclosed {")
+	}
+	pattern, err := re.Compile(mp.input[mp.pos+1 : mp.pos+end])
+	if err != nil {
+		return nil, mp.errorf("%s", err)
+	}
+	mp.pos += end + 1
+	return pattern, nil
+}
+
+func (mp *matchParser) parseComparison(key string) (Matcher, error) {
+	op := ""
+	for _, candidate := range []string{"<>", "<=", ">=", "=", "<", ">"} {
+		if strings.HasPrefix(mp.input[mp.pos:], candidate) {
+			op = candidate
+			break
+		}
+	}
+	mp.pos += len(op)
+
+	switch mp.peek() {
+	case '{':
+		if op != "=" && op != "<>" {
+			return nil, mp.errorf("regexp only supports = and <>")
+		}
+		pattern, err := mp.parseRegexp()
+		if err != nil {
+			return nil, err
+		}
+		return func(headline *parser.OrgHeadline) bool {
+			v, _ := property(headline, key)
+			return pattern.MatchString(v) == (op == "=")
+		}, nil
+	case '"':
+		end := strings.IndexByte(mp.input[mp.pos+1:], '"')
+		if end < 0 {
+			return nil, mp.errorf("unclosed \"")
+		}
+		value := mp.input[mp.pos+1 : mp.pos+1+end]
+		mp.pos += end + 2
+		return func(headline *parser.OrgHeadline) bool {
+			v, _ := property(headline, key)
+			return compare(strings.Compare(v, value), op)
+		}, nil
+	}
+
+	start := mp.pos
+	if c := mp.peek(); c == '+' || c == '-' {
+		mp.pos++
+	}
+	for mp.pos < len(mp.input) && strings.IndexByte("0123456789.", mp.input[mp.pos]) >= 0 {
+		mp.pos++
+	}
+	value, err := strconv.ParseFloat(mp.input[start:mp.pos], 64)
+	if err != nil {
+		return nil, mp.errorf("expect a string, regexp or number")
+	}
+	return func(headline *parser.OrgHeadline) bool {
+		v, ok := property(headline, key)
+		if !ok {
+			return false
+		}
+		number, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return false
+		}
+		switch {
+		case number < value:
+			return compare(-1, op)
+		case number > value:
+			return compare(1, op)
+		}
+		return compare(0, op)
+	}, nil
+}
+
+// compare checks result of comparison satisfies op.
+func compare(result int, op string) bool {
+	switch op {
+	case "=":
+		return result == 0
+	case "<>":
+		return result != 0
+	case "<":
+		return result < 0
+	case "<=":
+		return result <= 0
+	case ">":
+		return result > 0
+	case ">=":
+		return result >= 0
+	}
+	return false
+}
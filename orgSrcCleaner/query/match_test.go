@@ -0,0 +1,96 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package query
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/MephistoMMM/magician/lib"
+	"github.com/MephistoMMM/magician/orgSrcCleaner/parser"
+)
+
+func loadHeadlines(t *testing.T) []*parser.OrgHeadline {
+	results, err := lib.ScanLines(parser.NewOrgHeadlineParser("./test.org"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	headlines := make([]*parser.OrgHeadline, len(results))
+	for i, result := range results {
+		headlines[i] = result.(*parser.OrgHeadline)
+	}
+	return headlines
+}
+
+func TestCompile(t *testing.T) {
+	headlines := loadHeadlines(t)
+	cases := map[string]string{
+		"+work-boss":             "Projects,Send mail,Review",
+		"work&urgent|errand":     "Review,Buy milk",
+		"notes/NEXT|TODO":        "Write report,Buy milk",
+		"{^[^/]+$}/NEXT|TODO":    "Write report,Buy milk",
+		`OWNER<>"a/b"/-DONE`:     "Projects,Write report,Review,Home,Buy milk",
+		"+work/-DONE":            "Projects,Write report,Review",
+		"EFFORT>1":               "Write report",
+		"EFFORT<=1+errand":       "Buy milk",
+		`OWNER="mephis"`:         "Write report",
+		"OWNER={^me}":            "Write report",
+		`TODO="WAIT"`:            "Review",
+		"LEVEL=1":                "Projects,Home",
+		"PRIORITY=\"A\"":         "Write report",
+		"{^er}":                  "Buy milk",
+		"ITEM<>{o}-work":         "Buy milk",
+		"-work-notes|TODO<>\"\"": "Write report,Send mail,Review,Buy milk",
+	}
+
+	for match, expected := range cases {
+		matcher, err := Compile(match)
+		if err != nil {
+			t.Errorf("Compile %s error: %s", match, err)
+			continue
+		}
+
+		titles := make([]string, 0)
+		for _, headline := range headlines {
+			if matcher(headline) {
+				titles = append(titles, headline.Title)
+			}
+		}
+		if result := strings.Join(titles, ","); result != expected {
+			t.Errorf("Match %s should get '%s', but get '%s'.", match, expected, result)
+		}
+	}
+}
+
+func TestCompileError(t *testing.T) {
+	for _, match := range []string{"+", "work|", "{unclosed", "EFFORT>abc", "A<{x}"} {
+		if _, err := Compile(match); err == nil {
+			t.Errorf("Compile %s should fail.", match)
+		}
+	}
+}
+
+func TestBreadcrumb(t *testing.T) {
+	headlines := loadHeadlines(t)
+	if crumb := headlines[1].Breadcrumb(); crumb != "Projects :work: / NEXT [#A] Write report :boss:" {
+		t.Errorf("Breadcrumb is error: %s", crumb)
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/MephistoMMM/magician/lib"
+	"github.com/MephistoMMM/magician/snapshot/manifest"
+	"github.com/spf13/cobra"
+)
+
+// exit codes of diff, like diff(1)
+const (
+	diffExitChanged = 1
+	diffExitError   = 2
+)
+
+// diffCmd represents the diff command
+var diffCmd = &cobra.Command{
+	Use:   "diff <old> <new>",
+	Short: "Diff two manifests, or a manifest against a live tree.",
+	Long: `diff compares old and new, each of them is a manifest file or a
+directory walked now, and prints changes from old to new one per line:
+
+    A path            added
+    D path            removed
+    M path            content or mode modified
+    R old -> new      renamed, found by hash
+
+A directory diffed against a manifest file is walked with the filters
+recorded in the manifest if no filter is given, and a warning is printed if
+the two sides are walked with different filters.
+
+diff exits with 0 if nothing is changed, 1 if anything is changed and 2 if
+it fails to run.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if err := cobra.ExactArgs(2)(cmd, args); err != nil {
+			return err
+		}
+
+		for _, src := range args {
+			if lib.IsNotExist(src) {
+				return fmt.Errorf("src is not exist: %s", src)
+			}
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := interruptContext()
+		defer cancel()
+
+		// manifest files are loaded first, so that their filters could be
+		// reused by directories
+		manifests := make([]*manifest.Manifest, len(args))
+		var like *manifest.Manifest
+		for i, src := range args {
+			if lib.IsDir(src) {
+				continue
+			}
+			m, err := manifest.Load(src)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				diffExit(diffExitError)
+			}
+			manifests[i], like = m, m
+		}
+		for i, src := range args {
+			if manifests[i] != nil {
+				continue
+			}
+			m, err := buildManifest(ctx, src, like)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				diffExit(diffExitError)
+			}
+			manifests[i] = m
+		}
+
+		before, after := manifests[0], manifests[1]
+		if before.Filter != after.Filter || before.Prune != after.Prune {
+			log.Warnf("%s is walked with %s but %s is walked with %s, changes may be caused by filters",
+				args[0], describeFilters(before), args[1], describeFilters(after))
+		}
+
+		changes := manifest.Diff(before, after)
+		for _, change := range changes {
+			fmt.Println(change)
+		}
+		if len(changes) > 0 {
+			diffExit(diffExitChanged)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+}
+
+// diffExit reports filters and exits with code.
+func diffExit(code int) {
+	reportFilters()
+	os.Exit(code)
+}
+
+// describeFilters return the filters m is walked with.
+func describeFilters(m *manifest.Manifest) string {
+	if m.Filter == "" && m.Prune == "" {
+		return "no filter"
+	}
+	parts := make([]string, 0, 2)
+	if m.Filter != "" {
+		parts = append(parts, "filter "+m.Filter)
+	}
+	if m.Prune != "" {
+		parts = append(parts, "prune "+m.Prune)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,217 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+
+	"github.com/MephistoMMM/magician/lib"
+	"github.com/MephistoMMM/magician/snapshot/manifest"
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var cfgFile string
+
+// explainFilters is the value of `--explain-filters`, noExplainedPath if no
+// path is given.
+var explainFilters string
+
+const noExplainedPath = "-"
+
+var (
+	filterTracer *lib.FilterTracer
+	walkErrors   = lib.NewErrorReport()
+	reportOnce   sync.Once
+)
+
+var log = lib.Logger
+
+// rootCmd represents the base command when called without any subcommands
+var rootCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Write manifests of directory trees and diff them.",
+	Long: `snapshot writes a manifest of a directory tree, recording path, size,
+mode, mtime and hash of every file, and diffs two manifests or a manifest
+against a live tree. It is used to compare a tree before and after cleanups
+and syncs.
+
+Besides --filter, filters of files recorded and of directories pruned can
+be declared in config like:
+
+	prune:
+	  - type: dot
+	filters:
+	  - type: expr
+	    args: ['not path("**/.cache/**")']
+
+Filters are recorded in manifests, and reused when a live tree is diffed
+against a manifest without filters given.`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if explainFilters == "" {
+			return nil
+		}
+
+		filterTracer = lib.NewFilterTracer(5)
+		if explainFilters != noExplainedPath {
+			if err := filterTracer.Explain(explainFilters); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	PersistentPostRun: func(cmd *cobra.Command, args []string) {
+		reportFilters()
+	},
+}
+
+// Execute adds all child commands to the root command and sets flags appropriately.
+// This is called by main.main(). It only needs to happen once to the rootCmd.
+func Execute() {
+	if err := rootCmd.Execute(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+func init() {
+	cobra.OnInitialize(initConfig)
+
+	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.snapshot.yaml)")
+	rootCmd.PersistentFlags().String("filter", "", `filter expression of files recorded, like 'not path("**/.git/**")'`)
+	viper.BindPFlag("filter", rootCmd.PersistentFlags().Lookup("filter"))
+	rootCmd.PersistentFlags().StringVar(&explainFilters, "explain-filters", "",
+		"print statistics of filters, and which filter decided for the given path")
+	rootCmd.PersistentFlags().Lookup("explain-filters").NoOptDefVal = noExplainedPath
+	rootCmd.PersistentFlags().String("on-error", lib.ErrorSkipCollect.String(),
+		"what to do when a path fails to be read: collect, log or abort")
+	viper.BindPFlag("on-error", rootCmd.PersistentFlags().Lookup("on-error"))
+	rootCmd.PersistentFlags().Int("workers", 1, "number of directories read and files hashed concurrently")
+	viper.BindPFlag("workers", rootCmd.PersistentFlags().Lookup("workers"))
+}
+
+// initConfig reads in config file and ENV variables if set.
+func initConfig() {
+	if cfgFile != "" {
+		viper.SetConfigFile(cfgFile)
+	} else {
+		home, err := homedir.Dir()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		// Search config in home directory with name ".snapshot" (without extension).
+		viper.AddConfigPath(home)
+		viper.SetConfigName(".snapshot")
+	}
+
+	viper.AutomaticEnv()
+
+	// manifests and changes are written to stdout
+	if err := viper.ReadInConfig(); err == nil {
+		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
+	}
+}
+
+// reportFilters writes errors skipped during walks, statistics of filters,
+// and decisions for the path given by `--explain-filters`, to stderr once.
+func reportFilters() {
+	reportOnce.Do(func() {
+		walkErrors.WriteTo(os.Stderr)
+		if filterTracer != nil {
+			filterTracer.WriteStats(os.Stderr)
+			filterTracer.WriteExplain(os.Stderr)
+		}
+	})
+}
+
+// interruptContext return a context cancelled on the first interrupt.
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		select {
+		case <-interrupts:
+			log.Warnln("interrupted, stopping walk")
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(interrupts)
+	}()
+	return ctx, cancel
+}
+
+// iteratorOptions return options of iterating files under root kept by
+// filters declared in config and the filter expression of `--filter`.
+func iteratorOptions(root string) (lib.IteratorOptions, error) {
+	policy, err := lib.ParseErrorPolicy(viper.GetString("on-error"))
+	if err != nil {
+		return lib.IteratorOptions{}, err
+	}
+	opts := lib.IteratorOptions{
+		ErrorPolicy: policy,
+		Report:      walkErrors,
+		Workers:     viper.GetInt("workers"),
+		Tracer:      filterTracer,
+	}
+
+	specs := make([]lib.FilterSpec, 0)
+	if err := viper.UnmarshalKey("filters", &specs); err != nil {
+		return opts, err
+	}
+	if expr := viper.GetString("filter"); expr != "" {
+		specs = append(specs, lib.FilterSpec{Type: "expr", Args: []string{expr}})
+	}
+	if opts.Filter, err = lib.NewFilterChain(root, specs); err != nil {
+		return opts, err
+	}
+
+	pruneSpecs := make([]lib.FilterSpec, 0)
+	if err := viper.UnmarshalKey("prune", &pruneSpecs); err != nil {
+		return opts, err
+	}
+	opts.Prune, err = lib.NewFilterChain(root, pruneSpecs)
+	return opts, err
+}
+
+// buildManifest return manifest of files under root kept by filters. If no
+// filter is given and like is not nil, the filters of like are reused.
+func buildManifest(ctx context.Context, root string, like *manifest.Manifest) (*manifest.Manifest, error) {
+	opts, err := iteratorOptions(root)
+	if err != nil {
+		return nil, err
+	}
+	if like != nil && opts.Filter == nil && opts.Prune == nil {
+		if opts.Filter, opts.Prune, err = like.Filters(root); err != nil {
+			return nil, err
+		}
+		if opts.Filter != nil || opts.Prune != nil {
+			log.Infof("walk %s with filters of manifest of %s", root, like.Root)
+		}
+	}
+	return manifest.Build(ctx, root, manifest.Options{IteratorOptions: opts, Workers: opts.Workers})
+}
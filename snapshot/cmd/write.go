@@ -0,0 +1,71 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/MephistoMMM/magician/lib"
+	"github.com/spf13/cobra"
+)
+
+var outputPath string
+
+// writeCmd represents the write command
+var writeCmd = &cobra.Command{
+	Use:   "write <directory>",
+	Short: "Write the manifest of a directory tree.",
+	Long: `write walks files under directory and writes their manifest as json,
+to stdout or to the file given by --output.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if err := cobra.ExactArgs(1)(cmd, args); err != nil {
+			return err
+		}
+
+		if !lib.IsDir(args[0]) {
+			return fmt.Errorf("directory is not exist or not a directory: %s", args[0])
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := interruptContext()
+		defer cancel()
+
+		m, err := buildManifest(ctx, args[0], nil)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if outputPath == "" {
+			err = m.Encode(os.Stdout)
+		} else {
+			err = m.Save(outputPath)
+		}
+		if err != nil {
+			log.Fatalln(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(writeCmd)
+	writeCmd.Flags().StringVarP(&outputPath, "output", "o", "", "file the manifest is written to (default is stdout)")
+}
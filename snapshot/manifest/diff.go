@@ -0,0 +1,166 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package manifest
+
+import (
+	"fmt"
+	"path"
+	"sort"
+)
+
+// ChangeKind is the kind of Change.
+type ChangeKind int
+
+const (
+	// Added is a file only in the new manifest.
+	Added ChangeKind = iota
+	// Removed is a file only in the old manifest.
+	Removed
+	// Modified is a file whose content or mode is changed.
+	Modified
+	// Renamed is a file moved to another path with the same content.
+	Renamed
+)
+
+var changeKindNames = map[ChangeKind]string{
+	Added:    "A",
+	Removed:  "D",
+	Modified: "M",
+	Renamed:  "R",
+}
+
+// String return the letter of kind used by git status.
+func (ck ChangeKind) String() string {
+	return changeKindNames[ck]
+}
+
+// Change is a difference between two manifests.
+type Change struct {
+	Kind ChangeKind
+	// Path is the path in the new manifest, or the old one if the file is
+	// removed.
+	Path string
+	// Old and New are entries in the old and new manifest, Old is nil if
+	// the file is added and New is nil if the file is removed.
+	Old, New *Entry
+}
+
+func (c Change) String() string {
+	if c.Kind == Renamed {
+		return fmt.Sprintf("%s %s -> %s", c.Kind, c.Old.Path, c.New.Path)
+	}
+	return fmt.Sprintf("%s %s", c.Kind, c.Path)
+}
+
+// Diff return changes from manifest old to new ordered by path. Files
+// removed and added with the same hash are paired as renames, files with
+// the same name are paired first.
+func Diff(old, new *Manifest) []Change {
+	olds := make(map[string]*Entry, len(old.Entries))
+	for _, entry := range old.Entries {
+		olds[entry.Path] = entry
+	}
+
+	changes := make([]Change, 0)
+	added := make(map[string][]*Entry)
+	for _, entry := range new.Entries {
+		prev, ok := olds[entry.Path]
+		if !ok {
+			added[entry.Hash] = append(added[entry.Hash], entry)
+			continue
+		}
+		delete(olds, entry.Path)
+		if prev.Hash != entry.Hash || prev.Mode != entry.Mode {
+			changes = append(changes, Change{Kind: Modified, Path: entry.Path, Old: prev, New: entry})
+		}
+	}
+	removed := make(map[string][]*Entry)
+	for _, entry := range olds {
+		removed[entry.Hash] = append(removed[entry.Hash], entry)
+	}
+
+	for hash, entries := range added {
+		pairs, rest := pairRenames(removed[hash], entries)
+		for _, pair := range pairs {
+			if pair[0] == nil {
+				changes = append(changes, Change{Kind: Added, Path: pair[1].Path, New: pair[1]})
+			} else {
+				changes = append(changes, Change{Kind: Renamed, Path: pair[1].Path, Old: pair[0], New: pair[1]})
+			}
+		}
+		removed[hash] = rest
+	}
+	for _, entries := range removed {
+		for _, entry := range entries {
+			changes = append(changes, Change{Kind: Removed, Path: entry.Path, Old: entry})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Path != changes[j].Path {
+			return changes[i].Path < changes[j].Path
+		}
+		return changes[i].Kind < changes[j].Kind
+	})
+	return changes
+}
+
+// pairRenames pairs removed entries with added entries of the same hash,
+// entries with the same base name are paired first and the others in
+// order of path. Added entries not paired are returned with nil old
+// entry, and removed entries not paired are returned as rest.
+func pairRenames(removed, added []*Entry) (pairs [][2]*Entry, rest []*Entry) {
+	byPath := func(entries []*Entry) {
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].Path < entries[j].Path
+		})
+	}
+	byPath(removed)
+	byPath(added)
+
+	used := make([]bool, len(removed))
+	pairs = make([][2]*Entry, len(added))
+	for i, entry := range added {
+		pairs[i][1] = entry
+		for j, old := range removed {
+			if !used[j] && path.Base(old.Path) == path.Base(entry.Path) {
+				pairs[i][0], used[j] = old, true
+				break
+			}
+		}
+	}
+	for i := range pairs {
+		if pairs[i][0] != nil {
+			continue
+		}
+		for j, old := range removed {
+			if !used[j] {
+				pairs[i][0], used[j] = old, true
+				break
+			}
+		}
+	}
+	for j, old := range removed {
+		if !used[j] {
+			rest = append(rest, old)
+		}
+	}
+	return pairs, rest
+}
@@ -0,0 +1,227 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package manifest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/MephistoMMM/magician/lib"
+	"github.com/MephistoMMM/magician/lib/concurrent"
+)
+
+// manifestVersion is the version of manifest file format.
+const manifestVersion = 1
+
+// Entry is the state of a file recorded in Manifest.
+type Entry struct {
+	// Path is slash separated and relative to root of manifest.
+	Path  string      `json:"path"`
+	Size  int64       `json:"size"`
+	Mode  os.FileMode `json:"mode"`
+	MTime time.Time   `json:"mtime"`
+	// Hash is the hex encoded sha256 of content.
+	Hash string `json:"hash"`
+}
+
+// Manifest is a snapshot of files under a root, entries are ordered by
+// path.
+type Manifest struct {
+	Version int       `json:"version"`
+	Root    string    `json:"root"`
+	Created time.Time `json:"created"`
+	// Filter and Prune are expressions of the filters files are walked
+	// with, they are empty if files are not filtered.
+	Filter  string   `json:"filter,omitempty"`
+	Prune   string   `json:"prune,omitempty"`
+	Entries []*Entry `json:"entries"`
+}
+
+// Options are options of building manifests.
+type Options struct {
+	lib.IteratorOptions
+	// Workers is the number of files hashed concurrently, 1 if it is not
+	// positive.
+	Workers int
+}
+
+// filterExpression return the expression of filter, or "" if it is nil.
+func filterExpression(filter lib.FilterSupport) string {
+	if filter == nil {
+		return ""
+	}
+	return filter.String()
+}
+
+// Filters compiles Filter and Prune of m for root, so that another tree
+// could be walked like m. They are nil if m is not filtered.
+func (m *Manifest) Filters(root string) (filter, prune lib.FilterSupport, err error) {
+	if m.Filter != "" {
+		if filter, err = lib.CompileFilterExpression(m.Filter, root); err != nil {
+			return nil, nil, err
+		}
+	}
+	if m.Prune != "" {
+		if prune, err = lib.CompileFilterExpression(m.Prune, root); err != nil {
+			return nil, nil, err
+		}
+	}
+	return filter, prune, nil
+}
+
+// Build walks files under root by options and return their manifest. Files
+// failed to be hashed are handled by ErrorPolicy of options.
+func Build(ctx context.Context, root string, opts Options) (*Manifest, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	iterator, err := lib.NewEntryIterator(ctx, root, opts.IteratorOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]lib.FileEntry, 0)
+	for iterator.HasNext() {
+		entry, err := iterator.NextEntry()
+		if err != nil {
+			return nil, err
+		}
+		if entry.Info.Mode().IsRegular() {
+			files = append(files, entry)
+		}
+	}
+
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	entries := make([]*Entry, len(files))
+	var (
+		mu       sync.Mutex
+		firstErr error
+	)
+	swg := concurrent.New(workers)
+	for i := range files {
+		if err := swg.AddWithContext(ctx); err != nil {
+			break
+		}
+		go func(i int) {
+			defer swg.Done()
+			file := files[i]
+			hash, err := lib.HashFileFS(opts.FS, file.Path)
+			if err == nil {
+				entries[i] = &Entry{
+					Path:  filepath.ToSlash(file.Rel),
+					Size:  file.Info.Size(),
+					Mode:  file.Info.Mode(),
+					MTime: file.Info.ModTime(),
+					Hash:  hash,
+				}
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err := opts.ErrorPolicy.Handle(
+				&lib.PathError{Op: "hash", Path: file.Path, Err: err}, opts.Report); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}(i)
+	}
+	swg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	m := &Manifest{
+		Version: manifestVersion,
+		Root:    root,
+		Created: time.Now(),
+		Filter:  filterExpression(opts.Filter),
+		Prune:   filterExpression(opts.Prune),
+		Entries: make([]*Entry, 0, len(entries)),
+	}
+	for _, entry := range entries {
+		if entry != nil {
+			m.Entries = append(m.Entries, entry)
+		}
+	}
+	sort.Slice(m.Entries, func(i, j int) bool {
+		return m.Entries[i].Path < m.Entries[j].Path
+	})
+	return m, nil
+}
+
+// Load reads manifest from file path.
+func Load(path string) (*Manifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	m := &Manifest{}
+	if err := json.NewDecoder(f).Decode(m); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if m.Version != manifestVersion {
+		return nil, fmt.Errorf("%s: unsupported manifest version %d", path, m.Version)
+	}
+	sort.Slice(m.Entries, func(i, j int) bool {
+		return m.Entries[i].Path < m.Entries[j].Path
+	})
+	return m, nil
+}
+
+// Encode writes manifest to w as indented json.
+func (m *Manifest) Encode(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(m)
+}
+
+// Save writes manifest to file path, the file is replaced atomically.
+func (m *Manifest) Save(path string) error {
+	tmp := fmt.Sprintf("%s.%d.tmp", path, os.Getpid())
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = m.Encode(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
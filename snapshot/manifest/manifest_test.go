@@ -0,0 +1,187 @@
+// Copyright © 2020 Mephis Pheies <mephistommm@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package manifest
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/MephistoMMM/magician/lib"
+)
+
+// buildTestManifest return manifest of files under /root of mfs.
+func buildTestManifest(t *testing.T, mfs *lib.MemFileSystem) *Manifest {
+	m, err := Build(context.Background(), "/root", Options{
+		IteratorOptions: lib.IteratorOptions{FS: mfs},
+		Workers:         2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// formatChanges return changes separated by ', '.
+func formatChanges(changes []Change) string {
+	lines := make([]string, 0, len(changes))
+	for _, change := range changes {
+		lines = append(lines, change.String())
+	}
+	return strings.Join(lines, ", ")
+}
+
+func TestBuild(t *testing.T) {
+	mfs, _ := lib.NewMemFileSystemWithFiles(map[string]string{
+		"/root/b.txt":     "b",
+		"/root/a/c.txt":   "cc",
+		"/root/.hidden/d": "d",
+	})
+	mfs.Symlink("b.txt", "/root/link")
+	mfs.Chmod("/root/b.txt", 0600)
+
+	m := buildTestManifest(t, mfs)
+	paths := make([]string, 0, len(m.Entries))
+	for _, entry := range m.Entries {
+		paths = append(paths, entry.Path)
+	}
+	if strings.Join(paths, " ") != ".hidden/d a/c.txt b.txt" {
+		t.Errorf("Paths of manifest are error: %v", paths)
+	}
+	b := m.Entries[2]
+	if b.Size != 1 || b.Mode != 0600 ||
+		b.Hash != "3e23e8160039594a33894f6564e1b1348bbd7a0088d42c4acb73eeaed59c009d" {
+		t.Errorf("Entry is error: %+v", b)
+	}
+}
+
+func TestBuildFilters(t *testing.T) {
+	mfs, _ := lib.NewMemFileSystemWithFiles(map[string]string{
+		"/root/a.txt":      "a",
+		"/root/b.org":      "b",
+		"/root/.hidden/c":  "c",
+		"/root/skip/d.txt": "d",
+	})
+	filter, _ := lib.CompileFilterExpression(`ext(txt) or ext(org)`, "/root")
+	prune, _ := lib.CompileFilterExpression(`not dot and not path("skip")`, "/root")
+	m, err := Build(context.Background(), "/root", Options{
+		IteratorOptions: lib.IteratorOptions{FS: mfs, Filter: filter, Prune: prune},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Filter != `(ext(txt) or ext(org))` || m.Prune != `not dot and not path("skip")` || len(m.Entries) != 2 {
+		t.Errorf("Filters of manifest are error: %q, %q, %d entries", m.Filter, m.Prune, len(m.Entries))
+	}
+
+	// the tree is walked again like m
+	filter, prune, err = m.Filters("/root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := Build(context.Background(), "/root", Options{
+		IteratorOptions: lib.IteratorOptions{FS: mfs, Filter: filter, Prune: prune},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes := Diff(m, again); len(changes) != 0 || again.Filter != m.Filter || again.Prune != m.Prune {
+		t.Errorf("Tree walked with filters of manifest is different: %s", formatChanges(changes))
+	}
+	if filter, prune, err := buildTestManifest(t, mfs).Filters("/root"); filter != nil || prune != nil || err != nil {
+		t.Errorf("Manifest without filters is error: %v, %v, %v", filter, prune, err)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	mfs, _ := lib.NewMemFileSystemWithFiles(map[string]string{
+		"/root/same.txt":      "same",
+		"/root/changed.txt":   "old",
+		"/root/mode.txt":      "mode",
+		"/root/moved.txt":     "moved",
+		"/root/renamed.txt":   "renamed",
+		"/root/removed.txt":   "removed",
+		"/root/copy/a.txt":    "copy",
+		"/root/copy/b.txt":    "copy",
+		"/root/copy/gone.txt": "copy",
+	})
+	old := buildTestManifest(t, mfs)
+
+	lib.WriteFileFS(mfs, "/root/changed.txt", []byte("new"))
+	mfs.Chmod("/root/mode.txt", 0600)
+	mfs.MkdirAll("/root/dir", 0755)
+	mfs.Rename("/root/moved.txt", "/root/dir/moved.txt")
+	mfs.Rename("/root/renamed.txt", "/root/dir/other.txt")
+	mfs.Remove("/root/removed.txt")
+	mfs.Rename("/root/copy/b.txt", "/root/dir/b.txt")
+	mfs.Remove("/root/copy/gone.txt")
+	lib.WriteFileFS(mfs, "/root/added.txt", []byte("added"))
+	lib.WriteFileFS(mfs, "/root/dir/c.txt", []byte("copy"))
+	new := buildTestManifest(t, mfs)
+
+	expected := strings.Join([]string{
+		"A added.txt",
+		"M changed.txt",
+		"R copy/b.txt -> dir/b.txt",
+		"R copy/gone.txt -> dir/c.txt",
+		"R moved.txt -> dir/moved.txt",
+		"R renamed.txt -> dir/other.txt",
+		"M mode.txt",
+		"D removed.txt",
+	}, ", ")
+	if got := formatChanges(Diff(old, new)); got != expected {
+		t.Errorf("Diff is error:\n%s\nexpected:\n%s", got, expected)
+	}
+	if changes := Diff(new, new); len(changes) != 0 {
+		t.Errorf("Diff of the same manifest should be empty: %s", formatChanges(changes))
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mfs, _ := lib.NewMemFileSystemWithFiles(map[string]string{
+		"/root/a.txt": "a", "/root/b/c.txt": "c",
+	})
+	m := buildTestManifest(t, mfs)
+	path := filepath.Join(dir, "manifest.json")
+	if err := m.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Root != "/root" || len(loaded.Entries) != 2 || len(Diff(m, loaded)) != 0 {
+		t.Errorf("Loaded manifest is error: %+v", loaded)
+	}
+
+	lib.WriteFile(path, []byte(`{"version": 99}`))
+	if _, err := Load(path); err == nil {
+		t.Error("Loading manifest of unknown version should fail.")
+	}
+}